@@ -8,13 +8,13 @@ import (
 	"os/signal"
 	"time"
 
-	"github.com/a-h/templ"
 	"github.com/go-chi/chi/v5"
-	"github.com/go-chi/cors"
 	"github.com/joho/godotenv"
 	"github.com/lescuer97/nostr-oicd/internal/auth"
 	"github.com/lescuer97/nostr-oicd/internal/config"
 	"github.com/lescuer97/nostr-oicd/internal/database"
+	"github.com/lescuer97/nostr-oicd/internal/middleware"
+	"github.com/lescuer97/nostr-oicd/internal/oidc"
 	pages "github.com/lescuer97/nostr-oicd/templates/pages"
 	_ "github.com/mattn/go-sqlite3"
 )
@@ -29,6 +29,8 @@ func main() {
 
 	// Load config from environment
 	cfg := config.LoadFromEnv()
+	// forwarding headers are only believed from these peers when rate limiting by client IP
+	middleware.TrustProxies(cfg.TrustedProxies)
 
 	// Open DB using our helper
 	db, err := database.Open(cfg.DatabasePath)
@@ -44,23 +46,37 @@ func main() {
 
 	r := chi.NewRouter()
 
-	// CORS for development
-	r.Use(cors.Handler(cors.Options{
-		AllowedOrigins:   []string{"https://*", "http://*"},
-		AllowedMethods:   []string{http.MethodGet, http.MethodPost, http.MethodPut, http.MethodDelete, http.MethodOptions},
-		AllowedHeaders:   []string{"Accept", "Authorization", "Content-Type", "X-CSRF-Token"},
-		AllowCredentials: true,
-		MaxAge:           300,
-	}))
+	// CORS, without credentials, only on the endpoints browser-based clients call directly
+	r.Use(oidc.CORS())
 
 	// Register auth routes
 	auth.RegisterRoutes(r, cfg, db)
 
+	// Load (or generate) the token signing keys and keep rotating them in the background
+	keys, err := oidc.NewKeySet(context.Background(), cfg, db)
+	if err != nil {
+		log.Fatalf("failed to load signing keys: %v", err)
+	}
+	rotateCtx, stopRotation := context.WithCancel(context.Background())
+	defer stopRotation()
+	go keys.Run(rotateCtx)
+
+	// Deliver back-channel logout notifications queued when sessions end
+	go oidc.NewLogoutNotifier(cfg, db, keys).Run(rotateCtx)
+
+	// Register OpenID Connect provider routes (discovery, authorize, token, ...)
+	oidc.RegisterRoutes(r, cfg, db, keys)
+
 	// Static file server
 	r.Handle("/static/*", http.StripPrefix("/static/", http.FileServer(http.Dir("./static"))))
 
 	// Templ pages (make sure to run `templ generate` before running the server)
-	r.Handle("/login", templ.Handler(pages.LoginPage()))
+	r.Get("/login", func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "text/html; charset=utf-8")
+		if err := pages.LoginPage(r.URL.Query().Get("next"), r.URL.Query().Get("login_hint")).Render(r.Context(), w); err != nil {
+			http.Error(w, "failed to render", http.StatusInternalServerError)
+		}
+	})
 	// TODO: add signup/dashboard templates and mount them here when available
 
 	// Basic routes (placeholders)
@@ -0,0 +1,24 @@
+-- migrate:up
+ALTER TABLE clients ADD COLUMN client_secret_hash TEXT NOT NULL DEFAULT '';
+
+-- migrate:up
+-- client_secret_basic | client_secret_post | none (public client)
+ALTER TABLE clients ADD COLUMN token_endpoint_auth_method TEXT NOT NULL DEFAULT 'client_secret_basic';
+
+-- migrate:up
+CREATE TABLE IF NOT EXISTS access_tokens (
+    id INTEGER PRIMARY KEY AUTOINCREMENT,
+    token_hash TEXT UNIQUE NOT NULL,
+    client_id TEXT NOT NULL,
+    user_id INTEGER,
+    session_id INTEGER,
+    authorization_code_id INTEGER,
+    scope TEXT NOT NULL,
+    created_at INTEGER NOT NULL,
+    expires_at INTEGER NOT NULL,
+    active BOOLEAN DEFAULT TRUE,
+    FOREIGN KEY (client_id) REFERENCES clients (client_id),
+    FOREIGN KEY (user_id) REFERENCES users (id),
+    FOREIGN KEY (session_id) REFERENCES sessions (id),
+    FOREIGN KEY (authorization_code_id) REFERENCES authorization_codes (id)
+);
@@ -0,0 +1,13 @@
+-- migrate:up
+-- Asymmetric keys used to sign ID tokens. A key is pending while activates_at is in the
+-- future (already published in the JWKS), signs once activated, and stays published after
+-- retired_at until the grace period ends.
+CREATE TABLE IF NOT EXISTS signing_keys (
+    id INTEGER PRIMARY KEY AUTOINCREMENT,
+    kid TEXT UNIQUE NOT NULL,
+    alg TEXT NOT NULL,
+    private_key BLOB NOT NULL,
+    created_at INTEGER NOT NULL,
+    activates_at INTEGER NOT NULL,
+    retired_at INTEGER
+);
@@ -0,0 +1,9 @@
+-- migrate:up
+ALTER TABLE clients ADD COLUMN require_pkce BOOLEAN NOT NULL DEFAULT FALSE;
+
+-- migrate:up
+ALTER TABLE authorization_codes ADD COLUMN code_challenge TEXT NOT NULL DEFAULT '';
+
+-- migrate:up
+-- S256 | plain (empty when the request did not use PKCE)
+ALTER TABLE authorization_codes ADD COLUMN code_challenge_method TEXT NOT NULL DEFAULT '';
@@ -0,0 +1,10 @@
+-- migrate:up
+-- Latest verified kind-0 (profile metadata) event per user, used for OIDC profile claims.
+CREATE TABLE IF NOT EXISTS profiles (
+    user_id INTEGER PRIMARY KEY,
+    event_id TEXT NOT NULL,
+    content TEXT NOT NULL,
+    event_created_at INTEGER NOT NULL,
+    fetched_at INTEGER NOT NULL,
+    FOREIGN KEY (user_id) REFERENCES users (id)
+);
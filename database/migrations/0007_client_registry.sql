@@ -0,0 +1,12 @@
+-- migrate:up
+ALTER TABLE clients ADD COLUMN grant_types TEXT NOT NULL DEFAULT 'authorization_code';
+
+-- migrate:up
+ALTER TABLE clients ADD COLUMN logo_uri TEXT NOT NULL DEFAULT '';
+
+-- migrate:up
+-- token lifetimes in seconds, 0 uses the provider default
+ALTER TABLE clients ADD COLUMN access_token_ttl INTEGER NOT NULL DEFAULT 0;
+
+-- migrate:up
+ALTER TABLE clients ADD COLUMN id_token_ttl INTEGER NOT NULL DEFAULT 0;
@@ -0,0 +1,18 @@
+-- migrate:up
+-- HMAC of the RFC 7592 registration access token; empty for clients created by an admin
+ALTER TABLE clients ADD COLUMN registration_token_hash TEXT NOT NULL DEFAULT '';
+
+-- migrate:up
+ALTER TABLE clients ADD COLUMN software_id TEXT NOT NULL DEFAULT '';
+
+-- migrate:up
+CREATE TABLE IF NOT EXISTS initial_access_tokens (
+    id INTEGER PRIMARY KEY AUTOINCREMENT,
+    token_hash TEXT UNIQUE NOT NULL,
+    label TEXT NOT NULL DEFAULT '',
+    created_by INTEGER,
+    created_at INTEGER NOT NULL,
+    expires_at INTEGER NOT NULL,
+    active BOOLEAN DEFAULT TRUE,
+    FOREIGN KEY (created_by) REFERENCES users (id)
+);
@@ -0,0 +1,32 @@
+-- migrate:up
+-- refresh token lifetime in seconds, 0 uses the provider default
+ALTER TABLE clients ADD COLUMN refresh_token_ttl INTEGER NOT NULL DEFAULT 0;
+
+-- migrate:up
+-- Refresh tokens are rotated on every use. All tokens descending from one authorization
+-- code share a family_id so a replayed (used) token can revoke the whole family.
+CREATE TABLE IF NOT EXISTS refresh_tokens (
+    id INTEGER PRIMARY KEY AUTOINCREMENT,
+    token_hash TEXT UNIQUE NOT NULL,
+    family_id TEXT NOT NULL,
+    parent_id INTEGER,
+    client_id TEXT NOT NULL,
+    user_id INTEGER NOT NULL,
+    authorization_code_id INTEGER,
+    scope TEXT NOT NULL,
+    created_at INTEGER NOT NULL,
+    expires_at INTEGER NOT NULL,
+    used BOOLEAN DEFAULT FALSE,
+    active BOOLEAN DEFAULT TRUE,
+    FOREIGN KEY (client_id) REFERENCES clients (client_id),
+    FOREIGN KEY (user_id) REFERENCES users (id),
+    FOREIGN KEY (parent_id) REFERENCES refresh_tokens (id),
+    FOREIGN KEY (authorization_code_id) REFERENCES authorization_codes (id)
+);
+
+-- migrate:up
+CREATE INDEX IF NOT EXISTS idx_refresh_tokens_family ON refresh_tokens (family_id);
+
+-- migrate:up
+-- refresh token family the access token was issued in, empty when none
+ALTER TABLE access_tokens ADD COLUMN refresh_family_id TEXT NOT NULL DEFAULT '';
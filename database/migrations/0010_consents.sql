@@ -0,0 +1,16 @@
+-- migrate:up
+-- first-party clients are trusted and never show the consent screen
+ALTER TABLE clients ADD COLUMN first_party BOOLEAN NOT NULL DEFAULT 0;
+
+-- migrate:up
+CREATE TABLE IF NOT EXISTS consents (
+    id INTEGER PRIMARY KEY AUTOINCREMENT,
+    user_id INTEGER NOT NULL,
+    client_id TEXT NOT NULL,
+    scopes TEXT NOT NULL,
+    created_at INTEGER NOT NULL,
+    updated_at INTEGER NOT NULL,
+    UNIQUE (user_id, client_id),
+    FOREIGN KEY (user_id) REFERENCES users (id),
+    FOREIGN KEY (client_id) REFERENCES clients (client_id)
+);
@@ -0,0 +1,3 @@
+-- migrate:up
+-- last time tokens were issued under the consent, 0 when never
+ALTER TABLE consents ADD COLUMN last_used_at INTEGER NOT NULL DEFAULT 0;
@@ -0,0 +1,3 @@
+-- migrate:up
+-- space-separated URIs the end_session endpoint may redirect to after logout
+ALTER TABLE clients ADD COLUMN post_logout_redirect_uris TEXT NOT NULL DEFAULT '';
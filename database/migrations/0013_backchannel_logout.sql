@@ -0,0 +1,36 @@
+-- migrate:up
+-- URL that receives logout tokens when a session the client took part in ends
+ALTER TABLE clients ADD COLUMN backchannel_logout_uri TEXT NOT NULL DEFAULT '';
+
+-- migrate:up
+-- clients that were issued tokens in a browser session
+CREATE TABLE IF NOT EXISTS session_clients (
+    session_id INTEGER NOT NULL,
+    client_id TEXT NOT NULL,
+    created_at INTEGER NOT NULL,
+    PRIMARY KEY (session_id, client_id),
+    FOREIGN KEY (session_id) REFERENCES sessions (id),
+    FOREIGN KEY (client_id) REFERENCES clients (client_id)
+);
+
+-- migrate:up
+-- back-channel logout notifications; status is pending, delivered or failed
+CREATE TABLE IF NOT EXISTS logout_deliveries (
+    id INTEGER PRIMARY KEY AUTOINCREMENT,
+    session_id INTEGER NOT NULL,
+    user_id INTEGER NOT NULL,
+    client_id TEXT NOT NULL,
+    uri TEXT NOT NULL,
+    jti TEXT NOT NULL UNIQUE,
+    status TEXT NOT NULL DEFAULT 'pending',
+    attempts INTEGER NOT NULL DEFAULT 0,
+    last_error TEXT NOT NULL DEFAULT '',
+    next_attempt_at INTEGER NOT NULL,
+    created_at INTEGER NOT NULL,
+    delivered_at INTEGER,
+    FOREIGN KEY (session_id) REFERENCES sessions (id),
+    FOREIGN KEY (user_id) REFERENCES users (id)
+);
+
+-- migrate:up
+CREATE INDEX IF NOT EXISTS idx_logout_deliveries_due ON logout_deliveries (status, next_attempt_at);
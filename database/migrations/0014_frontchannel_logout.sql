@@ -0,0 +1,3 @@
+-- migrate:up
+-- URL loaded in a hidden iframe, with iss and sid, when a session the client took part in ends
+ALTER TABLE clients ADD COLUMN frontchannel_logout_uri TEXT NOT NULL DEFAULT '';
@@ -0,0 +1,21 @@
+-- migrate:up
+-- Device authorization grant (RFC 8628). Both codes are stored as HMACs. status is pending
+-- until the user approves or denies it on /device, and used once tokens were issued.
+CREATE TABLE IF NOT EXISTS device_authorizations (
+    id INTEGER PRIMARY KEY AUTOINCREMENT,
+    device_code_hash TEXT UNIQUE NOT NULL,
+    user_code_hash TEXT NOT NULL,
+    client_id TEXT NOT NULL,
+    scope TEXT NOT NULL,
+    user_id INTEGER,
+    status TEXT NOT NULL DEFAULT 'pending',
+    poll_interval INTEGER NOT NULL,
+    last_polled_at INTEGER,
+    created_at INTEGER NOT NULL,
+    expires_at INTEGER NOT NULL,
+    FOREIGN KEY (client_id) REFERENCES clients (client_id),
+    FOREIGN KEY (user_id) REFERENCES users (id)
+);
+
+-- migrate:up
+CREATE INDEX IF NOT EXISTS idx_device_authorizations_user_code ON device_authorizations (user_code_hash);
@@ -0,0 +1,18 @@
+-- migrate:up
+-- clients that must push their authorization requests to /par first (RFC 9126 section 6)
+ALTER TABLE clients ADD COLUMN require_par BOOLEAN NOT NULL DEFAULT 0;
+
+-- migrate:up
+-- Pushed authorization requests (RFC 9126). params is the form-encoded request; the
+-- request_uri handed to the client is stored as an HMAC and used once an authorization
+-- code was issued for it.
+CREATE TABLE IF NOT EXISTS pushed_requests (
+    id INTEGER PRIMARY KEY AUTOINCREMENT,
+    request_uri_hash TEXT UNIQUE NOT NULL,
+    client_id TEXT NOT NULL,
+    params TEXT NOT NULL,
+    created_at INTEGER NOT NULL,
+    expires_at INTEGER NOT NULL,
+    used BOOLEAN DEFAULT FALSE,
+    FOREIGN KEY (client_id) REFERENCES clients (client_id)
+);
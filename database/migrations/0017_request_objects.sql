@@ -0,0 +1,14 @@
+-- migrate:up
+-- client public keys for signed request objects (RFC 9101): an inline JWK set or a URL serving one
+ALTER TABLE clients ADD COLUMN jwks TEXT NOT NULL DEFAULT '';
+
+-- migrate:up
+ALTER TABLE clients ADD COLUMN jwks_uri TEXT NOT NULL DEFAULT '';
+
+-- migrate:up
+-- space-separated URLs the provider may fetch request objects from (OIDC Core section 6.2)
+ALTER TABLE clients ADD COLUMN request_uris TEXT NOT NULL DEFAULT '';
+
+-- migrate:up
+-- clients whose authorization requests must come as a signed request object
+ALTER TABLE clients ADD COLUMN require_signed_request_object BOOLEAN NOT NULL DEFAULT 0;
@@ -0,0 +1,7 @@
+-- migrate:up
+-- JWK thumbprint (RFC 7638) of the DPoP key an access token is bound to (RFC 9449 section 6); empty for bearer tokens
+ALTER TABLE access_tokens ADD COLUMN dpop_jkt TEXT NOT NULL DEFAULT '';
+
+-- migrate:up
+-- refresh tokens of public clients are bound to the DPoP key too (RFC 9449 section 5)
+ALTER TABLE refresh_tokens ADD COLUMN dpop_jkt TEXT NOT NULL DEFAULT '';
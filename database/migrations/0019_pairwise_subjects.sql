@@ -0,0 +1,7 @@
+-- migrate:up
+-- public: sub is the hex pubkey; pairwise: sub is derived per sector (OIDC Core section 8)
+ALTER TABLE clients ADD COLUMN subject_type TEXT NOT NULL DEFAULT 'public';
+
+-- migrate:up
+-- URL of a JSON array of redirect URIs; its host is the sector shared by the clients listing it
+ALTER TABLE clients ADD COLUMN sector_identifier_uri TEXT NOT NULL DEFAULT '';
@@ -0,0 +1,7 @@
+-- migrate:up
+-- when the user signed in to the session the code was issued in (OIDC Core auth_time)
+ALTER TABLE authorization_codes ADD COLUMN auth_time INTEGER NOT NULL DEFAULT 0;
+
+-- migrate:up
+-- carried along refresh token rotation so refreshed ID tokens keep the original auth_time
+ALTER TABLE refresh_tokens ADD COLUMN auth_time INTEGER NOT NULL DEFAULT 0;
@@ -0,0 +1,3 @@
+-- migrate:up
+-- comma-separated response types the client may use at /authorize; implicit and hybrid ones are opt-in
+ALTER TABLE clients ADD COLUMN response_types TEXT NOT NULL DEFAULT 'code';
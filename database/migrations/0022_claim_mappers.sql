@@ -0,0 +1,42 @@
+-- migrate:up
+-- Admin-configured claims: claim is released from source when scope is granted, in the ID
+-- token and/or at userinfo by default, or wherever the client asks for it with the claims parameter.
+CREATE TABLE IF NOT EXISTS claim_mappers (
+    id INTEGER PRIMARY KEY AUTOINCREMENT,
+    claim TEXT UNIQUE NOT NULL,
+    source TEXT NOT NULL,
+    scope TEXT NOT NULL,
+    id_token BOOLEAN NOT NULL DEFAULT 0,
+    userinfo BOOLEAN NOT NULL DEFAULT 1,
+    created_at INTEGER NOT NULL
+);
+
+-- migrate:up
+-- local roles, released through claim mappers with the roles source
+CREATE TABLE IF NOT EXISTS user_roles (
+    user_id INTEGER NOT NULL,
+    role TEXT NOT NULL,
+    created_at INTEGER NOT NULL,
+    PRIMARY KEY (user_id, role),
+    FOREIGN KEY (user_id) REFERENCES users (id)
+);
+
+-- migrate:up
+-- the NIP-05 identifier of the stored profile, once its domain confirmed it names the user's key
+ALTER TABLE profiles ADD COLUMN nip05_verified TEXT NOT NULL DEFAULT '';
+
+-- migrate:up
+-- when the NIP-05 identifier was last checked; 0 until it is checked or after the profile changed
+ALTER TABLE profiles ADD COLUMN nip05_checked_at INTEGER NOT NULL DEFAULT 0;
+
+-- migrate:up
+-- the claims parameter of the authorization request (OIDC Core section 5.5), as compact JSON
+ALTER TABLE authorization_codes ADD COLUMN claims TEXT NOT NULL DEFAULT '';
+
+-- migrate:up
+-- requested claims carried to userinfo
+ALTER TABLE access_tokens ADD COLUMN claims TEXT NOT NULL DEFAULT '';
+
+-- migrate:up
+-- requested claims carried along refresh token rotation
+ALTER TABLE refresh_tokens ADD COLUMN claims TEXT NOT NULL DEFAULT '';
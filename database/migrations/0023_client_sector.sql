@@ -0,0 +1,4 @@
+-- migrate:up
+-- the pairwise sector fixed when the client was registered, so later redirect URI edits
+-- cannot move it; empty for public clients and clients registered before this column
+ALTER TABLE clients ADD COLUMN sector_identifier TEXT NOT NULL DEFAULT '';
@@ -0,0 +1,4 @@
+-- migrate:up
+-- when /authorize first resolved the request_uri; from then on expires_at covers login and
+-- consent instead of the short window for bringing the browser to /authorize
+ALTER TABLE pushed_requests ADD COLUMN resolved_at INTEGER NOT NULL DEFAULT 0;
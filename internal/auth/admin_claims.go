@@ -0,0 +1,210 @@
+package auth
+
+import (
+	"database/sql"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log/slog"
+	"net/http"
+	"regexp"
+	"strconv"
+	"strings"
+
+	"github.com/go-chi/chi/v5"
+	"github.com/lescuer97/nostr-oicd/internal/config"
+	"github.com/lescuer97/nostr-oicd/internal/models"
+	"github.com/lescuer97/nostr-oicd/internal/oidc"
+	"github.com/lescuer97/nostr-oicd/internal/ui"
+	"github.com/lescuer97/nostr-oicd/templates/fragments"
+)
+
+// roleName is the shape of local role names.
+var roleName = regexp.MustCompile(`^[A-Za-z0-9][A-Za-z0-9_.:-]{0,63}$`)
+
+// renderClaims renders the claims panel: claim mappers, role assignments and the token preview form.
+func renderClaims(w http.ResponseWriter, r *http.Request, db *sql.DB) {
+	mappers, err := models.ListClaimMappers(r.Context(), db)
+	if err != nil {
+		_ = ui.RenderSnackbar(r.Context(), w, fmt.Sprintf("failed to list claim mappers: %v", err), "error", "5s")
+		return
+	}
+	roles, err := models.ListUserRoles(r.Context(), db)
+	if err != nil {
+		_ = ui.RenderSnackbar(r.Context(), w, fmt.Sprintf("failed to list roles: %v", err), "error", "5s")
+		return
+	}
+	clients, err := models.ListClients(r.Context(), db)
+	if err != nil {
+		_ = ui.RenderSnackbar(r.Context(), w, fmt.Sprintf("failed to list clients: %v", err), "error", "5s")
+		return
+	}
+	w.Header().Set("Content-Type", "text/html; charset=utf-8")
+	_ = fragments.AdminClaims(mappers, roles, clients, oidc.ClaimSources, oidc.ScopesSupported).Render(r.Context(), w)
+}
+
+// userFromNpub returns the existing user the npub field of the form names.
+func userFromNpub(r *http.Request, db *sql.DB) (*models.User, error) {
+	pubHex, err := decodeNpubToHex(r.FormValue("npub"))
+	if err != nil {
+		return nil, fmt.Errorf("invalid npub: %v", err)
+	}
+	id, err := models.GetUserByPubKey(r.Context(), db, pubHex)
+	if err == sql.ErrNoRows {
+		return nil, errors.New("no user with this npub; add the user first")
+	}
+	if err != nil {
+		return nil, err
+	}
+	return models.GetUserByID(r.Context(), db, id)
+}
+
+// AdminClaims renders the claims panel.
+func AdminClaims(db *sql.DB) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		renderClaims(w, r, db)
+	}
+}
+
+// AdminCreateClaimMapper adds a claim mapper from the form.
+func AdminCreateClaimMapper(db *sql.DB) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		ctx := r.Context()
+		if err := r.ParseForm(); err != nil {
+			_ = ui.RenderSnackbar(ctx, w, "invalid form", "error", "5s")
+			return
+		}
+		m := &models.ClaimMapper{
+			Claim:    strings.TrimSpace(r.FormValue("claim")),
+			Source:   r.FormValue("source"),
+			Scope:    r.FormValue("scope"),
+			IDToken:  r.FormValue("id_token") == "on",
+			UserInfo: r.FormValue("userinfo") == "on",
+		}
+		if err := oidc.ValidateClaimMapper(m); err != nil {
+			_ = ui.RenderSnackbar(ctx, w, err.Error(), "error", "5s")
+			return
+		}
+		existing, err := models.ListClaimMappers(ctx, db)
+		if err != nil {
+			_ = ui.RenderSnackbar(ctx, w, fmt.Sprintf("failed to list claim mappers: %v", err), "error", "5s")
+			return
+		}
+		for _, e := range existing {
+			if e.Claim == m.Claim {
+				_ = ui.RenderSnackbar(ctx, w, fmt.Sprintf("claim %q is already mapped", m.Claim), "error", "5s")
+				return
+			}
+		}
+		id, err := models.CreateClaimMapper(ctx, db, m)
+		if err != nil {
+			_ = ui.RenderSnackbar(ctx, w, fmt.Sprintf("failed to create claim mapper: %v", err), "error", "5s")
+			slog.Error("admin_create_claim_mapper_failed", "admin", adminPubKey(r), "claim", m.Claim, "error", err.Error())
+			return
+		}
+		slog.Info("admin_create_claim_mapper", "admin", adminPubKey(r), "remote", r.RemoteAddr, "mapper_id", id, "claim", m.Claim, "source", m.Source, "scope", m.Scope)
+		renderClaims(w, r, db)
+	}
+}
+
+// AdminDeleteClaimMapper removes a claim mapper.
+func AdminDeleteClaimMapper(db *sql.DB) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		id, err := strconv.ParseInt(chi.URLParam(r, "id"), 10, 64)
+		if err != nil {
+			_ = ui.RenderSnackbar(r.Context(), w, "claim mapper not found", "error", "5s")
+			return
+		}
+		if err := models.DeleteClaimMapper(r.Context(), db, id); err != nil {
+			_ = ui.RenderSnackbar(r.Context(), w, fmt.Sprintf("failed to delete claim mapper: %v", err), "error", "5s")
+			slog.Error("admin_delete_claim_mapper_failed", "admin", adminPubKey(r), "mapper_id", id, "error", err.Error())
+			return
+		}
+		slog.Info("admin_delete_claim_mapper", "admin", adminPubKey(r), "remote", r.RemoteAddr, "mapper_id", id)
+		renderClaims(w, r, db)
+	}
+}
+
+// AdminAddUserRole assigns a local role to the user named by npub.
+func AdminAddUserRole(db *sql.DB) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		ctx := r.Context()
+		if err := r.ParseForm(); err != nil {
+			_ = ui.RenderSnackbar(ctx, w, "invalid form", "error", "5s")
+			return
+		}
+		role := strings.TrimSpace(r.FormValue("role"))
+		if !roleName.MatchString(role) {
+			_ = ui.RenderSnackbar(ctx, w, "role names are letters, digits, '_', '.', ':' and '-'", "error", "5s")
+			return
+		}
+		user, err := userFromNpub(r, db)
+		if err != nil {
+			_ = ui.RenderSnackbar(ctx, w, err.Error(), "error", "5s")
+			return
+		}
+		if err := models.AddUserRole(ctx, db, user.ID, role); err != nil {
+			_ = ui.RenderSnackbar(ctx, w, fmt.Sprintf("failed to assign role: %v", err), "error", "5s")
+			slog.Error("admin_add_user_role_failed", "admin", adminPubKey(r), "user_id", user.ID, "role", role, "error", err.Error())
+			return
+		}
+		slog.Info("admin_add_user_role", "admin", adminPubKey(r), "remote", r.RemoteAddr, "user_id", user.ID, "role", role)
+		renderClaims(w, r, db)
+	}
+}
+
+// AdminRemoveUserRole takes a local role away from a user.
+func AdminRemoveUserRole(db *sql.DB) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		ctx := r.Context()
+		if err := r.ParseForm(); err != nil {
+			_ = ui.RenderSnackbar(ctx, w, "invalid form", "error", "5s")
+			return
+		}
+		userID, err := strconv.ParseInt(r.FormValue("user_id"), 10, 64)
+		if err != nil {
+			_ = ui.RenderSnackbar(ctx, w, "user not found", "error", "5s")
+			return
+		}
+		role := r.FormValue("role")
+		if err := models.RemoveUserRole(ctx, db, userID, role); err != nil {
+			_ = ui.RenderSnackbar(ctx, w, fmt.Sprintf("failed to remove role: %v", err), "error", "5s")
+			slog.Error("admin_remove_user_role_failed", "admin", adminPubKey(r), "user_id", userID, "role", role, "error", err.Error())
+			return
+		}
+		slog.Info("admin_remove_user_role", "admin", adminPubKey(r), "remote", r.RemoteAddr, "user_id", userID, "role", role)
+		renderClaims(w, r, db)
+	}
+}
+
+// AdminPreviewClaims shows the ID token payload and userinfo response a client would get for a
+// user with the given scope and claims parameter. Nothing is issued.
+func AdminPreviewClaims(cfg *config.Config, db *sql.DB) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		ctx := r.Context()
+		if err := r.ParseForm(); err != nil {
+			_ = ui.RenderSnackbar(ctx, w, "invalid form", "error", "5s")
+			return
+		}
+		user, err := userFromNpub(r, db)
+		if err != nil {
+			_ = ui.RenderSnackbar(ctx, w, err.Error(), "error", "5s")
+			return
+		}
+		client, err := models.GetClientByClientID(ctx, db, r.FormValue("client_id"))
+		if err != nil {
+			_ = ui.RenderSnackbar(ctx, w, "client not found", "error", "5s")
+			return
+		}
+		preview, err := oidc.PreviewClaims(ctx, cfg, db, client, user, r.FormValue("scope"), strings.TrimSpace(r.FormValue("claims")))
+		if err != nil {
+			_ = ui.RenderSnackbar(ctx, w, err.Error(), "error", "5s")
+			return
+		}
+		idToken, _ := json.MarshalIndent(preview.IDToken, "", "  ")
+		userInfo, _ := json.MarshalIndent(preview.UserInfo, "", "  ")
+		slog.Info("admin_preview_claims", "admin", adminPubKey(r), "remote", r.RemoteAddr, "user_id", user.ID, "client_id", client.ClientID)
+		w.Header().Set("Content-Type", "text/html; charset=utf-8")
+		_ = fragments.AdminClaimsPreview(string(idToken), string(userInfo)).Render(ctx, w)
+	}
+}
@@ -0,0 +1,376 @@
+package auth
+
+import (
+	"database/sql"
+	"errors"
+	"fmt"
+	"log/slog"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/go-chi/chi/v5"
+	"github.com/lescuer97/nostr-oicd/internal/config"
+	"github.com/lescuer97/nostr-oicd/internal/middleware"
+	"github.com/lescuer97/nostr-oicd/internal/models"
+	"github.com/lescuer97/nostr-oicd/internal/oidc"
+	"github.com/lescuer97/nostr-oicd/internal/ui"
+	"github.com/lescuer97/nostr-oicd/templates/fragments"
+)
+
+// adminPubKey returns the public key of the admin in the request context, for audit logs.
+func adminPubKey(r *http.Request) string {
+	if user, ok := r.Context().Value(middleware.ContextUserKey).(*models.User); ok {
+		return user.PublicKey
+	}
+	return ""
+}
+
+// clientFromForm applies the admin client form to c. Errors are meant to be shown to the admin.
+func clientFromForm(r *http.Request, c *models.Client) error {
+	// the client as saved, for the checks that depend on what changes
+	var prev *models.Client
+	if c.ID != 0 {
+		saved := *c
+		prev = &saved
+	}
+
+	c.Name = strings.TrimSpace(r.FormValue("name"))
+	if c.Name == "" {
+		return errors.New("name is required")
+	}
+
+	c.RedirectURIs = strings.Fields(r.FormValue("redirect_uris"))
+	for _, raw := range c.RedirectURIs {
+		if err := oidc.ValidateRedirectURI(raw); err != nil {
+			return err
+		}
+	}
+	c.PostLogoutRedirectURIs = strings.Fields(r.FormValue("post_logout_redirect_uris"))
+	for _, raw := range c.PostLogoutRedirectURIs {
+		if err := oidc.ValidatePostLogoutRedirectURI(raw); err != nil {
+			return err
+		}
+	}
+	c.BackchannelLogoutURI = strings.TrimSpace(r.FormValue("backchannel_logout_uri"))
+	if err := oidc.ValidateBackchannelLogoutURI(c.BackchannelLogoutURI); err != nil {
+		return err
+	}
+	c.FrontchannelLogoutURI = strings.TrimSpace(r.FormValue("frontchannel_logout_uri"))
+	if err := oidc.ValidateFrontchannelLogoutURI(c.FrontchannelLogoutURI); err != nil {
+		return err
+	}
+
+	c.JWKS = strings.TrimSpace(r.FormValue("jwks"))
+	c.JWKSURI = strings.TrimSpace(r.FormValue("jwks_uri"))
+	if err := oidc.ValidateClientKeys(c.JWKS, c.JWKSURI); err != nil {
+		return err
+	}
+	c.RequestURIs = strings.Fields(r.FormValue("request_uris"))
+	if err := oidc.ValidateRequestURIs(c.RequestURIs); err != nil {
+		return err
+	}
+
+	c.SubjectType = r.FormValue("subject_type")
+	c.SectorIdentifierURI = strings.TrimSpace(r.FormValue("sector_identifier_uri"))
+	if err := oidc.ValidateSubjectType(r.Context(), c, prev); err != nil {
+		return err
+	}
+
+	c.Scopes = strings.Fields(r.FormValue("scopes"))
+	if !contains(c.Scopes, "openid") {
+		return errors.New("allowed scopes must include openid")
+	}
+
+	c.GrantTypes = r.Form["grant_types"]
+	if len(c.GrantTypes) == 0 {
+		return errors.New("select at least one grant type")
+	}
+	for _, g := range c.GrantTypes {
+		if !contains(oidc.GrantTypesSupported, g) {
+			return fmt.Errorf("unsupported grant type %q", g)
+		}
+	}
+	if contains(c.GrantTypes, "authorization_code") && len(c.RedirectURIs) == 0 {
+		return errors.New("the authorization_code grant needs at least one redirect URI")
+	}
+
+	c.ResponseTypes = r.Form["response_types"]
+	usesCode := false
+	for _, rt := range c.ResponseTypes {
+		if !contains(oidc.ResponseTypesSupported, rt) {
+			return fmt.Errorf("unsupported response type %q", rt)
+		}
+		if contains(strings.Fields(rt), "code") {
+			usesCode = true
+		} else if len(c.RedirectURIs) == 0 {
+			return fmt.Errorf("the %s response type needs at least one redirect URI", rt)
+		}
+	}
+	if usesCode && !contains(c.GrantTypes, "authorization_code") {
+		return errors.New("response types with code need the authorization_code grant")
+	}
+	if contains(c.GrantTypes, "authorization_code") && !usesCode {
+		return errors.New("the authorization_code grant needs a response type with code")
+	}
+
+	c.TokenEndpointAuthMethod = r.FormValue("token_endpoint_auth_method")
+	if !contains(oidc.TokenEndpointAuthMethods, c.TokenEndpointAuthMethod) {
+		return errors.New("invalid token endpoint authentication method")
+	}
+	if contains(c.GrantTypes, "client_credentials") && c.TokenEndpointAuthMethod == "none" {
+		return errors.New("the client_credentials grant needs a confidential client")
+	}
+	c.RequirePKCE = r.FormValue("require_pkce") != ""
+	c.RequirePAR = r.FormValue("require_par") != ""
+	c.RequireSignedRequestObject = r.FormValue("require_signed_request_object") != ""
+	if c.RequireSignedRequestObject && c.JWKS == "" && c.JWKSURI == "" {
+		return errors.New("signed request objects need the client's JWKS or JWKS URI")
+	}
+	c.FirstParty = r.FormValue("first_party") != ""
+
+	c.LogoURI = strings.TrimSpace(r.FormValue("logo_uri"))
+	if c.LogoURI != "" {
+		if u, err := url.Parse(c.LogoURI); err != nil || (u.Scheme != "https" && u.Scheme != "http") {
+			return errors.New("logo URI must be an http(s) URL")
+		}
+	}
+
+	var err error
+	if c.AccessTokenTTL, err = ttlFromForm(r, "access_token_ttl"); err != nil {
+		return err
+	}
+	if c.IDTokenTTL, err = ttlFromForm(r, "id_token_ttl"); err != nil {
+		return err
+	}
+	if c.RefreshTokenTTL, err = ttlFromForm(r, "refresh_token_ttl"); err != nil {
+		return err
+	}
+	if contains(c.Scopes, "offline_access") && !contains(c.GrantTypes, "refresh_token") {
+		return errors.New("the offline_access scope needs the refresh_token grant type")
+	}
+	return nil
+}
+
+// ttlFromForm parses a lifetime in seconds; empty means the provider default (0).
+func ttlFromForm(r *http.Request, field string) (time.Duration, error) {
+	v := strings.TrimSpace(r.FormValue(field))
+	if v == "" {
+		return 0, nil
+	}
+	n, err := strconv.ParseInt(v, 10, 64)
+	if err != nil || n < 0 {
+		return 0, fmt.Errorf("%s must be a non-negative number of seconds", field)
+	}
+	return time.Duration(n) * time.Second, nil
+}
+
+func contains(list []string, v string) bool {
+	for _, s := range list {
+		if s == v {
+			return true
+		}
+	}
+	return false
+}
+
+// renderClientList renders the client list fragment, or a snackbar if loading fails.
+func renderClientList(w http.ResponseWriter, r *http.Request, db *sql.DB) {
+	clients, err := models.ListClients(r.Context(), db)
+	if err != nil {
+		_ = ui.RenderSnackbar(r.Context(), w, fmt.Sprintf("failed to list clients: %v", err), "error", "5s")
+		return
+	}
+	w.Header().Set("Content-Type", "text/html; charset=utf-8")
+	_ = fragments.AdminClientList(clients).Render(r.Context(), w)
+}
+
+// clientFromURL loads the client referenced by the {id} route parameter.
+func clientFromURL(r *http.Request, db *sql.DB) (*models.Client, error) {
+	id, err := strconv.ParseInt(chi.URLParam(r, "id"), 10, 64)
+	if err != nil {
+		return nil, sql.ErrNoRows
+	}
+	return models.GetClientByID(r.Context(), db, id)
+}
+
+// AdminListClients renders the registered OAuth clients.
+func AdminListClients(db *sql.DB) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		slog.Info("admin_view_clients", "admin", adminPubKey(r), "remote", r.RemoteAddr)
+		renderClientList(w, r, db)
+	}
+}
+
+// AdminNewClientForm renders an empty client form with sensible defaults.
+func AdminNewClientForm() http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		c := models.Client{
+			Scopes:                  []string{"openid", "profile", "email"},
+			GrantTypes:              []string{"authorization_code"},
+			ResponseTypes:           []string{"code"},
+			TokenEndpointAuthMethod: "client_secret_basic",
+		}
+		w.Header().Set("Content-Type", "text/html; charset=utf-8")
+		_ = fragments.AdminClientForm(c, oidc.GrantTypesSupported, oidc.ResponseTypesSupported, oidc.TokenEndpointAuthMethods, true).Render(r.Context(), w)
+	}
+}
+
+// AdminCreateClient registers a new client and shows its secret once.
+func AdminCreateClient(cfg *config.Config, db *sql.DB) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		ctx := r.Context()
+		if err := r.ParseForm(); err != nil {
+			_ = ui.RenderSnackbar(ctx, w, "invalid form", "error", "5s")
+			return
+		}
+		var c models.Client
+		if err := clientFromForm(r, &c); err != nil {
+			_ = ui.RenderSnackbar(ctx, w, err.Error(), "error", "5s")
+			return
+		}
+		c.ClientID = strings.TrimSpace(r.FormValue("client_id"))
+		if c.ClientID == "" {
+			id, err := generateRandomToken(16)
+			if err != nil {
+				_ = ui.RenderSnackbar(ctx, w, "failed to generate client_id", "error", "5s")
+				return
+			}
+			c.ClientID = id
+		}
+
+		secret := ""
+		if !c.IsPublic() {
+			var err error
+			if secret, err = generateRandomToken(32); err != nil {
+				_ = ui.RenderSnackbar(ctx, w, "failed to generate client secret", "error", "5s")
+				return
+			}
+			c.SecretHash = oidc.HashClientSecret(cfg, secret)
+		}
+
+		id, err := models.CreateClient(ctx, db, &c)
+		if err != nil {
+			_ = ui.RenderSnackbar(ctx, w, fmt.Sprintf("failed to create client: %v", err), "error", "5s")
+			slog.Error("admin_create_client_failed", "admin", adminPubKey(r), "client_id", c.ClientID, "error", err.Error())
+			return
+		}
+		c.ID = id
+		slog.Info("admin_create_client", "admin", adminPubKey(r), "remote", r.RemoteAddr, "client_id", c.ClientID)
+
+		if secret == "" {
+			renderClientList(w, r, db)
+			return
+		}
+		clients, err := models.ListClients(ctx, db)
+		if err != nil {
+			_ = ui.RenderSnackbar(ctx, w, fmt.Sprintf("failed to list clients: %v", err), "error", "5s")
+			return
+		}
+		w.Header().Set("Content-Type", "text/html; charset=utf-8")
+		_ = fragments.AdminClientSecret(c, secret, clients).Render(ctx, w)
+	}
+}
+
+// AdminEditClientForm renders the form for an existing client.
+func AdminEditClientForm(db *sql.DB) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		c, err := clientFromURL(r, db)
+		if err != nil {
+			_ = ui.RenderSnackbar(r.Context(), w, "client not found", "error", "5s")
+			return
+		}
+		w.Header().Set("Content-Type", "text/html; charset=utf-8")
+		_ = fragments.AdminClientForm(*c, oidc.GrantTypesSupported, oidc.ResponseTypesSupported, oidc.TokenEndpointAuthMethods, false).Render(r.Context(), w)
+	}
+}
+
+// AdminUpdateClient saves changes to a client, optionally rotating its secret.
+func AdminUpdateClient(cfg *config.Config, db *sql.DB) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		ctx := r.Context()
+		c, err := clientFromURL(r, db)
+		if err != nil {
+			_ = ui.RenderSnackbar(ctx, w, "client not found", "error", "5s")
+			return
+		}
+		if err := r.ParseForm(); err != nil {
+			_ = ui.RenderSnackbar(ctx, w, "invalid form", "error", "5s")
+			return
+		}
+		if err := clientFromForm(r, c); err != nil {
+			_ = ui.RenderSnackbar(ctx, w, err.Error(), "error", "5s")
+			return
+		}
+
+		secret := ""
+		if c.IsPublic() {
+			c.SecretHash = ""
+		} else if c.SecretHash == "" || r.FormValue("regenerate_secret") != "" {
+			if secret, err = generateRandomToken(32); err != nil {
+				_ = ui.RenderSnackbar(ctx, w, "failed to generate client secret", "error", "5s")
+				return
+			}
+			c.SecretHash = oidc.HashClientSecret(cfg, secret)
+		}
+
+		if err := models.UpdateClient(ctx, db, c); err != nil {
+			_ = ui.RenderSnackbar(ctx, w, fmt.Sprintf("failed to update client: %v", err), "error", "5s")
+			slog.Error("admin_update_client_failed", "admin", adminPubKey(r), "client_id", c.ClientID, "error", err.Error())
+			return
+		}
+		slog.Info("admin_update_client", "admin", adminPubKey(r), "remote", r.RemoteAddr, "client_id", c.ClientID, "secret_rotated", secret != "")
+
+		if secret == "" {
+			renderClientList(w, r, db)
+			return
+		}
+		clients, err := models.ListClients(ctx, db)
+		if err != nil {
+			_ = ui.RenderSnackbar(ctx, w, fmt.Sprintf("failed to list clients: %v", err), "error", "5s")
+			return
+		}
+		w.Header().Set("Content-Type", "text/html; charset=utf-8")
+		_ = fragments.AdminClientSecret(*c, secret, clients).Render(ctx, w)
+	}
+}
+
+// AdminDeleteClient removes a client and revokes its tokens.
+func AdminDeleteClient(db *sql.DB) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		c, err := clientFromURL(r, db)
+		if err != nil {
+			_ = ui.RenderSnackbar(r.Context(), w, "client not found", "error", "5s")
+			return
+		}
+		if err := models.DeleteClient(r.Context(), db, c.ID); err != nil {
+			_ = ui.RenderSnackbar(r.Context(), w, fmt.Sprintf("failed to delete client: %v", err), "error", "5s")
+			slog.Error("admin_delete_client_failed", "admin", adminPubKey(r), "client_id", c.ClientID, "error", err.Error())
+			return
+		}
+		slog.Info("admin_delete_client", "admin", adminPubKey(r), "remote", r.RemoteAddr, "client_id", c.ClientID)
+		renderClientList(w, r, db)
+	}
+}
+
+// AdminRevokeServiceTokens revokes the access tokens a client obtained with the
+// client_credentials grant. User tokens are left alone.
+func AdminRevokeServiceTokens(db *sql.DB) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		c, err := clientFromURL(r, db)
+		if err != nil {
+			_ = ui.RenderSnackbar(r.Context(), w, "client not found", "error", "5s")
+			return
+		}
+		n, err := models.DeactivateServiceTokens(r.Context(), db, c.ClientID)
+		if err != nil {
+			_ = ui.RenderSnackbar(r.Context(), w, fmt.Sprintf("failed to revoke service tokens: %v", err), "error", "5s")
+			slog.Error("admin_revoke_service_tokens_failed", "admin", adminPubKey(r), "client_id", c.ClientID, "error", err.Error())
+			return
+		}
+		slog.Info("admin_revoke_service_tokens", "admin", adminPubKey(r), "remote", r.RemoteAddr, "client_id", c.ClientID, "revoked_tokens", n)
+		_ = ui.RenderSnackbar(r.Context(), w, fmt.Sprintf("revoked %d service token(s) of %s", n, c.Name), "success", "5s")
+	}
+}
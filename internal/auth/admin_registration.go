@@ -0,0 +1,144 @@
+package auth
+
+import (
+	"database/sql"
+	"errors"
+	"fmt"
+	"log/slog"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/go-chi/chi/v5"
+	"github.com/lescuer97/nostr-oicd/internal/config"
+	"github.com/lescuer97/nostr-oicd/internal/middleware"
+	"github.com/lescuer97/nostr-oicd/internal/models"
+	"github.com/lescuer97/nostr-oicd/internal/oidc"
+	"github.com/lescuer97/nostr-oicd/internal/ui"
+	"github.com/lescuer97/nostr-oicd/templates/fragments"
+)
+
+// defaultCredentialLifetime is used when the admin leaves the lifetime empty.
+const defaultCredentialLifetime = 30 * 24 * time.Hour
+
+// lifetimeFromForm parses a credential lifetime in hours.
+func lifetimeFromForm(r *http.Request) (time.Duration, error) {
+	v := strings.TrimSpace(r.FormValue("lifetime_hours"))
+	if v == "" {
+		return defaultCredentialLifetime, nil
+	}
+	n, err := strconv.ParseInt(v, 10, 64)
+	if err != nil || n <= 0 {
+		return 0, errors.New("lifetime must be a positive number of hours")
+	}
+	return time.Duration(n) * time.Hour, nil
+}
+
+// renderRegistration renders the registration credentials panel, optionally showing a
+// newly issued credential once.
+func renderRegistration(w http.ResponseWriter, r *http.Request, db *sql.DB, kind, credential string) {
+	tokens, err := models.ListInitialAccessTokens(r.Context(), db)
+	if err != nil {
+		_ = ui.RenderSnackbar(r.Context(), w, fmt.Sprintf("failed to list initial access tokens: %v", err), "error", "5s")
+		return
+	}
+	w.Header().Set("Content-Type", "text/html; charset=utf-8")
+	_ = fragments.AdminRegistration(tokens, kind, credential).Render(r.Context(), w)
+}
+
+// AdminRegistration renders the dynamic client registration credentials panel.
+func AdminRegistration(db *sql.DB) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		renderRegistration(w, r, db, "", "")
+	}
+}
+
+// AdminIssueInitialAccessToken issues an initial access token for POST /register and shows it once.
+func AdminIssueInitialAccessToken(cfg *config.Config, db *sql.DB) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		ctx := r.Context()
+		if err := r.ParseForm(); err != nil {
+			_ = ui.RenderSnackbar(ctx, w, "invalid form", "error", "5s")
+			return
+		}
+		ttl, err := lifetimeFromForm(r)
+		if err != nil {
+			_ = ui.RenderSnackbar(ctx, w, err.Error(), "error", "5s")
+			return
+		}
+		var createdBy *int64
+		if user, ok := ctx.Value(middleware.ContextUserKey).(*models.User); ok {
+			createdBy = &user.ID
+		}
+		label := strings.TrimSpace(r.FormValue("label"))
+		token, err := oidc.NewInitialAccessToken(ctx, cfg, db, label, createdBy, ttl)
+		if err != nil {
+			_ = ui.RenderSnackbar(ctx, w, fmt.Sprintf("failed to issue token: %v", err), "error", "5s")
+			slog.Error("admin_issue_initial_access_token_failed", "admin", adminPubKey(r), "error", err.Error())
+			return
+		}
+		slog.Info("admin_issue_initial_access_token", "admin", adminPubKey(r), "remote", r.RemoteAddr, "label", label, "ttl", ttl.String())
+		renderRegistration(w, r, db, "Initial access token", token)
+	}
+}
+
+// AdminRevokeInitialAccessToken stops an initial access token from registering more clients.
+func AdminRevokeInitialAccessToken(db *sql.DB) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		id, err := strconv.ParseInt(chi.URLParam(r, "id"), 10, 64)
+		if err != nil {
+			_ = ui.RenderSnackbar(r.Context(), w, "token not found", "error", "5s")
+			return
+		}
+		if err := models.RevokeInitialAccessToken(r.Context(), db, id); err != nil {
+			_ = ui.RenderSnackbar(r.Context(), w, fmt.Sprintf("failed to revoke token: %v", err), "error", "5s")
+			return
+		}
+		slog.Info("admin_revoke_initial_access_token", "admin", adminPubKey(r), "remote", r.RemoteAddr, "token_id", id)
+		renderRegistration(w, r, db, "", "")
+	}
+}
+
+// AdminIssueSoftwareStatement signs a software statement that registering clients send
+// instead of an initial access token. Empty fields leave the choice to the client.
+func AdminIssueSoftwareStatement(cfg *config.Config, db *sql.DB) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		ctx := r.Context()
+		if err := r.ParseForm(); err != nil {
+			_ = ui.RenderSnackbar(ctx, w, "invalid form", "error", "5s")
+			return
+		}
+		ttl, err := lifetimeFromForm(r)
+		if err != nil {
+			_ = ui.RenderSnackbar(ctx, w, err.Error(), "error", "5s")
+			return
+		}
+		st := oidc.SoftwareStatement{
+			SoftwareID: strings.TrimSpace(r.FormValue("software_id")),
+			ClientName: strings.TrimSpace(r.FormValue("client_name")),
+			Scope:      strings.Join(strings.Fields(r.FormValue("scope")), " "),
+		}
+		if st.SoftwareID == "" {
+			_ = ui.RenderSnackbar(ctx, w, "software ID is required", "error", "5s")
+			return
+		}
+		for _, s := range strings.Fields(st.Scope) {
+			if !contains(oidc.ScopesSupported, s) {
+				_ = ui.RenderSnackbar(ctx, w, fmt.Sprintf("unsupported scope %q", s), "error", "5s")
+				return
+			}
+		}
+		if st.Scope != "" && !contains(strings.Fields(st.Scope), "openid") {
+			_ = ui.RenderSnackbar(ctx, w, "scope must include openid", "error", "5s")
+			return
+		}
+		statement, err := oidc.IssueSoftwareStatement(cfg, st, ttl)
+		if err != nil {
+			_ = ui.RenderSnackbar(ctx, w, fmt.Sprintf("failed to issue statement: %v", err), "error", "5s")
+			return
+		}
+		slog.Info("admin_issue_software_statement", "admin", adminPubKey(r), "remote", r.RemoteAddr, "software_id", st.SoftwareID, "ttl", ttl.String())
+		renderRegistration(w, r, db, "Software statement", statement)
+	}
+}
@@ -77,4 +77,31 @@ func RegisterAdminRoutes(r chi.Router, cfg *config.Config, db *sql.DB) {
 		slog.Info("admin_add_user_success", "admin", adminPub, "remote", r.RemoteAddr, "pubHex", pubHex, "user_id", id)
 		return
 	})).ServeHTTP)
+
+	// OAuth client registry (HTMX fragments)
+	r.Get("/admin/clients", middleware.AdminOnly()(AdminListClients(db)).ServeHTTP)
+	r.Get("/admin/clients/new", middleware.AdminOnly()(AdminNewClientForm()).ServeHTTP)
+	r.Post("/admin/clients", middleware.AdminOnly()(AdminCreateClient(cfg, db)).ServeHTTP)
+	r.Get("/admin/clients/{id}/edit", middleware.AdminOnly()(AdminEditClientForm(db)).ServeHTTP)
+	r.Post("/admin/clients/{id}", middleware.AdminOnly()(AdminUpdateClient(cfg, db)).ServeHTTP)
+	r.Post("/admin/clients/{id}/delete", middleware.AdminOnly()(AdminDeleteClient(db)).ServeHTTP)
+	r.Post("/admin/clients/{id}/revoke-service-tokens", middleware.AdminOnly()(AdminRevokeServiceTokens(db)).ServeHTTP)
+
+	// Browser sessions; revoking one sends back-channel logout notifications
+	r.Get("/admin/sessions", middleware.AdminOnly()(AdminListSessions(db)).ServeHTTP)
+	r.Post("/admin/sessions/{id}/revoke", middleware.AdminOnly()(AdminRevokeSession(db)).ServeHTTP)
+
+	// Credentials for dynamic client registration
+	r.Get("/admin/registration", middleware.AdminOnly()(AdminRegistration(db)).ServeHTTP)
+	r.Post("/admin/registration/tokens", middleware.AdminOnly()(AdminIssueInitialAccessToken(cfg, db)).ServeHTTP)
+	r.Post("/admin/registration/tokens/{id}/revoke", middleware.AdminOnly()(AdminRevokeInitialAccessToken(db)).ServeHTTP)
+	r.Post("/admin/registration/statements", middleware.AdminOnly()(AdminIssueSoftwareStatement(cfg, db)).ServeHTTP)
+
+	// Claim mappers, the local roles they release, and a preview of the resulting tokens
+	r.Get("/admin/claims", middleware.AdminOnly()(AdminClaims(db)).ServeHTTP)
+	r.Post("/admin/claims/mappers", middleware.AdminOnly()(AdminCreateClaimMapper(db)).ServeHTTP)
+	r.Post("/admin/claims/mappers/{id}/delete", middleware.AdminOnly()(AdminDeleteClaimMapper(db)).ServeHTTP)
+	r.Post("/admin/claims/roles", middleware.AdminOnly()(AdminAddUserRole(db)).ServeHTTP)
+	r.Post("/admin/claims/roles/remove", middleware.AdminOnly()(AdminRemoveUserRole(db)).ServeHTTP)
+	r.Post("/admin/claims/preview", middleware.AdminOnly()(AdminPreviewClaims(cfg, db)).ServeHTTP)
 }
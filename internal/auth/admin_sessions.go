@@ -0,0 +1,60 @@
+package auth
+
+import (
+	"database/sql"
+	"fmt"
+	"log/slog"
+	"net/http"
+	"strconv"
+
+	"github.com/go-chi/chi/v5"
+	"github.com/lescuer97/nostr-oicd/internal/models"
+	"github.com/lescuer97/nostr-oicd/internal/ui"
+	"github.com/lescuer97/nostr-oicd/templates/fragments"
+)
+
+// recentLogoutDeliveries is how many back-channel logout deliveries the sessions panel shows.
+const recentLogoutDeliveries = 25
+
+// renderSessions renders the active sessions panel.
+func renderSessions(w http.ResponseWriter, r *http.Request, db *sql.DB) {
+	sessions, err := models.ListActiveSessions(r.Context(), db)
+	if err != nil {
+		_ = ui.RenderSnackbar(r.Context(), w, fmt.Sprintf("failed to list sessions: %v", err), "error", "5s")
+		return
+	}
+	deliveries, err := models.ListRecentLogoutDeliveries(r.Context(), db, recentLogoutDeliveries)
+	if err != nil {
+		_ = ui.RenderSnackbar(r.Context(), w, fmt.Sprintf("failed to list logout deliveries: %v", err), "error", "5s")
+		return
+	}
+	w.Header().Set("Content-Type", "text/html; charset=utf-8")
+	_ = fragments.AdminSessions(sessions, deliveries).Render(r.Context(), w)
+}
+
+// AdminListSessions renders the active sessions and the latest back-channel logout deliveries.
+func AdminListSessions(db *sql.DB) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		renderSessions(w, r, db)
+	}
+}
+
+// AdminRevokeSession ends a user's session. Clients that were issued tokens in it are
+// notified over the back channel.
+func AdminRevokeSession(db *sql.DB) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		id, err := strconv.ParseInt(chi.URLParam(r, "id"), 10, 64)
+		if err != nil {
+			_ = ui.RenderSnackbar(r.Context(), w, "session not found", "error", "5s")
+			return
+		}
+		queued, err := models.EndSession(r.Context(), db, id)
+		if err != nil {
+			_ = ui.RenderSnackbar(r.Context(), w, fmt.Sprintf("failed to revoke session: %v", err), "error", "5s")
+			slog.Error("admin_revoke_session_failed", "admin", adminPubKey(r), "session_id", id, "error", err.Error())
+			return
+		}
+		slog.Info("admin_revoke_session", "admin", adminPubKey(r), "remote", r.RemoteAddr, "session_id", id, "backchannel_notifications", queued)
+		renderSessions(w, r, db)
+	}
+}
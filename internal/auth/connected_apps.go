@@ -0,0 +1,61 @@
+package auth
+
+import (
+	"database/sql"
+	"fmt"
+	"log/slog"
+	"net/http"
+
+	"github.com/lescuer97/nostr-oicd/internal/middleware"
+	"github.com/lescuer97/nostr-oicd/internal/models"
+	"github.com/lescuer97/nostr-oicd/internal/ui"
+	"github.com/lescuer97/nostr-oicd/templates/fragments"
+)
+
+// renderConnectedApps renders the signed-in user's connected apps fragment.
+func renderConnectedApps(w http.ResponseWriter, r *http.Request, db *sql.DB, user *models.User) {
+	apps, err := models.ListConnectedApps(r.Context(), db, user.ID)
+	if err != nil {
+		_ = ui.RenderSnackbar(r.Context(), w, fmt.Sprintf("failed to list connected apps: %v", err), "error", "5s")
+		return
+	}
+	w.Header().Set("Content-Type", "text/html; charset=utf-8")
+	_ = fragments.ConnectedApps(apps).Render(r.Context(), w)
+}
+
+// ConnectedAppsHandler lists the clients the user has consented to. Requires middleware.AuthMiddleware.
+func ConnectedAppsHandler(db *sql.DB) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		user, ok := r.Context().Value(middleware.ContextUserKey).(*models.User)
+		if !ok {
+			http.Error(w, "unauthorized", http.StatusUnauthorized)
+			return
+		}
+		renderConnectedApps(w, r, db, user)
+	}
+}
+
+// RevokeConnectedAppHandler removes the user's consent for the posted client_id and
+// deactivates all of that client's tokens for the user. Requires middleware.AuthMiddleware.
+func RevokeConnectedAppHandler(db *sql.DB) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		ctx := r.Context()
+		user, ok := ctx.Value(middleware.ContextUserKey).(*models.User)
+		if !ok {
+			http.Error(w, "unauthorized", http.StatusUnauthorized)
+			return
+		}
+		clientID := r.FormValue("client_id")
+		if clientID == "" {
+			_ = ui.RenderSnackbar(ctx, w, "client_id is required", "error", "5s")
+			return
+		}
+		if err := models.RevokeConsent(ctx, db, user.ID, clientID); err != nil {
+			slog.Error("connected_app_revoke_failed", "user_id", user.ID, "client_id", clientID, "error", err.Error())
+			_ = ui.RenderSnackbar(ctx, w, fmt.Sprintf("failed to revoke access: %v", err), "error", "5s")
+			return
+		}
+		slog.Info("connected_app_revoked", "user_id", user.ID, "client_id", clientID, "remote", r.RemoteAddr)
+		renderConnectedApps(w, r, db, user)
+	}
+}
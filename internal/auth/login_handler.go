@@ -8,11 +8,13 @@ import (
 	"database/sql"
 	"encoding/hex"
 	"encoding/json"
+	"log/slog"
 	"net/http"
 	"time"
 
 	"github.com/lescuer97/nostr-oicd/internal/config"
 	"github.com/lescuer97/nostr-oicd/internal/models"
+	"github.com/lescuer97/nostr-oicd/internal/profile"
 	"github.com/lescuer97/nostr-oicd/internal/ui"
 	"github.com/lescuer97/nostr-oicd/templates/fragments"
 	"github.com/nbd-wtf/go-nostr"
@@ -131,6 +133,13 @@ func LoginHandler(cfg *config.Config, db *sql.DB, w http.ResponseWriter, r *http
 		return
 	}
 
+	// Refresh the kind-0 profile used for OIDC profile claims without delaying the login
+	go func(userID int64, pubkey string) {
+		if err := profile.Refresh(context.Background(), db, cfg.NostrRelays, userID, pubkey); err != nil {
+			slog.Warn("login_profile_refresh_failed", "user_id", userID, "error", err.Error())
+		}
+	}(userID, ev.PubKey)
+
 	// Set cookie to the opaque token value
 	http.SetCookie(w, &http.Cookie{
 		Name:     cfg.CookieName,
@@ -142,9 +151,9 @@ func LoginHandler(cfg *config.Config, db *sql.DB, w http.ResponseWriter, r *http
 		Expires:  expiresAt,
 	})
 
-	// Render login success fragment
+	// Render login success fragment, returning to the requested local page (e.g. /authorize)
 	w.Header().Set("Content-Type", "text/html; charset=utf-8")
-	if err := fragments.LoginSuccess().Render(ctx, w); err != nil {
+	if err := fragments.LoginSuccess(safeRedirectPath(r.FormValue("next"))).Render(ctx, w); err != nil {
 		// As a fallback, write plain text
 		http.Error(w, "failed to render fragment", http.StatusInternalServerError)
 	}
@@ -5,9 +5,13 @@ import (
 	"crypto/sha256"
 	"database/sql"
 	"encoding/hex"
+	"log/slog"
 	"net/http"
 
 	"github.com/lescuer97/nostr-oicd/internal/config"
+	"github.com/lescuer97/nostr-oicd/internal/models"
+	"github.com/lescuer97/nostr-oicd/internal/oidc"
+	"github.com/lescuer97/nostr-oicd/templates/pages"
 )
 
 // LogoutHandler invalidates the current session token (sets active=false in DB) and clears the cookie.
@@ -28,10 +32,19 @@ func LogoutHandler(cfg *config.Config, db *sql.DB, w http.ResponseWriter, r *htt
 	h.Write([]byte(token))
 	tokenHash := hex.EncodeToString(h.Sum(nil))
 
-	// deactivate session
-	if err := DeactivateSessionByHash(r.Context(), db, tokenHash); err != nil {
-		// If failing to deactivate, still clear cookie and redirect
-		// (log server-side in real app)
+	// end the session and notify the clients that took part in it
+	var frames []string
+	if sess, err := models.GetSessionByHash(r.Context(), db, tokenHash); err == nil {
+		if frames, err = oidc.FrontchannelLogoutURLs(r.Context(), cfg, db, sess.ID); err != nil {
+			slog.Error("logout_frontchannel_lookup_failed", "session_id", sess.ID, "error", err.Error())
+		}
+		queued, err := models.EndSession(r.Context(), db, sess.ID)
+		if err != nil {
+			// still clear cookie and redirect
+			slog.Error("logout_end_session_failed", "session_id", sess.ID, "error", err.Error())
+		} else {
+			slog.Info("logout", "user_id", sess.UserID, "session_id", sess.ID, "backchannel_notifications", queued, "remote", r.RemoteAddr)
+		}
 	}
 
 	// Clear cookie
@@ -44,6 +57,16 @@ func LogoutHandler(cfg *config.Config, db *sql.DB, w http.ResponseWriter, r *htt
 		MaxAge:   -1,
 	})
 
+	// browser-side apps are signed out through iframes before going on to login
+	if len(frames) > 0 {
+		w.Header().Set("Content-Type", "text/html; charset=utf-8")
+		w.Header().Set("Cache-Control", "no-store")
+		if err := pages.LoggedOutPage(frames, "/login").Render(r.Context(), w); err != nil {
+			http.Error(w, "failed to render", http.StatusInternalServerError)
+		}
+		return
+	}
+
 	// redirect to login
 	http.Redirect(w, r, "/login", http.StatusSeeOther)
 }
@@ -0,0 +1,51 @@
+package auth
+
+import (
+	"database/sql"
+	"encoding/json"
+	"fmt"
+	"log/slog"
+	"net/http"
+
+	"github.com/lescuer97/nostr-oicd/internal/config"
+	"github.com/lescuer97/nostr-oicd/internal/middleware"
+	"github.com/lescuer97/nostr-oicd/internal/models"
+	"github.com/lescuer97/nostr-oicd/internal/profile"
+	"github.com/lescuer97/nostr-oicd/internal/ui"
+	"github.com/nbd-wtf/go-nostr"
+)
+
+// ProfileHandler updates the signed-in user's kind-0 profile metadata. When the form carries a
+// signed_event it is verified and stored directly, otherwise the profile is fetched from the relays.
+// Requires middleware.AuthMiddleware.
+func ProfileHandler(cfg *config.Config, db *sql.DB, w http.ResponseWriter, r *http.Request) {
+	ctx := r.Context()
+	user, ok := ctx.Value(middleware.ContextUserKey).(*models.User)
+	if !ok {
+		http.Error(w, "unauthorized", http.StatusUnauthorized)
+		return
+	}
+	if err := r.ParseForm(); err != nil {
+		_ = ui.RenderSnackbar(ctx, w, "invalid form", "error", "5s")
+		return
+	}
+
+	if signed := r.FormValue("signed_event"); signed != "" {
+		var ev nostr.Event
+		if err := json.Unmarshal([]byte(signed), &ev); err != nil {
+			_ = ui.RenderSnackbar(ctx, w, "invalid event", "error", "5s")
+			return
+		}
+		if err := profile.Save(ctx, db, user.ID, user.PublicKey, &ev); err != nil {
+			_ = ui.RenderSnackbar(ctx, w, fmt.Sprintf("profile rejected: %v", err), "error", "5s")
+			return
+		}
+	} else if err := profile.Refresh(ctx, db, cfg.NostrRelays, user.ID, user.PublicKey); err != nil {
+		slog.Warn("profile_refresh_failed", "user_id", user.ID, "error", err.Error())
+		_ = ui.RenderSnackbar(ctx, w, fmt.Sprintf("failed to refresh profile: %v", err), "error", "5s")
+		return
+	}
+
+	slog.Info("profile_updated", "user_id", user.ID, "remote", r.RemoteAddr)
+	_ = ui.RenderSnackbar(ctx, w, "profile updated", "success", "5s")
+}
@@ -32,6 +32,15 @@ func RegisterRoutes(r chi.Router, cfg *config.Config, db *sql.DB) {
 		LogoutHandler(cfg, db, w, r)
 	})
 
+	// Profile refresh (protected) — POST, optionally with a signed kind-0 event
+	r.With(middleware.AuthMiddleware(cfg, db)).Post("/api/profile", func(w http.ResponseWriter, r *http.Request) {
+		ProfileHandler(cfg, db, w, r)
+	})
+
+	// Connected apps (protected): clients the user consented to, and revoking them
+	r.With(middleware.AuthMiddleware(cfg, db)).Get("/api/connected-apps", ConnectedAppsHandler(db))
+	r.With(middleware.AuthMiddleware(cfg, db)).Post("/api/connected-apps/revoke", RevokeConnectedAppHandler(db))
+
 	// Dashboard route (requires authentication)
 	r.With(middleware.AuthMiddleware(cfg, db)).Get("/dashboard", func(w http.ResponseWriter, r *http.Request) {
 		// get user from context
@@ -1,9 +1,6 @@
 package auth
 
 import (
-	"context"
-	"database/sql"
-	"fmt"
 	"sync"
 	"time"
 )
@@ -35,16 +32,3 @@ func ValidateAndDeleteChallenge(ch string) bool {
 	delete(challenges, ch)
 	return true
 }
-
-// DeactivateSessionByHash sets active = false for the session with the given token_hash
-func DeactivateSessionByHash(ctx context.Context, db *sql.DB, tokenHash string) error {
-	res, err := db.ExecContext(ctx, `UPDATE sessions SET active = 0 WHERE token_hash = ?`, tokenHash)
-	if err != nil {
-		return fmt.Errorf("failed to deactivate session: %w", err)
-	}
-	_, err = res.RowsAffected()
-	if err != nil {
-		return nil
-	}
-	return nil
-}
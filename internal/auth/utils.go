@@ -2,7 +2,9 @@ package auth
 
 import (
 	"errors"
+	"net/url"
 	"strings"
+	"unicode"
 
 	"github.com/nbd-wtf/go-nostr/nip19"
 )
@@ -25,3 +27,25 @@ func decodeNpubToHex(npub string) (string, error) {
 	}
 	return "", errors.New("unexpected npub payload")
 }
+
+// safeRedirectPath returns next if it is a local absolute path, otherwise /dashboard.
+// This prevents the post-login redirect from being used as an open redirect. Browsers drop
+// tabs and newlines from URLs and treat backslashes as slashes, so "/\t/evil.example" would
+// leave the site; control characters and whitespace are refused, raw or percent-encoded.
+func safeRedirectPath(next string) string {
+	if !isLocalPath(next) {
+		return "/dashboard"
+	}
+	u, err := url.Parse(next)
+	if err != nil || u.Scheme != "" || u.Host != "" || u.User != nil || !isLocalPath(u.Path) {
+		return "/dashboard"
+	}
+	return next
+}
+
+// isLocalPath reports whether p starts with a single slash and has no control characters or
+// whitespace.
+func isLocalPath(p string) bool {
+	return strings.HasPrefix(p, "/") && !strings.HasPrefix(p, "//") && !strings.HasPrefix(p, "/\\") &&
+		!strings.ContainsFunc(p, func(r rune) bool { return unicode.IsControl(r) || unicode.IsSpace(r) })
+}
@@ -0,0 +1,33 @@
+package auth
+
+import "testing"
+
+func TestSafeRedirectPath(t *testing.T) {
+	tests := []struct {
+		name string
+		next string
+		want string
+	}{
+		{"empty", "", "/dashboard"},
+		{"local path", "/settings", "/settings"},
+		{"local path with query", "/authorize?client_id=app&state=x", "/authorize?client_id=app&state=x"},
+		{"relative path", "settings", "/dashboard"},
+		{"absolute URL", "https://evil.example/", "/dashboard"},
+		{"scheme relative", "//evil.example/", "/dashboard"},
+		{"backslash", "/\\evil.example/", "/dashboard"},
+		{"tab", "/\t/evil.example/", "/dashboard"},
+		{"newline", "/\n/evil.example/", "/dashboard"},
+		{"space", "/ /evil.example/", "/dashboard"},
+		{"encoded tab", "/%09/evil.example/", "/dashboard"},
+		{"encoded newline", "/%0a/evil.example/", "/dashboard"},
+		{"javascript", "javascript:alert(1)", "/dashboard"},
+		{"invalid escape", "/%zz", "/dashboard"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := safeRedirectPath(tt.next); got != tt.want {
+				t.Errorf("safeRedirectPath(%q) = %q, want %q", tt.next, got, tt.want)
+			}
+		})
+	}
+}
@@ -1,8 +1,12 @@
 package config
 
 import (
+	"log/slog"
+	"net/netip"
 	"os"
 	"strconv"
+	"strings"
+	"time"
 )
 
 // Config holds application configuration loaded from environment variables.
@@ -13,6 +17,19 @@ type Config struct {
 	CookieSecure      bool
 	DatabasePath      string
 	Port              string
+	// Issuer is the public base URL of this OpenID provider (no trailing slash).
+	Issuer string
+	// SigningAlg is the JWS algorithm for newly generated signing keys (ES256, RS256 or EdDSA).
+	SigningAlg string
+	// KeyRotationInterval is how long a signing key is used before the next one takes over.
+	KeyRotationInterval time.Duration
+	// KeyGracePeriod is how long a retired key stays published in the JWKS.
+	KeyGracePeriod time.Duration
+	// NostrRelays are queried for users' kind-0 profile metadata.
+	NostrRelays []string
+	// TrustedProxies are the reverse proxies whose X-Forwarded-For and X-Real-IP headers are
+	// believed when rate limiting by client IP. Requests from anywhere else use the peer address.
+	TrustedProxies []netip.Prefix
 }
 
 // LoadFromEnv loads configuration from environment variables with sensible defaults.
@@ -35,6 +52,36 @@ func LoadFromEnv() *Config {
 	if cfg.Port == "" {
 		cfg.Port = "8080"
 	}
+	cfg.Issuer = strings.TrimRight(os.Getenv("ISSUER_URL"), "/")
+	if cfg.Issuer == "" {
+		cfg.Issuer = "http://localhost:" + cfg.Port
+	}
+	cfg.SigningAlg = os.Getenv("SIGNING_ALG")
+	if cfg.SigningAlg == "" {
+		cfg.SigningAlg = "ES256"
+	}
+	cfg.KeyRotationInterval = durationFromEnv("KEY_ROTATION_INTERVAL", 30*24*time.Hour)
+	cfg.KeyGracePeriod = durationFromEnv("KEY_GRACE_PERIOD", 7*24*time.Hour)
+	cfg.NostrRelays = []string{"wss://relay.damus.io", "wss://nos.lol", "wss://purplepag.es"}
+	if v := os.Getenv("NOSTR_RELAYS"); v != "" {
+		cfg.NostrRelays = nil
+		for _, relay := range strings.Split(v, ",") {
+			if relay = strings.TrimSpace(relay); relay != "" {
+				cfg.NostrRelays = append(cfg.NostrRelays, relay)
+			}
+		}
+	}
+	for _, p := range strings.Split(os.Getenv("TRUSTED_PROXIES"), ",") {
+		if p = strings.TrimSpace(p); p == "" {
+			continue
+		}
+		prefix, err := parsePrefix(p)
+		if err != nil {
+			slog.Warn("config_trusted_proxy_invalid", "value", p, "error", err.Error())
+			continue
+		}
+		cfg.TrustedProxies = append(cfg.TrustedProxies, prefix)
+	}
 	if v := os.Getenv("COOKIE_SECURE"); v != "" {
 		b, err := strconv.ParseBool(v)
 		if err == nil {
@@ -43,3 +90,22 @@ func LoadFromEnv() *Config {
 	}
 	return cfg
 }
+
+// parsePrefix parses a CIDR range, or a single IP address as a range of its own.
+func parsePrefix(s string) (netip.Prefix, error) {
+	if addr, err := netip.ParseAddr(s); err == nil {
+		return netip.PrefixFrom(addr, addr.BitLen()), nil
+	}
+	return netip.ParsePrefix(s)
+}
+
+// durationFromEnv parses a Go duration (e.g. "720h") from the named variable, or returns def.
+func durationFromEnv(name string, def time.Duration) time.Duration {
+	if v := os.Getenv(name); v != "" {
+		d, err := time.ParseDuration(v)
+		if err == nil && d > 0 {
+			return d
+		}
+	}
+	return def
+}
@@ -24,21 +24,49 @@ func Open(path string) (*sql.DB, error) {
 	return db, nil
 }
 
+// RunMigrations applies every *.sql file in migrationsDir that is not yet recorded in
+// schema_migrations, in file name order. Each file runs in its own transaction so that
+// non-idempotent statements (ALTER TABLE) are applied exactly once.
+// Databases created before tracking existed re-run the early CREATE TABLE IF NOT EXISTS
+// migrations once, which is harmless.
 func RunMigrations(db *sql.DB, migrationsDir string) error {
+	if _, err := db.Exec(`CREATE TABLE IF NOT EXISTS schema_migrations (name TEXT PRIMARY KEY, applied_at INTEGER NOT NULL)`); err != nil {
+		return fmt.Errorf("failed to create schema_migrations: %w", err)
+	}
 	files, err := filepath.Glob(filepath.Join(migrationsDir, "*.sql"))
 	if err != nil {
 		return err
 	}
 	for _, f := range files {
+		name := filepath.Base(f)
+		var applied int
+		if err := db.QueryRow(`SELECT COUNT(1) FROM schema_migrations WHERE name = ?`, name).Scan(&applied); err != nil {
+			return fmt.Errorf("failed to check migration %s: %w", name, err)
+		}
+		if applied > 0 {
+			continue
+		}
 		b, err := ioutil.ReadFile(f)
 		if err != nil {
 			return err
 		}
 		queries := string(b)
-		_, err = db.Exec(queries)
+		tx, err := db.Begin()
 		if err != nil {
+			return err
+		}
+		if _, err := tx.Exec(queries); err != nil {
+			_ = tx.Rollback()
 			return fmt.Errorf("migration %s failed: %w", f, err)
 		}
+		if _, err := tx.Exec(`INSERT INTO schema_migrations (name, applied_at) VALUES (?, ?)`, name, time.Now().Unix()); err != nil {
+			_ = tx.Rollback()
+			return fmt.Errorf("failed to record migration %s: %w", name, err)
+		}
+		if err := tx.Commit(); err != nil {
+			return fmt.Errorf("migration %s failed to commit: %w", f, err)
+		}
+		log.Printf("applied migration %s", name)
 		time.Sleep(20 * time.Millisecond) // tiny pause between migrations
 	}
 	return nil
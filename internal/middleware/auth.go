@@ -8,7 +8,6 @@ import (
 	"encoding/hex"
 	"net/http"
 	"strings"
-	"time"
 
 	"github.com/lescuer97/nostr-oicd/internal/config"
 	"github.com/lescuer97/nostr-oicd/internal/models"
@@ -18,14 +17,48 @@ type contextKey string
 
 const ContextUserKey = contextKey("user")
 
-// AuthMiddleware validates the session cookie token by computing HMAC(token)
-// and looking up the session in the DB. If valid, it loads the user and stores
-// it in the request context. Otherwise it returns 401 for API/HTMX requests or
+// ContextSessionKey holds the *models.Session that authenticated the request.
+const ContextSessionKey = contextKey("session")
+
+// SessionFromRequest validates the session cookie token by computing HMAC(token)
+// and looking up the session in the DB. It returns the active session and its user,
+// or an error (http.ErrNoCookie, sql.ErrNoRows, ...) when the request is not authenticated.
+func SessionFromRequest(r *http.Request, cfg *config.Config, db *sql.DB) (*models.Session, *models.User, error) {
+	cookie, err := r.Cookie(cfg.CookieName)
+	if err != nil {
+		return nil, nil, err
+	}
+	token := cookie.Value
+	// compute hmac
+	signKey := []byte(cfg.SessionSigningKey)
+	if len(signKey) == 0 {
+		signKey = []byte(cfg.JWTSecret)
+	}
+	h := hmac.New(sha256.New, signKey)
+	h.Write([]byte(token))
+	tokenHash := hex.EncodeToString(h.Sum(nil))
+
+	// find session
+	sess, err := models.GetSessionByHash(r.Context(), db, tokenHash)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	// load user
+	u, err := models.GetUserByID(r.Context(), db, sess.UserID)
+	if err != nil {
+		return nil, nil, err
+	}
+	return sess, u, nil
+}
+
+// AuthMiddleware loads the session and user with SessionFromRequest and stores
+// them in the request context. Otherwise it returns 401 for API/HTMX requests or
 // redirects to /login for browser HTML requests.
 func AuthMiddleware(cfg *config.Config, db *sql.DB) func(next http.Handler) http.Handler {
 	return func(next http.Handler) http.Handler {
 		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
-			cookie, err := r.Cookie(cfg.CookieName)
+			sess, u, err := SessionFromRequest(r, cfg, db)
 			if err != nil {
 				// Decide whether to redirect (browser page) or return 401 (API/HTMX)
 				accept := r.Header.Get("Accept")
@@ -36,46 +69,10 @@ func AuthMiddleware(cfg *config.Config, db *sql.DB) func(next http.Handler) http
 				http.Error(w, "unauthorized", http.StatusUnauthorized)
 				return
 			}
-			token := cookie.Value
-			// compute hmac
-			signKey := []byte(cfg.SessionSigningKey)
-			if len(signKey) == 0 {
-				signKey = []byte(cfg.JWTSecret)
-			}
-			h := hmac.New(sha256.New, signKey)
-			h.Write([]byte(token))
-			tokenHash := hex.EncodeToString(h.Sum(nil))
-
-			// find session
-			sess, err := models.GetSessionByHash(r.Context(), db, tokenHash)
-			if err != nil {
-				accept := r.Header.Get("Accept")
-				if strings.Contains(accept, "text/html") {
-					http.Redirect(w, r, "/login", http.StatusSeeOther)
-					return
-				}
-				http.Error(w, "unauthorized", http.StatusUnauthorized)
-				return
-			}
-
-			// load user
-			row := db.QueryRowContext(r.Context(), `SELECT id, public_key, is_admin, created_at, updated_at FROM users WHERE id = ?`, sess.UserID)
-			var u models.User
-			var createdAtUnix, updatedAtUnix int64
-			if err := row.Scan(&u.ID, &u.PublicKey, &u.IsAdmin, &createdAtUnix, &updatedAtUnix); err != nil {
-				accept := r.Header.Get("Accept")
-				if strings.Contains(accept, "text/html") {
-					http.Redirect(w, r, "/login", http.StatusSeeOther)
-					return
-				}
-				http.Error(w, "unauthorized", http.StatusUnauthorized)
-				return
-			}
-			u.CreatedAt = time.Unix(createdAtUnix, 0)
-			u.UpdatedAt = time.Unix(updatedAtUnix, 0)
 
-			// attach user to context
-			ctx := context.WithValue(r.Context(), ContextUserKey, &u)
+			// attach user and session to context
+			ctx := context.WithValue(r.Context(), ContextUserKey, u)
+			ctx = context.WithValue(ctx, ContextSessionKey, sess)
 			next.ServeHTTP(w, r.WithContext(ctx))
 		})
 	}
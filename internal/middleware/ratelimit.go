@@ -1,10 +1,13 @@
 package middleware
 
 import (
+	"fmt"
 	"net"
 	"net/http"
+	"net/netip"
 	"strings"
 	"sync"
+	"sync/atomic"
 	"time"
 
 	"golang.org/x/time/rate"
@@ -18,19 +21,31 @@ type clientInfo struct {
 var (
 	clients sync.Map // map[string]*clientInfo
 	once    sync.Once
+	scopes  atomic.Uint64
 )
 
 // RateLimitMiddleware returns a Chi middleware that rate-limits requests by client IP.
 // rps is the allowed requests per second (use rate.Every(time.Minute/requests) for per-minute),
 // burst is the allowed burst size.
 func RateLimitMiddleware(rps rate.Limit, burst int) func(next http.Handler) http.Handler {
+	return rateLimit("", rps, burst)
+}
+
+// ScopedRateLimitMiddleware is like RateLimitMiddleware, but keeps limiters of its own: the
+// routes it guards get exactly rps and burst per client IP, however other routes are limited.
+func ScopedRateLimitMiddleware(rps rate.Limit, burst int) func(next http.Handler) http.Handler {
+	return rateLimit(fmt.Sprintf("%d/", scopes.Add(1)), rps, burst)
+}
+
+// rateLimit limits requests by client IP, with the limiters stored under scope followed by the IP.
+func rateLimit(scope string, rps rate.Limit, burst int) func(next http.Handler) http.Handler {
 	// start cleanup once
 	once.Do(func() { go cleanupStaleClients() })
 
 	return func(next http.Handler) http.Handler {
 		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
 			ip := clientIP(r)
-			ci := getClient(ip, rps, burst)
+			ci := getClient(scope+ip, rps, burst)
 			ci.lastSeen = time.Now()
 			if !ci.limiter.Allow() {
 				w.Header().Set("Retry-After", "60")
@@ -66,26 +81,60 @@ func cleanupStaleClients() {
 	}
 }
 
-// clientIP returns a best-effort client IP address.
-// It checks X-Forwarded-For, X-Real-IP, and falls back to r.RemoteAddr.
+// trustedProxies are the peers whose forwarding headers clientIP believes.
+var trustedProxies []netip.Prefix
+
+// TrustProxies sets the reverse proxies whose X-Forwarded-For and X-Real-IP headers are used
+// for rate limiting. It must be called before the server starts.
+func TrustProxies(prefixes []netip.Prefix) {
+	trustedProxies = prefixes
+}
+
+// clientIP returns the client IP address of r. The peer address is used unless it is a trusted
+// proxy: then X-Forwarded-For is read from the right, skipping trusted proxies, so a client
+// cannot pick its own address by sending the header. X-Real-IP is used when the proxy sets
+// no X-Forwarded-For.
 func clientIP(r *http.Request) string {
-	// Check X-Forwarded-For header
-	xff := r.Header.Get("X-Forwarded-For")
-	if xff != "" {
-		// may contain multiple addresses, use first
-		parts := strings.Split(xff, ",")
-		ip := strings.TrimSpace(parts[0])
-		if ip != "" {
-			return ip
+	host, _, err := net.SplitHostPort(r.RemoteAddr)
+	if err != nil {
+		host = r.RemoteAddr
+	}
+	if !isTrustedProxy(host) {
+		return host
+	}
+	if xff := r.Header.Values("X-Forwarded-For"); len(xff) > 0 {
+		hops := strings.Split(strings.Join(xff, ","), ",")
+		for i := len(hops) - 1; i >= 0; i-- {
+			hop := strings.TrimSpace(hops[i])
+			if _, err := netip.ParseAddr(hop); err != nil {
+				break
+			}
+			host = hop
+			if !isTrustedProxy(hop) {
+				break
+			}
 		}
+		return host
 	}
-	if xr := r.Header.Get("X-Real-IP"); xr != "" {
-		return xr
+	if xr := strings.TrimSpace(r.Header.Get("X-Real-IP")); xr != "" {
+		if _, err := netip.ParseAddr(xr); err == nil {
+			return xr
+		}
 	}
-	// fallback to RemoteAddr
-	host, _, err := net.SplitHostPort(r.RemoteAddr)
+	return host
+}
+
+// isTrustedProxy reports whether ip is in one of the trusted proxy ranges.
+func isTrustedProxy(ip string) bool {
+	addr, err := netip.ParseAddr(ip)
 	if err != nil {
-		return r.RemoteAddr
+		return false
 	}
-	return host
+	addr = addr.Unmap()
+	for _, p := range trustedProxies {
+		if p.Contains(addr) {
+			return true
+		}
+	}
+	return false
 }
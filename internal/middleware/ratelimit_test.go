@@ -0,0 +1,139 @@
+package middleware
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"net/netip"
+	"testing"
+)
+
+func TestClientIP(t *testing.T) {
+	tests := []struct {
+		name       string
+		trusted    []string
+		remoteAddr string
+		xff        []string
+		xRealIP    string
+		want       string
+	}{
+		{
+			name:       "no proxies trusted",
+			remoteAddr: "203.0.113.7:5000",
+			want:       "203.0.113.7",
+		},
+		{
+			name:       "forwarding headers from an untrusted peer",
+			remoteAddr: "203.0.113.7:5000",
+			xff:        []string{"198.51.100.1"},
+			xRealIP:    "198.51.100.2",
+			want:       "203.0.113.7",
+		},
+		{
+			name:       "forwarding headers from a peer outside the trusted range",
+			trusted:    []string{"10.0.0.0/8"},
+			remoteAddr: "203.0.113.7:5000",
+			xff:        []string{"198.51.100.1"},
+			want:       "203.0.113.7",
+		},
+		{
+			name:       "trusted proxy",
+			trusted:    []string{"10.0.0.1/32"},
+			remoteAddr: "10.0.0.1:5000",
+			xff:        []string{"198.51.100.1"},
+			want:       "198.51.100.1",
+		},
+		{
+			name:       "client-supplied hops are ignored",
+			trusted:    []string{"10.0.0.1/32"},
+			remoteAddr: "10.0.0.1:5000",
+			xff:        []string{"1.2.3.4, 198.51.100.1"},
+			want:       "198.51.100.1",
+		},
+		{
+			name:       "chain of trusted proxies",
+			trusted:    []string{"10.0.0.0/8"},
+			remoteAddr: "10.0.0.1:5000",
+			xff:        []string{"1.2.3.4, 198.51.100.1, 10.0.0.2"},
+			want:       "198.51.100.1",
+		},
+		{
+			name:       "several header lines",
+			trusted:    []string{"10.0.0.0/8"},
+			remoteAddr: "10.0.0.1:5000",
+			xff:        []string{"1.2.3.4", "198.51.100.1, 10.0.0.2"},
+			want:       "198.51.100.1",
+		},
+		{
+			name:       "garbage hop stops the walk",
+			trusted:    []string{"10.0.0.0/8"},
+			remoteAddr: "10.0.0.1:5000",
+			xff:        []string{"1.2.3.4, not-an-ip, 10.0.0.2"},
+			want:       "10.0.0.2",
+		},
+		{
+			name:       "only trusted hops",
+			trusted:    []string{"10.0.0.0/8"},
+			remoteAddr: "10.0.0.1:5000",
+			xff:        []string{"10.0.0.3, 10.0.0.2"},
+			want:       "10.0.0.3",
+		},
+		{
+			name:       "X-Real-IP from a trusted proxy",
+			trusted:    []string{"10.0.0.1/32"},
+			remoteAddr: "10.0.0.1:5000",
+			xRealIP:    "198.51.100.2",
+			want:       "198.51.100.2",
+		},
+		{
+			name:       "invalid X-Real-IP",
+			trusted:    []string{"10.0.0.1/32"},
+			remoteAddr: "10.0.0.1:5000",
+			xRealIP:    "not-an-ip",
+			want:       "10.0.0.1",
+		},
+		{
+			name:       "X-Forwarded-For wins over X-Real-IP",
+			trusted:    []string{"10.0.0.1/32"},
+			remoteAddr: "10.0.0.1:5000",
+			xff:        []string{"198.51.100.1"},
+			xRealIP:    "198.51.100.2",
+			want:       "198.51.100.1",
+		},
+		{
+			name:       "IPv4-mapped trusted proxy",
+			trusted:    []string{"10.0.0.1/32"},
+			remoteAddr: "[::ffff:10.0.0.1]:5000",
+			xff:        []string{"198.51.100.1"},
+			want:       "198.51.100.1",
+		},
+		{
+			name:       "IPv6 trusted proxy",
+			trusted:    []string{"::1/128"},
+			remoteAddr: "[::1]:5000",
+			xff:        []string{"2001:db8::1"},
+			want:       "2001:db8::1",
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var prefixes []netip.Prefix
+			for _, p := range tt.trusted {
+				prefixes = append(prefixes, netip.MustParsePrefix(p))
+			}
+			TrustProxies(prefixes)
+			t.Cleanup(func() { TrustProxies(nil) })
+
+			r := httptest.NewRequest(http.MethodGet, "/", nil)
+			r.RemoteAddr = tt.remoteAddr
+			for _, v := range tt.xff {
+				r.Header.Add("X-Forwarded-For", v)
+			}
+			if tt.xRealIP != "" {
+				r.Header.Set("X-Real-IP", tt.xRealIP)
+			}
+			if got := clientIP(r); got != tt.want {
+				t.Errorf("clientIP() = %q, want %q", got, tt.want)
+			}
+		})
+	}
+}
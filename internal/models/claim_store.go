@@ -0,0 +1,99 @@
+package models
+
+import (
+	"context"
+	"database/sql"
+	"time"
+)
+
+// ListClaimMappers returns every claim mapper, ordered by claim name.
+func ListClaimMappers(ctx context.Context, db *sql.DB) ([]ClaimMapper, error) {
+	rows, err := db.QueryContext(ctx, `SELECT id, claim, source, scope, id_token, userinfo, created_at FROM claim_mappers ORDER BY claim`)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var mappers []ClaimMapper
+	for rows.Next() {
+		var m ClaimMapper
+		var createdAtUnix int64
+		if err := rows.Scan(&m.ID, &m.Claim, &m.Source, &m.Scope, &m.IDToken, &m.UserInfo, &createdAtUnix); err != nil {
+			return nil, err
+		}
+		m.CreatedAt = time.Unix(createdAtUnix, 0)
+		mappers = append(mappers, m)
+	}
+	return mappers, rows.Err()
+}
+
+// CreateClaimMapper stores a claim mapper and returns its id. Claim names are unique.
+func CreateClaimMapper(ctx context.Context, db *sql.DB, m *ClaimMapper) (int64, error) {
+	res, err := db.ExecContext(ctx, `INSERT INTO claim_mappers (claim, source, scope, id_token, userinfo, created_at) VALUES (?, ?, ?, ?, ?, ?)`,
+		m.Claim, m.Source, m.Scope, m.IDToken, m.UserInfo, time.Now().Unix())
+	if err != nil {
+		return 0, err
+	}
+	return res.LastInsertId()
+}
+
+// DeleteClaimMapper removes a claim mapper. Tokens already issued keep the claim.
+func DeleteClaimMapper(ctx context.Context, db *sql.DB, id int64) error {
+	_, err := db.ExecContext(ctx, `DELETE FROM claim_mappers WHERE id = ?`, id)
+	return err
+}
+
+// GetUserRoles returns the roles assigned to a user, in name order.
+func GetUserRoles(ctx context.Context, db *sql.DB, userID int64) ([]string, error) {
+	rows, err := db.QueryContext(ctx, `SELECT role FROM user_roles WHERE user_id = ? ORDER BY role`, userID)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var roles []string
+	for rows.Next() {
+		var role string
+		if err := rows.Scan(&role); err != nil {
+			return nil, err
+		}
+		roles = append(roles, role)
+	}
+	return roles, rows.Err()
+}
+
+// ListUserRoles returns every role assignment with the user's public key, by role and then user.
+func ListUserRoles(ctx context.Context, db *sql.DB) ([]UserRole, error) {
+	rows, err := db.QueryContext(ctx, `SELECT r.user_id, u.public_key, r.role, r.created_at
+		FROM user_roles r JOIN users u ON u.id = r.user_id
+		ORDER BY r.role, u.public_key`)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var roles []UserRole
+	for rows.Next() {
+		var r UserRole
+		var createdAtUnix int64
+		if err := rows.Scan(&r.UserID, &r.PublicKey, &r.Role, &createdAtUnix); err != nil {
+			return nil, err
+		}
+		r.CreatedAt = time.Unix(createdAtUnix, 0)
+		roles = append(roles, r)
+	}
+	return roles, rows.Err()
+}
+
+// AddUserRole assigns role to a user. Assigning a role the user already has is a no-op.
+func AddUserRole(ctx context.Context, db *sql.DB, userID int64, role string) error {
+	_, err := db.ExecContext(ctx, `INSERT INTO user_roles (user_id, role, created_at) VALUES (?, ?, ?) ON CONFLICT (user_id, role) DO NOTHING`,
+		userID, role, time.Now().Unix())
+	return err
+}
+
+// RemoveUserRole takes role away from a user.
+func RemoveUserRole(ctx context.Context, db *sql.DB, userID int64, role string) error {
+	_, err := db.ExecContext(ctx, `DELETE FROM user_roles WHERE user_id = ? AND role = ?`, userID, role)
+	return err
+}
@@ -0,0 +1,223 @@
+package models
+
+import (
+	"context"
+	"database/sql"
+	"strings"
+	"time"
+)
+
+// clientColumns lists the clients columns in the order scanClient expects them.
+const clientColumns = `id, client_id, name, redirect_uris, post_logout_redirect_uris, backchannel_logout_uri, frontchannel_logout_uri, scopes, client_secret_hash, token_endpoint_auth_method, require_pkce, first_party, require_par, jwks, jwks_uri, request_uris, require_signed_request_object, subject_type, sector_identifier_uri, sector_identifier, grant_types, response_types, logo_uri, access_token_ttl, id_token_ttl, refresh_token_ttl, registration_token_hash, software_id, created_at, updated_at`
+
+// rowScanner is implemented by *sql.Row and *sql.Rows.
+type rowScanner interface {
+	Scan(dest ...any) error
+}
+
+func scanClient(row rowScanner) (*Client, error) {
+	var c Client
+	var redirectURIs, postLogoutURIs, requestURIs, scopes, grantTypes, responseTypes string
+	var accessTTL, idTTL, refreshTTL int64
+	var createdAtUnix, updatedAtUnix int64
+	if err := row.Scan(&c.ID, &c.ClientID, &c.Name, &redirectURIs, &postLogoutURIs, &c.BackchannelLogoutURI, &c.FrontchannelLogoutURI, &scopes, &c.SecretHash, &c.TokenEndpointAuthMethod, &c.RequirePKCE, &c.FirstParty, &c.RequirePAR, &c.JWKS, &c.JWKSURI, &requestURIs, &c.RequireSignedRequestObject, &c.SubjectType, &c.SectorIdentifierURI, &c.SectorIdentifier,
+		&grantTypes, &responseTypes, &c.LogoURI, &accessTTL, &idTTL, &refreshTTL, &c.RegistrationTokenHash, &c.SoftwareID, &createdAtUnix, &updatedAtUnix); err != nil {
+		return nil, err
+	}
+	c.RedirectURIs = strings.Fields(redirectURIs)
+	c.PostLogoutRedirectURIs = strings.Fields(postLogoutURIs)
+	c.RequestURIs = strings.Fields(requestURIs)
+	c.Scopes = strings.Fields(scopes)
+	c.GrantTypes = strings.Fields(grantTypes)
+	// response types contain spaces ("code id_token"), so they are stored comma-separated
+	c.ResponseTypes = strings.FieldsFunc(responseTypes, func(r rune) bool { return r == ',' })
+	c.AccessTokenTTL = time.Duration(accessTTL) * time.Second
+	c.IDTokenTTL = time.Duration(idTTL) * time.Second
+	c.RefreshTokenTTL = time.Duration(refreshTTL) * time.Second
+	c.CreatedAt = time.Unix(createdAtUnix, 0)
+	c.UpdatedAt = time.Unix(updatedAtUnix, 0)
+	return &c, nil
+}
+
+// GetClientByClientID looks up a registered client by its public client_id.
+// Returns sql.ErrNoRows if the client is unknown.
+func GetClientByClientID(ctx context.Context, db *sql.DB, clientID string) (*Client, error) {
+	return scanClient(db.QueryRowContext(ctx, `SELECT `+clientColumns+` FROM clients WHERE client_id = ? LIMIT 1`, clientID))
+}
+
+// GetClientByID looks up a registered client by its row id. Returns sql.ErrNoRows if not found.
+func GetClientByID(ctx context.Context, db *sql.DB, id int64) (*Client, error) {
+	return scanClient(db.QueryRowContext(ctx, `SELECT `+clientColumns+` FROM clients WHERE id = ? LIMIT 1`, id))
+}
+
+// ListClients returns all registered clients ordered by name.
+func ListClients(ctx context.Context, db *sql.DB) ([]Client, error) {
+	rows, err := db.QueryContext(ctx, `SELECT `+clientColumns+` FROM clients ORDER BY name COLLATE NOCASE, id`)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var clients []Client
+	for rows.Next() {
+		c, err := scanClient(rows)
+		if err != nil {
+			return nil, err
+		}
+		clients = append(clients, *c)
+	}
+	return clients, rows.Err()
+}
+
+// ListSessionClients returns the clients that were issued tokens in a session.
+func ListSessionClients(ctx context.Context, db *sql.DB, sessionID int64) ([]Client, error) {
+	rows, err := db.QueryContext(ctx, `SELECT `+clientColumns+` FROM clients WHERE client_id IN (SELECT client_id FROM session_clients WHERE session_id = ?) ORDER BY name COLLATE NOCASE, id`, sessionID)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var clients []Client
+	for rows.Next() {
+		c, err := scanClient(rows)
+		if err != nil {
+			return nil, err
+		}
+		clients = append(clients, *c)
+	}
+	return clients, rows.Err()
+}
+
+// CreateClient inserts a new client and returns its row id.
+func CreateClient(ctx context.Context, db *sql.DB, c *Client) (int64, error) {
+	now := time.Now().Unix()
+	res, err := db.ExecContext(ctx, `INSERT INTO clients (client_id, name, redirect_uris, post_logout_redirect_uris, backchannel_logout_uri, frontchannel_logout_uri, scopes, client_secret_hash, token_endpoint_auth_method, require_pkce, first_party, require_par, jwks, jwks_uri, request_uris, require_signed_request_object, subject_type, sector_identifier_uri, sector_identifier, grant_types, response_types, logo_uri, access_token_ttl, id_token_ttl, refresh_token_ttl, registration_token_hash, software_id, created_at, updated_at) VALUES (?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?)`,
+		c.ClientID, c.Name, strings.Join(c.RedirectURIs, " "), strings.Join(c.PostLogoutRedirectURIs, " "), c.BackchannelLogoutURI, c.FrontchannelLogoutURI, strings.Join(c.Scopes, " "), c.SecretHash, c.TokenEndpointAuthMethod, c.RequirePKCE, c.FirstParty, c.RequirePAR, c.JWKS, c.JWKSURI, strings.Join(c.RequestURIs, " "), c.RequireSignedRequestObject, c.SubjectType, c.SectorIdentifierURI, c.SectorIdentifier,
+		strings.Join(c.GrantTypes, " "), strings.Join(c.ResponseTypes, ","), c.LogoURI, int64(c.AccessTokenTTL.Seconds()), int64(c.IDTokenTTL.Seconds()), int64(c.RefreshTokenTTL.Seconds()), c.RegistrationTokenHash, c.SoftwareID, now, now)
+	if err != nil {
+		return 0, err
+	}
+	return res.LastInsertId()
+}
+
+// UpdateClient saves every editable field of c (client_id is immutable).
+func UpdateClient(ctx context.Context, db *sql.DB, c *Client) error {
+	_, err := db.ExecContext(ctx, `UPDATE clients SET name = ?, redirect_uris = ?, post_logout_redirect_uris = ?, backchannel_logout_uri = ?, frontchannel_logout_uri = ?, scopes = ?, client_secret_hash = ?, token_endpoint_auth_method = ?, require_pkce = ?, first_party = ?, require_par = ?, jwks = ?, jwks_uri = ?, request_uris = ?, require_signed_request_object = ?, subject_type = ?, sector_identifier_uri = ?, sector_identifier = ?, grant_types = ?, response_types = ?, logo_uri = ?, access_token_ttl = ?, id_token_ttl = ?, refresh_token_ttl = ?, updated_at = ? WHERE id = ?`,
+		c.Name, strings.Join(c.RedirectURIs, " "), strings.Join(c.PostLogoutRedirectURIs, " "), c.BackchannelLogoutURI, c.FrontchannelLogoutURI, strings.Join(c.Scopes, " "), c.SecretHash, c.TokenEndpointAuthMethod, c.RequirePKCE, c.FirstParty, c.RequirePAR, c.JWKS, c.JWKSURI, strings.Join(c.RequestURIs, " "), c.RequireSignedRequestObject, c.SubjectType, c.SectorIdentifierURI, c.SectorIdentifier,
+		strings.Join(c.GrantTypes, " "), strings.Join(c.ResponseTypes, ","), c.LogoURI, int64(c.AccessTokenTTL.Seconds()), int64(c.IDTokenTTL.Seconds()), int64(c.RefreshTokenTTL.Seconds()), time.Now().Unix(), c.ID)
+	return err
+}
+
+// DeleteClient removes a client with its consents, codes, device authorizations and pushed
+// requests, and deactivates every access and refresh token issued to it.
+func DeleteClient(ctx context.Context, db *sql.DB, id int64) error {
+	tx, err := db.BeginTx(ctx, nil)
+	if err != nil {
+		return err
+	}
+	defer func() {
+		_ = tx.Rollback()
+	}()
+
+	if _, err := tx.ExecContext(ctx, `UPDATE access_tokens SET active = 0 WHERE client_id = (SELECT client_id FROM clients WHERE id = ?)`, id); err != nil {
+		return err
+	}
+	if _, err := tx.ExecContext(ctx, `UPDATE refresh_tokens SET active = 0 WHERE client_id = (SELECT client_id FROM clients WHERE id = ?)`, id); err != nil {
+		return err
+	}
+	if _, err := tx.ExecContext(ctx, `DELETE FROM consents WHERE client_id = (SELECT client_id FROM clients WHERE id = ?)`, id); err != nil {
+		return err
+	}
+	if _, err := tx.ExecContext(ctx, `DELETE FROM session_clients WHERE client_id = (SELECT client_id FROM clients WHERE id = ?)`, id); err != nil {
+		return err
+	}
+	if _, err := tx.ExecContext(ctx, `DELETE FROM authorization_codes WHERE client_id = (SELECT client_id FROM clients WHERE id = ?)`, id); err != nil {
+		return err
+	}
+	if _, err := tx.ExecContext(ctx, `DELETE FROM device_authorizations WHERE client_id = (SELECT client_id FROM clients WHERE id = ?)`, id); err != nil {
+		return err
+	}
+	if _, err := tx.ExecContext(ctx, `DELETE FROM pushed_requests WHERE client_id = (SELECT client_id FROM clients WHERE id = ?)`, id); err != nil {
+		return err
+	}
+	if _, err := tx.ExecContext(ctx, `DELETE FROM clients WHERE id = ?`, id); err != nil {
+		return err
+	}
+	return tx.Commit()
+}
+
+// HasRedirectURI reports whether uri exactly matches one of the client's registered redirect URIs.
+func (c *Client) HasRedirectURI(uri string) bool {
+	for _, u := range c.RedirectURIs {
+		if u == uri {
+			return true
+		}
+	}
+	return false
+}
+
+// HasPostLogoutRedirectURI reports whether uri exactly matches one of the client's registered
+// post-logout redirect URIs.
+func (c *Client) HasPostLogoutRedirectURI(uri string) bool {
+	for _, u := range c.PostLogoutRedirectURIs {
+		if u == uri {
+			return true
+		}
+	}
+	return false
+}
+
+// HasRequestURI reports whether uri matches one of the client's registered request URIs. The
+// fragment is ignored, clients may use it to tell request objects apart (OIDC Core section 6.2).
+func (c *Client) HasRequestURI(uri string) bool {
+	base, _, _ := strings.Cut(uri, "#")
+	for _, u := range c.RequestURIs {
+		if u == base {
+			return true
+		}
+	}
+	return false
+}
+
+// AllowsScopes reports whether every requested scope is allowed for the client.
+func (c *Client) AllowsScopes(requested []string) bool {
+	for _, s := range requested {
+		allowed := false
+		for _, a := range c.Scopes {
+			if a == s {
+				allowed = true
+				break
+			}
+		}
+		if !allowed {
+			return false
+		}
+	}
+	return true
+}
+
+// AllowsGrantType reports whether the client is registered for grantType.
+func (c *Client) AllowsGrantType(grantType string) bool {
+	for _, g := range c.GrantTypes {
+		if g == grantType {
+			return true
+		}
+	}
+	return false
+}
+
+// AllowsResponseType reports whether the client may use responseType at /authorize.
+// responseType must be normalized, with its values in alphabetical order.
+func (c *Client) AllowsResponseType(responseType string) bool {
+	for _, rt := range c.ResponseTypes {
+		if rt == responseType {
+			return true
+		}
+	}
+	return false
+}
+
+// IsPublic reports whether the client authenticates without a secret (token_endpoint_auth_method=none).
+func (c *Client) IsPublic() bool {
+	return c.TokenEndpointAuthMethod == "none"
+}
@@ -0,0 +1,62 @@
+package models
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"time"
+)
+
+// ErrCodeAlreadyUsed is returned by ConsumeAuthorizationCode when a code is redeemed twice.
+var ErrCodeAlreadyUsed = errors.New("authorization code already used")
+
+// CreateAuthorizationCode stores a new one-time authorization code (by hash) and returns its id.
+func CreateAuthorizationCode(ctx context.Context, db *sql.DB, code *AuthorizationCode) (int64, error) {
+	res, err := db.ExecContext(ctx, `INSERT INTO authorization_codes (code_hash, client_id, user_id, session_id, redirect_uri, scope, nonce, code_challenge, code_challenge_method, auth_time, claims, created_at, expires_at, used) VALUES (?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, 0)`,
+		code.CodeHash, code.ClientID, code.UserID, code.SessionID, code.RedirectURI, code.Scope, code.Nonce, code.CodeChallenge, code.CodeChallengeMethod, code.AuthTime.Unix(), code.Claims, time.Now().Unix(), code.ExpiresAt.Unix())
+	if err != nil {
+		return 0, err
+	}
+	return res.LastInsertId()
+}
+
+// ConsumeAuthorizationCode atomically marks the code with the given hash as used and returns it.
+// Returns sql.ErrNoRows if the code is unknown or expired, and the code together with
+// ErrCodeAlreadyUsed if it was redeemed before, so callers can revoke tokens issued from it.
+func ConsumeAuthorizationCode(ctx context.Context, db *sql.DB, codeHash string) (*AuthorizationCode, error) {
+	tx, err := db.BeginTx(ctx, nil)
+	if err != nil {
+		return nil, err
+	}
+	defer func() {
+		_ = tx.Rollback()
+	}()
+
+	row := tx.QueryRowContext(ctx, `SELECT id, code_hash, client_id, user_id, session_id, redirect_uri, scope, nonce, code_challenge, code_challenge_method, auth_time, claims, created_at, expires_at, used FROM authorization_codes WHERE code_hash = ? LIMIT 1`, codeHash)
+	var c AuthorizationCode
+	var authTimeUnix, createdAtUnix, expiresAtUnix int64
+	if err := row.Scan(&c.ID, &c.CodeHash, &c.ClientID, &c.UserID, &c.SessionID, &c.RedirectURI, &c.Scope, &c.Nonce, &c.CodeChallenge, &c.CodeChallengeMethod, &authTimeUnix, &c.Claims, &createdAtUnix, &expiresAtUnix, &c.Used); err != nil {
+		return nil, err
+	}
+	c.AuthTime = time.Unix(authTimeUnix, 0)
+	c.CreatedAt = time.Unix(createdAtUnix, 0)
+	c.ExpiresAt = time.Unix(expiresAtUnix, 0)
+	if c.Used {
+		return &c, ErrCodeAlreadyUsed
+	}
+	if time.Now().After(c.ExpiresAt) {
+		return nil, sql.ErrNoRows
+	}
+	res, err := tx.ExecContext(ctx, `UPDATE authorization_codes SET used = 1 WHERE id = ? AND used = 0`, c.ID)
+	if err != nil {
+		return nil, err
+	}
+	if n, err := res.RowsAffected(); err != nil || n != 1 {
+		return &c, ErrCodeAlreadyUsed
+	}
+	if err := tx.Commit(); err != nil {
+		return nil, err
+	}
+	c.Used = true
+	return &c, nil
+}
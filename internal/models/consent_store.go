@@ -0,0 +1,139 @@
+package models
+
+import (
+	"context"
+	"database/sql"
+	"strings"
+	"time"
+)
+
+// GetConsent returns the consent a user gave a client. Returns sql.ErrNoRows if there is none.
+func GetConsent(ctx context.Context, db *sql.DB, userID int64, clientID string) (*Consent, error) {
+	row := db.QueryRowContext(ctx, `SELECT id, user_id, client_id, scopes, created_at, updated_at FROM consents WHERE user_id = ? AND client_id = ? LIMIT 1`, userID, clientID)
+	var c Consent
+	var scopes string
+	var createdAtUnix, updatedAtUnix int64
+	if err := row.Scan(&c.ID, &c.UserID, &c.ClientID, &scopes, &createdAtUnix, &updatedAtUnix); err != nil {
+		return nil, err
+	}
+	c.Scopes = strings.Fields(scopes)
+	c.CreatedAt = time.Unix(createdAtUnix, 0)
+	c.UpdatedAt = time.Unix(updatedAtUnix, 0)
+	return &c, nil
+}
+
+// SaveConsent records that the user granted scopes to the client, adding them to any scopes
+// granted before.
+func SaveConsent(ctx context.Context, db *sql.DB, userID int64, clientID string, scopes []string) error {
+	tx, err := db.BeginTx(ctx, nil)
+	if err != nil {
+		return err
+	}
+	defer func() {
+		_ = tx.Rollback()
+	}()
+
+	var existing string
+	err = tx.QueryRowContext(ctx, `SELECT scopes FROM consents WHERE user_id = ? AND client_id = ?`, userID, clientID).Scan(&existing)
+	if err != nil && err != sql.ErrNoRows {
+		return err
+	}
+	merged := strings.Fields(existing)
+	for _, s := range scopes {
+		found := false
+		for _, m := range merged {
+			if m == s {
+				found = true
+				break
+			}
+		}
+		if !found {
+			merged = append(merged, s)
+		}
+	}
+
+	now := time.Now().Unix()
+	if _, err := tx.ExecContext(ctx, `INSERT INTO consents (user_id, client_id, scopes, created_at, updated_at) VALUES (?, ?, ?, ?, ?)
+		ON CONFLICT (user_id, client_id) DO UPDATE SET scopes = excluded.scopes, updated_at = excluded.updated_at`,
+		userID, clientID, strings.Join(merged, " "), now, now); err != nil {
+		return err
+	}
+	return tx.Commit()
+}
+
+// Covers reports whether every requested scope was already granted.
+func (c *Consent) Covers(requested []string) bool {
+	for _, s := range requested {
+		granted := false
+		for _, g := range c.Scopes {
+			if g == s {
+				granted = true
+				break
+			}
+		}
+		if !granted {
+			return false
+		}
+	}
+	return true
+}
+
+// TouchConsent records that tokens were just issued under the user's consent for the client.
+func TouchConsent(ctx context.Context, db *sql.DB, userID int64, clientID string) error {
+	_, err := db.ExecContext(ctx, `UPDATE consents SET last_used_at = ? WHERE user_id = ? AND client_id = ?`, time.Now().Unix(), userID, clientID)
+	return err
+}
+
+// ListConnectedApps returns the clients the user has consented to, ordered by name.
+func ListConnectedApps(ctx context.Context, db *sql.DB, userID int64) ([]ConnectedApp, error) {
+	rows, err := db.QueryContext(ctx, `SELECT c.client_id, cl.name, cl.logo_uri, c.scopes, c.created_at, c.last_used_at,
+		(SELECT COUNT(*) FROM refresh_tokens rt WHERE rt.user_id = c.user_id AND rt.client_id = c.client_id AND rt.active = 1 AND rt.used = 0 AND rt.expires_at > ?)
+		FROM consents c JOIN clients cl ON cl.client_id = c.client_id
+		WHERE c.user_id = ? ORDER BY cl.name COLLATE NOCASE, c.id`, time.Now().Unix(), userID)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var apps []ConnectedApp
+	for rows.Next() {
+		var a ConnectedApp
+		var scopes string
+		var grantedAtUnix, lastUsedUnix int64
+		if err := rows.Scan(&a.ClientID, &a.Name, &a.LogoURI, &scopes, &grantedAtUnix, &lastUsedUnix, &a.ActiveRefreshTokens); err != nil {
+			return nil, err
+		}
+		a.Scopes = strings.Fields(scopes)
+		a.GrantedAt = time.Unix(grantedAtUnix, 0)
+		if lastUsedUnix > 0 {
+			lastUsed := time.Unix(lastUsedUnix, 0)
+			a.LastUsed = &lastUsed
+		}
+		apps = append(apps, a)
+	}
+	return apps, rows.Err()
+}
+
+// RevokeConsent removes the user's consent for the client and deactivates every token and
+// pending authorization code the client holds for the user.
+func RevokeConsent(ctx context.Context, db *sql.DB, userID int64, clientID string) error {
+	tx, err := db.BeginTx(ctx, nil)
+	if err != nil {
+		return err
+	}
+	defer func() {
+		_ = tx.Rollback()
+	}()
+
+	for _, q := range []string{
+		`DELETE FROM consents WHERE user_id = ? AND client_id = ?`,
+		`UPDATE access_tokens SET active = 0 WHERE user_id = ? AND client_id = ? AND active = 1`,
+		`UPDATE refresh_tokens SET active = 0 WHERE user_id = ? AND client_id = ? AND active = 1`,
+		`UPDATE authorization_codes SET used = 1 WHERE user_id = ? AND client_id = ? AND used = 0`,
+	} {
+		if _, err := tx.ExecContext(ctx, q, userID, clientID); err != nil {
+			return err
+		}
+	}
+	return tx.Commit()
+}
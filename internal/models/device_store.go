@@ -0,0 +1,103 @@
+package models
+
+import (
+	"context"
+	"database/sql"
+	"time"
+)
+
+// Device authorization states.
+const (
+	DevicePending  = "pending"
+	DeviceApproved = "approved"
+	DeviceDenied   = "denied"
+	DeviceUsed     = "used"
+)
+
+// deviceAuthorizationColumns lists the device_authorizations columns in the order scanDeviceAuthorization expects them.
+const deviceAuthorizationColumns = `id, device_code_hash, user_code_hash, client_id, scope, user_id, status, poll_interval, last_polled_at, created_at, expires_at`
+
+func scanDeviceAuthorization(row rowScanner) (*DeviceAuthorization, error) {
+	var d DeviceAuthorization
+	var userID, lastPolled sql.NullInt64
+	var interval, createdAtUnix, expiresAtUnix int64
+	if err := row.Scan(&d.ID, &d.DeviceCodeHash, &d.UserCodeHash, &d.ClientID, &d.Scope, &userID, &d.Status, &interval, &lastPolled, &createdAtUnix, &expiresAtUnix); err != nil {
+		return nil, err
+	}
+	if userID.Valid {
+		d.UserID = &userID.Int64
+	}
+	if lastPolled.Valid {
+		t := time.Unix(lastPolled.Int64, 0)
+		d.LastPolledAt = &t
+	}
+	d.Interval = time.Duration(interval) * time.Second
+	d.CreatedAt = time.Unix(createdAtUnix, 0)
+	d.ExpiresAt = time.Unix(expiresAtUnix, 0)
+	return &d, nil
+}
+
+// CreateDeviceAuthorization stores a new pending device authorization and returns its id.
+func CreateDeviceAuthorization(ctx context.Context, db *sql.DB, d *DeviceAuthorization) (int64, error) {
+	res, err := db.ExecContext(ctx, `INSERT INTO device_authorizations (device_code_hash, user_code_hash, client_id, scope, status, poll_interval, created_at, expires_at) VALUES (?, ?, ?, ?, ?, ?, ?, ?)`,
+		d.DeviceCodeHash, d.UserCodeHash, d.ClientID, d.Scope, DevicePending, int64(d.Interval.Seconds()), time.Now().Unix(), d.ExpiresAt.Unix())
+	if err != nil {
+		return 0, err
+	}
+	return res.LastInsertId()
+}
+
+// GetDeviceAuthorizationByDeviceCode looks up a device authorization in any state by device_code hash.
+// Returns sql.ErrNoRows if it is unknown.
+func GetDeviceAuthorizationByDeviceCode(ctx context.Context, db *sql.DB, deviceCodeHash string) (*DeviceAuthorization, error) {
+	return scanDeviceAuthorization(db.QueryRowContext(ctx, `SELECT `+deviceAuthorizationColumns+` FROM device_authorizations WHERE device_code_hash = ? LIMIT 1`, deviceCodeHash))
+}
+
+// GetPendingDeviceAuthorization looks up the pending, unexpired device authorization with the
+// given user_code hash. Returns sql.ErrNoRows if there is none.
+func GetPendingDeviceAuthorization(ctx context.Context, db *sql.DB, userCodeHash string) (*DeviceAuthorization, error) {
+	return scanDeviceAuthorization(db.QueryRowContext(ctx, `SELECT `+deviceAuthorizationColumns+` FROM device_authorizations WHERE user_code_hash = ? AND status = ? AND expires_at > ? ORDER BY id DESC LIMIT 1`,
+		userCodeHash, DevicePending, time.Now().Unix()))
+}
+
+// DecideDeviceAuthorization records the user's decision on a pending device authorization.
+// Returns sql.ErrNoRows if it was already decided or has expired.
+func DecideDeviceAuthorization(ctx context.Context, db *sql.DB, id, userID int64, approved bool) error {
+	status := DeviceDenied
+	if approved {
+		status = DeviceApproved
+	}
+	res, err := db.ExecContext(ctx, `UPDATE device_authorizations SET status = ?, user_id = ? WHERE id = ? AND status = ? AND expires_at > ?`,
+		status, userID, id, DevicePending, time.Now().Unix())
+	if err != nil {
+		return err
+	}
+	if n, err := res.RowsAffected(); err != nil {
+		return err
+	} else if n == 0 {
+		return sql.ErrNoRows
+	}
+	return nil
+}
+
+// RecordDevicePoll stores the time of a token request for a pending device authorization
+// and the polling interval that applies from now on.
+func RecordDevicePoll(ctx context.Context, db *sql.DB, id int64, at time.Time, interval time.Duration) error {
+	_, err := db.ExecContext(ctx, `UPDATE device_authorizations SET last_polled_at = ?, poll_interval = ? WHERE id = ?`, at.Unix(), int64(interval.Seconds()), id)
+	return err
+}
+
+// ConsumeDeviceAuthorization atomically marks an approved device authorization as used.
+// Returns sql.ErrNoRows if it was not approved or was already used.
+func ConsumeDeviceAuthorization(ctx context.Context, db *sql.DB, id int64) error {
+	res, err := db.ExecContext(ctx, `UPDATE device_authorizations SET status = ? WHERE id = ? AND status = ?`, DeviceUsed, id, DeviceApproved)
+	if err != nil {
+		return err
+	}
+	if n, err := res.RowsAffected(); err != nil {
+		return err
+	} else if n == 0 {
+		return sql.ErrNoRows
+	}
+	return nil
+}
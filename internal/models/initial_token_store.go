@@ -0,0 +1,67 @@
+package models
+
+import (
+	"context"
+	"database/sql"
+	"time"
+)
+
+// CreateInitialAccessToken stores an initial access token (by hash) and returns its id.
+func CreateInitialAccessToken(ctx context.Context, db *sql.DB, t *InitialAccessToken) (int64, error) {
+	res, err := db.ExecContext(ctx, `INSERT INTO initial_access_tokens (token_hash, label, created_by, created_at, expires_at, active) VALUES (?, ?, ?, ?, ?, 1)`,
+		t.TokenHash, t.Label, t.CreatedBy, time.Now().Unix(), t.ExpiresAt.Unix())
+	if err != nil {
+		return 0, err
+	}
+	return res.LastInsertId()
+}
+
+// GetInitialAccessTokenByHash returns an active, unexpired initial access token.
+// Returns sql.ErrNoRows otherwise.
+func GetInitialAccessTokenByHash(ctx context.Context, db *sql.DB, tokenHash string) (*InitialAccessToken, error) {
+	row := db.QueryRowContext(ctx, `SELECT id, token_hash, label, created_by, created_at, expires_at, active FROM initial_access_tokens WHERE token_hash = ? LIMIT 1`, tokenHash)
+	var t InitialAccessToken
+	var createdBy sql.NullInt64
+	var createdAtUnix, expiresAtUnix int64
+	if err := row.Scan(&t.ID, &t.TokenHash, &t.Label, &createdBy, &createdAtUnix, &expiresAtUnix, &t.Active); err != nil {
+		return nil, err
+	}
+	if createdBy.Valid {
+		t.CreatedBy = &createdBy.Int64
+	}
+	t.CreatedAt = time.Unix(createdAtUnix, 0)
+	t.ExpiresAt = time.Unix(expiresAtUnix, 0)
+	if !t.Active || time.Now().After(t.ExpiresAt) {
+		return nil, sql.ErrNoRows
+	}
+	return &t, nil
+}
+
+// ListInitialAccessTokens returns the active, unexpired initial access tokens, newest first.
+func ListInitialAccessTokens(ctx context.Context, db *sql.DB) ([]InitialAccessToken, error) {
+	rows, err := db.QueryContext(ctx, `SELECT id, label, created_at, expires_at FROM initial_access_tokens WHERE active = 1 AND expires_at > ? ORDER BY created_at DESC, id DESC`, time.Now().Unix())
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var tokens []InitialAccessToken
+	for rows.Next() {
+		var t InitialAccessToken
+		var createdAtUnix, expiresAtUnix int64
+		if err := rows.Scan(&t.ID, &t.Label, &createdAtUnix, &expiresAtUnix); err != nil {
+			return nil, err
+		}
+		t.CreatedAt = time.Unix(createdAtUnix, 0)
+		t.ExpiresAt = time.Unix(expiresAtUnix, 0)
+		t.Active = true
+		tokens = append(tokens, t)
+	}
+	return tokens, rows.Err()
+}
+
+// RevokeInitialAccessToken deactivates an initial access token. Clients registered with it are kept.
+func RevokeInitialAccessToken(ctx context.Context, db *sql.DB, id int64) error {
+	_, err := db.ExecContext(ctx, `UPDATE initial_access_tokens SET active = 0 WHERE id = ?`, id)
+	return err
+}
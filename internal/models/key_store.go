@@ -0,0 +1,59 @@
+package models
+
+import (
+	"context"
+	"database/sql"
+	"time"
+)
+
+// ListSigningKeys returns all stored signing keys ordered by activation time.
+func ListSigningKeys(ctx context.Context, db *sql.DB) ([]SigningKey, error) {
+	rows, err := db.QueryContext(ctx, `SELECT id, kid, alg, private_key, created_at, activates_at, retired_at FROM signing_keys ORDER BY activates_at ASC`)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var keys []SigningKey
+	for rows.Next() {
+		var k SigningKey
+		var createdAtUnix, activatesAtUnix int64
+		var retiredAtUnix sql.NullInt64
+		if err := rows.Scan(&k.ID, &k.KID, &k.Alg, &k.PrivateKey, &createdAtUnix, &activatesAtUnix, &retiredAtUnix); err != nil {
+			return nil, err
+		}
+		k.CreatedAt = time.Unix(createdAtUnix, 0)
+		k.ActivatesAt = time.Unix(activatesAtUnix, 0)
+		if retiredAtUnix.Valid {
+			t := time.Unix(retiredAtUnix.Int64, 0)
+			k.RetiredAt = &t
+		}
+		keys = append(keys, k)
+	}
+	return keys, rows.Err()
+}
+
+// CreateSigningKey stores a new signing key and returns its id.
+func CreateSigningKey(ctx context.Context, db *sql.DB, k *SigningKey) (int64, error) {
+	res, err := db.ExecContext(ctx, `INSERT INTO signing_keys (kid, alg, private_key, created_at, activates_at) VALUES (?, ?, ?, ?, ?)`,
+		k.KID, k.Alg, k.PrivateKey, time.Now().Unix(), k.ActivatesAt.Unix())
+	if err != nil {
+		return 0, err
+	}
+	return res.LastInsertId()
+}
+
+// RetireSigningKey records when a key stopped being used for signing.
+func RetireSigningKey(ctx context.Context, db *sql.DB, id int64, retiredAt time.Time) error {
+	_, err := db.ExecContext(ctx, `UPDATE signing_keys SET retired_at = ? WHERE id = ? AND retired_at IS NULL`, retiredAt.Unix(), id)
+	return err
+}
+
+// DeleteSigningKeysRetiredBefore removes keys retired before cutoff. Returns number of rows deleted.
+func DeleteSigningKeysRetiredBefore(ctx context.Context, db *sql.DB, cutoff time.Time) (int64, error) {
+	res, err := db.ExecContext(ctx, `DELETE FROM signing_keys WHERE retired_at IS NOT NULL AND retired_at <= ?`, cutoff.Unix())
+	if err != nil {
+		return 0, err
+	}
+	return res.RowsAffected()
+}
@@ -0,0 +1,80 @@
+package models
+
+import (
+	"context"
+	"database/sql"
+	"time"
+)
+
+// Back-channel logout delivery states.
+const (
+	LogoutPending   = "pending"
+	LogoutDelivered = "delivered"
+	LogoutFailed    = "failed"
+)
+
+// logoutDeliveryColumns lists the logout_deliveries columns in the order scanLogoutDelivery expects them.
+const logoutDeliveryColumns = `id, session_id, user_id, client_id, uri, jti, status, attempts, last_error, next_attempt_at, created_at, delivered_at`
+
+func scanLogoutDelivery(row rowScanner) (*LogoutDelivery, error) {
+	var d LogoutDelivery
+	var nextAttemptUnix, createdAtUnix int64
+	var deliveredAt sql.NullInt64
+	if err := row.Scan(&d.ID, &d.SessionID, &d.UserID, &d.ClientID, &d.URI, &d.JTI, &d.Status, &d.Attempts, &d.LastError, &nextAttemptUnix, &createdAtUnix, &deliveredAt); err != nil {
+		return nil, err
+	}
+	d.NextAttemptAt = time.Unix(nextAttemptUnix, 0)
+	d.CreatedAt = time.Unix(createdAtUnix, 0)
+	if deliveredAt.Valid {
+		t := time.Unix(deliveredAt.Int64, 0)
+		d.DeliveredAt = &t
+	}
+	return &d, nil
+}
+
+func listLogoutDeliveries(ctx context.Context, db *sql.DB, query string, args ...any) ([]LogoutDelivery, error) {
+	rows, err := db.QueryContext(ctx, query, args...)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var deliveries []LogoutDelivery
+	for rows.Next() {
+		d, err := scanLogoutDelivery(rows)
+		if err != nil {
+			return nil, err
+		}
+		deliveries = append(deliveries, *d)
+	}
+	return deliveries, rows.Err()
+}
+
+// DueLogoutDeliveries returns up to limit pending deliveries whose next attempt is due at now, oldest first.
+func DueLogoutDeliveries(ctx context.Context, db *sql.DB, now time.Time, limit int) ([]LogoutDelivery, error) {
+	return listLogoutDeliveries(ctx, db, `SELECT `+logoutDeliveryColumns+` FROM logout_deliveries WHERE status = ? AND next_attempt_at <= ? ORDER BY next_attempt_at, id LIMIT ?`,
+		LogoutPending, now.Unix(), limit)
+}
+
+// ListRecentLogoutDeliveries returns the latest limit deliveries, newest first.
+func ListRecentLogoutDeliveries(ctx context.Context, db *sql.DB, limit int) ([]LogoutDelivery, error) {
+	return listLogoutDeliveries(ctx, db, `SELECT `+logoutDeliveryColumns+` FROM logout_deliveries ORDER BY created_at DESC, id DESC LIMIT ?`, limit)
+}
+
+// MarkLogoutDelivered records a successful delivery attempt.
+func MarkLogoutDelivered(ctx context.Context, db *sql.DB, id int64) error {
+	now := time.Now().Unix()
+	_, err := db.ExecContext(ctx, `UPDATE logout_deliveries SET status = ?, attempts = attempts + 1, last_error = '', delivered_at = ? WHERE id = ?`, LogoutDelivered, now, id)
+	return err
+}
+
+// MarkLogoutAttemptFailed records a failed delivery attempt. The delivery is retried at next,
+// or given up on when next is nil.
+func MarkLogoutAttemptFailed(ctx context.Context, db *sql.DB, id int64, lastError string, next *time.Time) error {
+	if next == nil {
+		_, err := db.ExecContext(ctx, `UPDATE logout_deliveries SET status = ?, attempts = attempts + 1, last_error = ? WHERE id = ?`, LogoutFailed, lastError, id)
+		return err
+	}
+	_, err := db.ExecContext(ctx, `UPDATE logout_deliveries SET attempts = attempts + 1, last_error = ?, next_attempt_at = ? WHERE id = ?`, lastError, next.Unix(), id)
+	return err
+}
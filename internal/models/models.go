@@ -18,3 +18,262 @@ type Session struct {
 	ExpiresAt time.Time `json:"expires_at"`
 	Active    bool      `json:"active"`
 }
+
+// Client is an OAuth 2.0 / OpenID Connect relying party registered with the provider.
+// List-valued fields are stored space-separated in the database.
+type Client struct {
+	ID           int64    `json:"id"`
+	ClientID     string   `json:"client_id"`
+	Name         string   `json:"name"`
+	RedirectURIs []string `json:"redirect_uris"`
+	// PostLogoutRedirectURIs are the allowed targets of RP-initiated logout.
+	PostLogoutRedirectURIs []string `json:"post_logout_redirect_uris"`
+	// BackchannelLogoutURI receives a logout token when a session the client took part in ends.
+	BackchannelLogoutURI string `json:"backchannel_logout_uri"`
+	// FrontchannelLogoutURI is loaded in an iframe on the end-session page when such a session ends.
+	FrontchannelLogoutURI string   `json:"frontchannel_logout_uri"`
+	Scopes                []string `json:"scopes"`
+	// SecretHash is the HMAC of the client secret; empty for public clients.
+	SecretHash              string `json:"-"`
+	TokenEndpointAuthMethod string `json:"token_endpoint_auth_method"`
+	// RequirePKCE rejects authorization requests without a code_challenge (RFC 7636).
+	RequirePKCE bool `json:"require_pkce"`
+	// RequirePAR rejects authorization requests that were not pushed to /par first (RFC 9126).
+	RequirePAR bool `json:"require_par"`
+	// JWKS (a JSON JWK set) or JWKSURI hold the client's keys for verifying request objects (RFC 9101).
+	JWKS    string `json:"jwks,omitempty"`
+	JWKSURI string `json:"jwks_uri,omitempty"`
+	// RequestURIs are the URLs request objects may be fetched from by reference.
+	RequestURIs []string `json:"request_uris,omitempty"`
+	// RequireSignedRequestObject rejects authorization requests without a signed request object.
+	RequireSignedRequestObject bool `json:"require_signed_request_object"`
+	// SubjectType is "public" (sub is the hex pubkey) or "pairwise" (sub is derived per sector).
+	SubjectType string `json:"subject_type"`
+	// SectorIdentifierURI lists the redirect URIs of the clients sharing its host as pairwise sector.
+	SectorIdentifierURI string `json:"sector_identifier_uri,omitempty"`
+	// SectorIdentifier is the host pairwise subjects are derived for, fixed at registration.
+	SectorIdentifier string `json:"-"`
+	// FirstParty clients are operated by us and skip the consent screen.
+	FirstParty bool     `json:"first_party"`
+	GrantTypes []string `json:"grant_types"`
+	// ResponseTypes are the response types the client may use at /authorize; anything but
+	// "code" must be allowed explicitly by an admin.
+	ResponseTypes []string `json:"response_types"`
+	LogoURI       string   `json:"logo_uri"`
+	// AccessTokenTTL, IDTokenTTL and RefreshTokenTTL override the provider defaults when non-zero.
+	AccessTokenTTL  time.Duration `json:"access_token_ttl"`
+	IDTokenTTL      time.Duration `json:"id_token_ttl"`
+	RefreshTokenTTL time.Duration `json:"refresh_token_ttl"`
+	// RegistrationTokenHash is the HMAC of the RFC 7592 registration access token;
+	// empty for clients created by an admin, which cannot manage themselves.
+	RegistrationTokenHash string    `json:"-"`
+	SoftwareID            string    `json:"software_id,omitempty"`
+	CreatedAt             time.Time `json:"created_at"`
+	UpdatedAt             time.Time `json:"updated_at"`
+}
+
+// AuthorizationCode is a one-time code issued by the authorization endpoint.
+type AuthorizationCode struct {
+	ID          int64  `json:"id"`
+	CodeHash    string `json:"code_hash"`
+	ClientID    string `json:"client_id"`
+	UserID      int64  `json:"user_id"`
+	SessionID   int64  `json:"session_id"`
+	RedirectURI string `json:"redirect_uri"`
+	Scope       string `json:"scope"`
+	Nonce       string `json:"nonce"`
+	// CodeChallenge and CodeChallengeMethod are set when the request used PKCE.
+	CodeChallenge       string `json:"code_challenge"`
+	CodeChallengeMethod string `json:"code_challenge_method"`
+	// AuthTime is when the user signed in to the session the code was issued in.
+	AuthTime time.Time `json:"auth_time"`
+	// Claims is the claims parameter of the request as compact JSON, empty if none was sent.
+	Claims    string    `json:"claims,omitempty"`
+	CreatedAt time.Time `json:"created_at"`
+	ExpiresAt time.Time `json:"expires_at"`
+	Used      bool      `json:"used"`
+}
+
+// AccessToken is an opaque bearer token issued by the token endpoint, stored by hash.
+// UserID and SessionID are nil for tokens issued without an end-user.
+type AccessToken struct {
+	ID                  int64  `json:"id"`
+	TokenHash           string `json:"token_hash"`
+	ClientID            string `json:"client_id"`
+	UserID              *int64 `json:"user_id,omitempty"`
+	SessionID           *int64 `json:"session_id,omitempty"`
+	AuthorizationCodeID *int64 `json:"authorization_code_id,omitempty"`
+	// RefreshFamilyID links the token to the refresh token family it was issued in, if any.
+	RefreshFamilyID string `json:"refresh_family_id,omitempty"`
+	Scope           string `json:"scope"`
+	// DPoPJKT is the thumbprint of the DPoP key the token is bound to (RFC 9449); empty for bearer tokens.
+	DPoPJKT string `json:"dpop_jkt,omitempty"`
+	// Claims are the claims requested for userinfo with the claims parameter, as compact JSON.
+	Claims    string    `json:"claims,omitempty"`
+	CreatedAt time.Time `json:"created_at"`
+	ExpiresAt time.Time `json:"expires_at"`
+	Active    bool      `json:"active"`
+}
+
+// RefreshToken is a rotating refresh token, stored by hash. Used is set once it has been
+// exchanged; presenting a used token again revokes its whole family.
+type RefreshToken struct {
+	ID                  int64  `json:"id"`
+	TokenHash           string `json:"-"`
+	FamilyID            string `json:"family_id"`
+	ParentID            *int64 `json:"parent_id,omitempty"`
+	ClientID            string `json:"client_id"`
+	UserID              int64  `json:"user_id"`
+	AuthorizationCodeID *int64 `json:"authorization_code_id,omitempty"`
+	Scope               string `json:"scope"`
+	// DPoPJKT binds the tokens of public clients to the DPoP key they were first issued for.
+	DPoPJKT string `json:"dpop_jkt,omitempty"`
+	// AuthTime is the auth_time of the authorization the token family started from.
+	AuthTime time.Time `json:"auth_time"`
+	// Claims is the claims parameter of the authorization the token family started from.
+	Claims    string    `json:"claims,omitempty"`
+	CreatedAt time.Time `json:"created_at"`
+	ExpiresAt time.Time `json:"expires_at"`
+	Used      bool      `json:"used"`
+	Active    bool      `json:"active"`
+}
+
+// SigningKey is a provider key pair used to sign tokens. PrivateKey is PKCS#8 DER.
+// RetiredAt is nil until a newer key takes over signing.
+type SigningKey struct {
+	ID          int64      `json:"id"`
+	KID         string     `json:"kid"`
+	Alg         string     `json:"alg"`
+	PrivateKey  []byte     `json:"-"`
+	CreatedAt   time.Time  `json:"created_at"`
+	ActivatesAt time.Time  `json:"activates_at"`
+	RetiredAt   *time.Time `json:"retired_at,omitempty"`
+}
+
+// Profile is the latest verified kind-0 metadata event of a user. Content is the raw event content JSON.
+type Profile struct {
+	UserID         int64     `json:"user_id"`
+	EventID        string    `json:"event_id"`
+	Content        string    `json:"content"`
+	EventCreatedAt time.Time `json:"event_created_at"`
+	FetchedAt      time.Time `json:"fetched_at"`
+	// NIP05Verified is the NIP-05 identifier of the profile once its domain confirmed it, or "".
+	// NIP05CheckedAt is zero until it was checked for the current content.
+	NIP05Verified  string    `json:"nip05_verified,omitempty"`
+	NIP05CheckedAt time.Time `json:"nip05_checked_at"`
+}
+
+// InitialAccessToken authorizes dynamic client registration (RFC 7591 section 3). Stored by hash.
+type InitialAccessToken struct {
+	ID        int64     `json:"id"`
+	TokenHash string    `json:"-"`
+	Label     string    `json:"label"`
+	CreatedBy *int64    `json:"created_by,omitempty"`
+	CreatedAt time.Time `json:"created_at"`
+	ExpiresAt time.Time `json:"expires_at"`
+	Active    bool      `json:"active"`
+}
+
+// Consent records the scopes a user has granted to a client. Scopes only ever grow
+// until the consent is revoked.
+type Consent struct {
+	ID        int64     `json:"id"`
+	UserID    int64     `json:"user_id"`
+	ClientID  string    `json:"client_id"`
+	Scopes    []string  `json:"scopes"`
+	CreatedAt time.Time `json:"created_at"`
+	UpdatedAt time.Time `json:"updated_at"`
+}
+
+// ConnectedApp is a client the user has consented to, as listed on the dashboard.
+type ConnectedApp struct {
+	ClientID  string     `json:"client_id"`
+	Name      string     `json:"name"`
+	LogoURI   string     `json:"logo_uri"`
+	Scopes    []string   `json:"scopes"`
+	GrantedAt time.Time  `json:"granted_at"`
+	LastUsed  *time.Time `json:"last_used,omitempty"`
+	// ActiveRefreshTokens counts refresh tokens that can still be exchanged.
+	ActiveRefreshTokens int `json:"active_refresh_tokens"`
+}
+
+// LogoutDelivery is a back-channel logout notification (OIDC Back-Channel Logout 1.0) queued
+// for a client when a session ends. JTI is reused for every attempt of the same notification.
+type LogoutDelivery struct {
+	ID        int64  `json:"id"`
+	SessionID int64  `json:"session_id"`
+	UserID    int64  `json:"user_id"`
+	ClientID  string `json:"client_id"`
+	URI       string `json:"uri"`
+	JTI       string `json:"jti"`
+	// Status is LogoutPending, LogoutDelivered or LogoutFailed.
+	Status        string     `json:"status"`
+	Attempts      int        `json:"attempts"`
+	LastError     string     `json:"last_error,omitempty"`
+	NextAttemptAt time.Time  `json:"next_attempt_at"`
+	CreatedAt     time.Time  `json:"created_at"`
+	DeliveredAt   *time.Time `json:"delivered_at,omitempty"`
+}
+
+// ActiveSession is a live browser session as listed for admins.
+type ActiveSession struct {
+	ID        int64     `json:"id"`
+	UserID    int64     `json:"user_id"`
+	PublicKey string    `json:"public_key"`
+	CreatedAt time.Time `json:"created_at"`
+	ExpiresAt time.Time `json:"expires_at"`
+	// Clients are the client_ids that were issued tokens in the session.
+	Clients []string `json:"clients"`
+}
+
+// DeviceAuthorization is a pending device authorization grant (RFC 8628). UserID is set once
+// a user approved or denied it.
+type DeviceAuthorization struct {
+	ID             int64  `json:"id"`
+	DeviceCodeHash string `json:"-"`
+	UserCodeHash   string `json:"-"`
+	ClientID       string `json:"client_id"`
+	Scope          string `json:"scope"`
+	UserID         *int64 `json:"user_id,omitempty"`
+	// Status is DevicePending, DeviceApproved, DeviceDenied or DeviceUsed.
+	Status string `json:"status"`
+	// Interval is the minimum time between polls; it grows when the client polls too fast.
+	Interval     time.Duration `json:"interval"`
+	LastPolledAt *time.Time    `json:"last_polled_at,omitempty"`
+	CreatedAt    time.Time     `json:"created_at"`
+	ExpiresAt    time.Time     `json:"expires_at"`
+}
+
+// PushedRequest is an authorization request pushed by a client (RFC 9126), stored under the
+// hash of the request_uri returned for it. Params is the form-encoded request.
+type PushedRequest struct {
+	ID             int64     `json:"id"`
+	RequestURIHash string    `json:"-"`
+	ClientID       string    `json:"client_id"`
+	Params         string    `json:"params"`
+	CreatedAt      time.Time `json:"created_at"`
+	ExpiresAt      time.Time `json:"expires_at"`
+	// ResolvedAt is when /authorize first used the request, zero until then.
+	ResolvedAt time.Time `json:"resolved_at"`
+	Used       bool      `json:"used"`
+}
+
+// ClaimMapper releases a claim computed from Source when Scope is granted. IDToken and UserInfo
+// say where it is released unless the client asks for it elsewhere with the claims parameter.
+type ClaimMapper struct {
+	ID        int64     `json:"id"`
+	Claim     string    `json:"claim"`
+	Source    string    `json:"source"`
+	Scope     string    `json:"scope"`
+	IDToken   bool      `json:"id_token"`
+	UserInfo  bool      `json:"userinfo"`
+	CreatedAt time.Time `json:"created_at"`
+}
+
+// UserRole is a local role assigned to a user, as listed for admins.
+type UserRole struct {
+	UserID    int64     `json:"user_id"`
+	PublicKey string    `json:"public_key"`
+	Role      string    `json:"role"`
+	CreatedAt time.Time `json:"created_at"`
+}
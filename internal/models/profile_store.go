@@ -0,0 +1,45 @@
+package models
+
+import (
+	"context"
+	"database/sql"
+	"time"
+)
+
+// GetProfile returns the stored profile of a user. Returns sql.ErrNoRows if none was saved yet.
+func GetProfile(ctx context.Context, db *sql.DB, userID int64) (*Profile, error) {
+	row := db.QueryRowContext(ctx, `SELECT user_id, event_id, content, event_created_at, fetched_at, nip05_verified, nip05_checked_at FROM profiles WHERE user_id = ?`, userID)
+	var p Profile
+	var eventCreatedAtUnix, fetchedAtUnix, checkedAtUnix int64
+	if err := row.Scan(&p.UserID, &p.EventID, &p.Content, &eventCreatedAtUnix, &fetchedAtUnix, &p.NIP05Verified, &checkedAtUnix); err != nil {
+		return nil, err
+	}
+	p.EventCreatedAt = time.Unix(eventCreatedAtUnix, 0)
+	p.FetchedAt = time.Unix(fetchedAtUnix, 0)
+	if checkedAtUnix > 0 {
+		p.NIP05CheckedAt = time.Unix(checkedAtUnix, 0)
+	}
+	return &p, nil
+}
+
+// SaveProfile stores p unless a newer event is already saved for the user; fetched_at is always refreshed.
+// A change of content drops the NIP-05 verification, which has to be redone for the new identifier.
+func SaveProfile(ctx context.Context, db *sql.DB, p *Profile) error {
+	_, err := db.ExecContext(ctx, `INSERT INTO profiles (user_id, event_id, content, event_created_at, fetched_at) VALUES (?, ?, ?, ?, ?)
+		ON CONFLICT (user_id) DO UPDATE SET
+			event_id = CASE WHEN excluded.event_created_at >= profiles.event_created_at THEN excluded.event_id ELSE profiles.event_id END,
+			nip05_verified = CASE WHEN excluded.event_created_at >= profiles.event_created_at AND excluded.content != profiles.content THEN '' ELSE profiles.nip05_verified END,
+			nip05_checked_at = CASE WHEN excluded.event_created_at >= profiles.event_created_at AND excluded.content != profiles.content THEN 0 ELSE profiles.nip05_checked_at END,
+			content = CASE WHEN excluded.event_created_at >= profiles.event_created_at THEN excluded.content ELSE profiles.content END,
+			event_created_at = MAX(excluded.event_created_at, profiles.event_created_at),
+			fetched_at = excluded.fetched_at`,
+		p.UserID, p.EventID, p.Content, p.EventCreatedAt.Unix(), time.Now().Unix())
+	return err
+}
+
+// SetProfileNIP05 records the outcome of checking the NIP-05 identifier of a user's profile:
+// the verified identifier, or "" if it did not check out.
+func SetProfileNIP05(ctx context.Context, db *sql.DB, userID int64, verified string) error {
+	_, err := db.ExecContext(ctx, `UPDATE profiles SET nip05_verified = ?, nip05_checked_at = ? WHERE user_id = ?`, verified, time.Now().Unix(), userID)
+	return err
+}
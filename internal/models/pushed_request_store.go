@@ -0,0 +1,60 @@
+package models
+
+import (
+	"context"
+	"database/sql"
+	"time"
+)
+
+// CreatePushedRequest stores a pushed authorization request and returns its id.
+func CreatePushedRequest(ctx context.Context, db *sql.DB, p *PushedRequest) (int64, error) {
+	res, err := db.ExecContext(ctx, `INSERT INTO pushed_requests (request_uri_hash, client_id, params, created_at, expires_at, used) VALUES (?, ?, ?, ?, ?, 0)`,
+		p.RequestURIHash, p.ClientID, p.Params, time.Now().Unix(), p.ExpiresAt.Unix())
+	if err != nil {
+		return 0, err
+	}
+	return res.LastInsertId()
+}
+
+// GetPushedRequest looks up a pushed authorization request by request_uri hash. Returns
+// sql.ErrNoRows if it is unknown, expired or already used.
+func GetPushedRequest(ctx context.Context, db *sql.DB, requestURIHash string) (*PushedRequest, error) {
+	row := db.QueryRowContext(ctx, `SELECT id, request_uri_hash, client_id, params, created_at, expires_at, resolved_at, used FROM pushed_requests WHERE request_uri_hash = ? LIMIT 1`, requestURIHash)
+	var p PushedRequest
+	var createdAtUnix, expiresAtUnix, resolvedAtUnix int64
+	if err := row.Scan(&p.ID, &p.RequestURIHash, &p.ClientID, &p.Params, &createdAtUnix, &expiresAtUnix, &resolvedAtUnix, &p.Used); err != nil {
+		return nil, err
+	}
+	p.CreatedAt = time.Unix(createdAtUnix, 0)
+	p.ExpiresAt = time.Unix(expiresAtUnix, 0)
+	if resolvedAtUnix != 0 {
+		p.ResolvedAt = time.Unix(resolvedAtUnix, 0)
+	}
+	if p.Used || time.Now().After(p.ExpiresAt) {
+		return nil, sql.ErrNoRows
+	}
+	return &p, nil
+}
+
+// ResolvePushedRequest records that /authorize first used pushed request id and keeps it
+// usable until expiresAt, so the user has time to log in and consent. It does nothing if the
+// request was resolved before.
+func ResolvePushedRequest(ctx context.Context, db *sql.DB, id int64, expiresAt time.Time) error {
+	_, err := db.ExecContext(ctx, `UPDATE pushed_requests SET resolved_at = ?, expires_at = ? WHERE id = ? AND resolved_at = 0`, time.Now().Unix(), expiresAt.Unix(), id)
+	return err
+}
+
+// ConsumePushedRequest atomically marks a pushed authorization request as used.
+// Returns sql.ErrNoRows if it was used before.
+func ConsumePushedRequest(ctx context.Context, db *sql.DB, id int64) error {
+	res, err := db.ExecContext(ctx, `UPDATE pushed_requests SET used = 1 WHERE id = ? AND used = 0`, id)
+	if err != nil {
+		return err
+	}
+	if n, err := res.RowsAffected(); err != nil {
+		return err
+	} else if n == 0 {
+		return sql.ErrNoRows
+	}
+	return nil
+}
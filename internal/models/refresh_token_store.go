@@ -0,0 +1,144 @@
+package models
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"time"
+)
+
+// ErrRefreshTokenReused is returned by ConsumeRefreshToken when a rotated token is presented again.
+var ErrRefreshTokenReused = errors.New("refresh token already used")
+
+// refreshTokenColumns lists the refresh_tokens columns in the order scanRefreshToken expects them.
+const refreshTokenColumns = `id, token_hash, family_id, parent_id, client_id, user_id, authorization_code_id, scope, dpop_jkt, auth_time, claims, created_at, expires_at, used, active`
+
+func scanRefreshToken(row rowScanner) (*RefreshToken, error) {
+	var t RefreshToken
+	var parentID, codeID sql.NullInt64
+	var authTimeUnix, createdAtUnix, expiresAtUnix int64
+	if err := row.Scan(&t.ID, &t.TokenHash, &t.FamilyID, &parentID, &t.ClientID, &t.UserID, &codeID, &t.Scope, &t.DPoPJKT, &authTimeUnix, &t.Claims, &createdAtUnix, &expiresAtUnix, &t.Used, &t.Active); err != nil {
+		return nil, err
+	}
+	if parentID.Valid {
+		t.ParentID = &parentID.Int64
+	}
+	if codeID.Valid {
+		t.AuthorizationCodeID = &codeID.Int64
+	}
+	t.AuthTime = time.Unix(authTimeUnix, 0)
+	t.CreatedAt = time.Unix(createdAtUnix, 0)
+	t.ExpiresAt = time.Unix(expiresAtUnix, 0)
+	return &t, nil
+}
+
+// GetRefreshTokenByHash returns a refresh token in any state without consuming it.
+// Returns sql.ErrNoRows if the token is unknown.
+func GetRefreshTokenByHash(ctx context.Context, db *sql.DB, tokenHash string) (*RefreshToken, error) {
+	return scanRefreshToken(db.QueryRowContext(ctx, `SELECT `+refreshTokenColumns+` FROM refresh_tokens WHERE token_hash = ? LIMIT 1`, tokenHash))
+}
+
+// CreateRefreshToken stores a refresh token (by hash) and returns its id.
+func CreateRefreshToken(ctx context.Context, db *sql.DB, t *RefreshToken) (int64, error) {
+	res, err := db.ExecContext(ctx, `INSERT INTO refresh_tokens (token_hash, family_id, parent_id, client_id, user_id, authorization_code_id, scope, dpop_jkt, auth_time, claims, created_at, expires_at, used, active) VALUES (?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, 0, 1)`,
+		t.TokenHash, t.FamilyID, t.ParentID, t.ClientID, t.UserID, t.AuthorizationCodeID, t.Scope, t.DPoPJKT, t.AuthTime.Unix(), t.Claims, time.Now().Unix(), t.ExpiresAt.Unix())
+	if err != nil {
+		return 0, err
+	}
+	return res.LastInsertId()
+}
+
+// ConsumeRefreshToken atomically marks the refresh token with the given hash as used and returns it.
+// Returns sql.ErrNoRows if the token is unknown, revoked or expired, and the token together with
+// ErrRefreshTokenReused if it was rotated before, so callers can revoke its family.
+func ConsumeRefreshToken(ctx context.Context, db *sql.DB, tokenHash string) (*RefreshToken, error) {
+	tx, err := db.BeginTx(ctx, nil)
+	if err != nil {
+		return nil, err
+	}
+	defer func() {
+		_ = tx.Rollback()
+	}()
+
+	t, err := scanRefreshToken(tx.QueryRowContext(ctx, `SELECT `+refreshTokenColumns+` FROM refresh_tokens WHERE token_hash = ? LIMIT 1`, tokenHash))
+	if err != nil {
+		return nil, err
+	}
+	if !t.Active {
+		return nil, sql.ErrNoRows
+	}
+	if t.Used {
+		return t, ErrRefreshTokenReused
+	}
+	if time.Now().After(t.ExpiresAt) {
+		return nil, sql.ErrNoRows
+	}
+	res, err := tx.ExecContext(ctx, `UPDATE refresh_tokens SET used = 1 WHERE id = ? AND used = 0`, t.ID)
+	if err != nil {
+		return nil, err
+	}
+	if n, err := res.RowsAffected(); err != nil || n != 1 {
+		return t, ErrRefreshTokenReused
+	}
+	if err := tx.Commit(); err != nil {
+		return nil, err
+	}
+	t.Used = true
+	return t, nil
+}
+
+// RevokeRefreshTokenFamily deactivates every refresh token of a family and the access tokens
+// issued in it. It returns the number of refresh tokens that were still active.
+func RevokeRefreshTokenFamily(ctx context.Context, db *sql.DB, familyID string) (int64, error) {
+	tx, err := db.BeginTx(ctx, nil)
+	if err != nil {
+		return 0, err
+	}
+	defer func() {
+		_ = tx.Rollback()
+	}()
+
+	res, err := tx.ExecContext(ctx, `UPDATE refresh_tokens SET active = 0 WHERE family_id = ? AND active = 1`, familyID)
+	if err != nil {
+		return 0, err
+	}
+	n, err := res.RowsAffected()
+	if err != nil {
+		return 0, err
+	}
+	if _, err := tx.ExecContext(ctx, `UPDATE access_tokens SET active = 0 WHERE refresh_family_id = ? AND active = 1`, familyID); err != nil {
+		return 0, err
+	}
+	return n, tx.Commit()
+}
+
+// RevokeRefreshTokenFamiliesByCode revokes the refresh token families started by an authorization code.
+func RevokeRefreshTokenFamiliesByCode(ctx context.Context, db *sql.DB, codeID int64) (int64, error) {
+	rows, err := db.QueryContext(ctx, `SELECT DISTINCT family_id FROM refresh_tokens WHERE authorization_code_id = ?`, codeID)
+	if err != nil {
+		return 0, err
+	}
+	var families []string
+	for rows.Next() {
+		var f string
+		if err := rows.Scan(&f); err != nil {
+			rows.Close()
+			return 0, err
+		}
+		families = append(families, f)
+	}
+	rows.Close()
+	if err := rows.Err(); err != nil {
+		return 0, err
+	}
+
+	var total int64
+	for _, f := range families {
+		n, err := RevokeRefreshTokenFamily(ctx, db, f)
+		if err != nil {
+			return total, err
+		}
+		total += n
+	}
+	return total, nil
+}
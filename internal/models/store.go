@@ -3,6 +3,7 @@ package models
 import (
 	"context"
 	"database/sql"
+	"strings"
 	"time"
 )
 
@@ -92,6 +93,74 @@ func GetSessionByHash(ctx context.Context, db *sql.DB, tokenHash string) (*Sessi
 	return &s, nil
 }
 
+// EndSession deactivates a session and queues a back-channel logout notification for every
+// client that was issued tokens in it and registered a backchannel_logout_uri. It returns the
+// number of notifications queued; ending a session that is already inactive queues none.
+func EndSession(ctx context.Context, db *sql.DB, id int64) (int64, error) {
+	tx, err := db.BeginTx(ctx, nil)
+	if err != nil {
+		return 0, err
+	}
+	defer func() {
+		_ = tx.Rollback()
+	}()
+
+	res, err := tx.ExecContext(ctx, `UPDATE sessions SET active = 0 WHERE id = ? AND active = 1`, id)
+	if err != nil {
+		return 0, err
+	}
+	if n, err := res.RowsAffected(); err != nil || n == 0 {
+		return 0, err
+	}
+	now := time.Now().Unix()
+	res, err = tx.ExecContext(ctx, `INSERT INTO logout_deliveries (session_id, user_id, client_id, uri, jti, status, attempts, last_error, next_attempt_at, created_at)
+		SELECT s.id, s.user_id, c.client_id, c.backchannel_logout_uri, lower(hex(randomblob(16))), ?, 0, '', ?, ?
+		FROM session_clients sc JOIN sessions s ON s.id = sc.session_id JOIN clients c ON c.client_id = sc.client_id
+		WHERE sc.session_id = ? AND c.backchannel_logout_uri != ''`, LogoutPending, now, now, id)
+	if err != nil {
+		return 0, err
+	}
+	queued, err := res.RowsAffected()
+	if err != nil {
+		return 0, err
+	}
+	return queued, tx.Commit()
+}
+
+// AddSessionClient records that client was issued tokens in the session.
+func AddSessionClient(ctx context.Context, db *sql.DB, sessionID int64, clientID string) error {
+	_, err := db.ExecContext(ctx, `INSERT OR IGNORE INTO session_clients (session_id, client_id, created_at) VALUES (?, ?, ?)`, sessionID, clientID, time.Now().Unix())
+	return err
+}
+
+// ListActiveSessions returns the sessions that are active and not expired, newest first.
+func ListActiveSessions(ctx context.Context, db *sql.DB) ([]ActiveSession, error) {
+	rows, err := db.QueryContext(ctx, `SELECT s.id, s.user_id, u.public_key, s.created_at, s.expires_at,
+			COALESCE((SELECT group_concat(client_id, ' ') FROM session_clients WHERE session_id = s.id), '')
+		FROM sessions s JOIN users u ON u.id = s.user_id
+		WHERE s.active = 1 AND s.expires_at > ?
+		ORDER BY s.created_at DESC, s.id DESC`, time.Now().Unix())
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var sessions []ActiveSession
+	for rows.Next() {
+		var s ActiveSession
+		var clients string
+		var createdAtUnix, expiresAtUnix int64
+		if err := rows.Scan(&s.ID, &s.UserID, &s.PublicKey, &createdAtUnix, &expiresAtUnix, &clients); err != nil {
+			return nil, err
+		}
+		s.Clients = strings.Fields(clients)
+		s.CreatedAt = time.Unix(createdAtUnix, 0)
+		s.ExpiresAt = time.Unix(expiresAtUnix, 0)
+		sessions = append(sessions, s)
+	}
+	return sessions, rows.Err()
+}
+
 // DeleteExpiredSessions marks expired sessions as inactive. Returns number of rows updated.
 func DeleteExpiredSessions(ctx context.Context, db *sql.DB) (int64, error) {
 	res, err := db.ExecContext(ctx, `UPDATE sessions SET active = 0 WHERE expires_at <= ? AND active = 1`, time.Now().Unix())
@@ -114,3 +183,16 @@ func GetUserByPubKey(ctx context.Context, db *sql.DB, pubkey string) (int64, err
 	}
 	return id, nil
 }
+
+// GetUserByID loads a user row by id. Returns sql.ErrNoRows if not found.
+func GetUserByID(ctx context.Context, db *sql.DB, id int64) (*User, error) {
+	row := db.QueryRowContext(ctx, `SELECT id, public_key, is_admin, created_at, updated_at FROM users WHERE id = ?`, id)
+	var u User
+	var createdAtUnix, updatedAtUnix int64
+	if err := row.Scan(&u.ID, &u.PublicKey, &u.IsAdmin, &createdAtUnix, &updatedAtUnix); err != nil {
+		return nil, err
+	}
+	u.CreatedAt = time.Unix(createdAtUnix, 0)
+	u.UpdatedAt = time.Unix(updatedAtUnix, 0)
+	return &u, nil
+}
@@ -0,0 +1,77 @@
+package models
+
+import (
+	"context"
+	"database/sql"
+	"time"
+)
+
+// CreateAccessToken stores an access token (by hash) and returns its id.
+func CreateAccessToken(ctx context.Context, db *sql.DB, t *AccessToken) (int64, error) {
+	res, err := db.ExecContext(ctx, `INSERT INTO access_tokens (token_hash, client_id, user_id, session_id, authorization_code_id, refresh_family_id, scope, dpop_jkt, claims, created_at, expires_at, active) VALUES (?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, 1)`,
+		t.TokenHash, t.ClientID, t.UserID, t.SessionID, t.AuthorizationCodeID, t.RefreshFamilyID, t.Scope, t.DPoPJKT, t.Claims, time.Now().Unix(), t.ExpiresAt.Unix())
+	if err != nil {
+		return 0, err
+	}
+	return res.LastInsertId()
+}
+
+// DeactivateAccessTokensByCode sets active = false for every access token issued from an authorization code.
+func DeactivateAccessTokensByCode(ctx context.Context, db *sql.DB, codeID int64) (int64, error) {
+	res, err := db.ExecContext(ctx, `UPDATE access_tokens SET active = 0 WHERE authorization_code_id = ? AND active = 1`, codeID)
+	if err != nil {
+		return 0, err
+	}
+	return res.RowsAffected()
+}
+
+// GetAccessTokenByHash looks up an access token by token_hash and checks active/expiry.
+// If the token is expired, it will mark it inactive and return sql.ErrNoRows.
+func GetAccessTokenByHash(ctx context.Context, db *sql.DB, tokenHash string) (*AccessToken, error) {
+	row := db.QueryRowContext(ctx, `SELECT id, token_hash, client_id, user_id, session_id, authorization_code_id, refresh_family_id, scope, dpop_jkt, claims, created_at, expires_at, active FROM access_tokens WHERE token_hash = ? LIMIT 1`, tokenHash)
+	var t AccessToken
+	var userID, sessionID, codeID sql.NullInt64
+	var createdAtUnix, expiresAtUnix int64
+	if err := row.Scan(&t.ID, &t.TokenHash, &t.ClientID, &userID, &sessionID, &codeID, &t.RefreshFamilyID, &t.Scope, &t.DPoPJKT, &t.Claims, &createdAtUnix, &expiresAtUnix, &t.Active); err != nil {
+		return nil, err
+	}
+	if userID.Valid {
+		t.UserID = &userID.Int64
+	}
+	if sessionID.Valid {
+		t.SessionID = &sessionID.Int64
+	}
+	if codeID.Valid {
+		t.AuthorizationCodeID = &codeID.Int64
+	}
+	t.CreatedAt = time.Unix(createdAtUnix, 0)
+	t.ExpiresAt = time.Unix(expiresAtUnix, 0)
+	if !t.Active {
+		return nil, sql.ErrNoRows
+	}
+	if time.Now().After(t.ExpiresAt) {
+		_, _ = db.ExecContext(ctx, `UPDATE access_tokens SET active = 0 WHERE id = ?`, t.ID)
+		return nil, sql.ErrNoRows
+	}
+	return &t, nil
+}
+
+// DeactivateAccessTokenByHash sets active = false for the access token with the given token_hash.
+// It returns the number of tokens that were still active.
+func DeactivateAccessTokenByHash(ctx context.Context, db *sql.DB, tokenHash string) (int64, error) {
+	res, err := db.ExecContext(ctx, `UPDATE access_tokens SET active = 0 WHERE token_hash = ? AND active = 1`, tokenHash)
+	if err != nil {
+		return 0, err
+	}
+	return res.RowsAffected()
+}
+
+// DeactivateServiceTokens sets active = false for every access token a client was issued
+// without an end-user (client_credentials). It returns the number of tokens that were still active.
+func DeactivateServiceTokens(ctx context.Context, db *sql.DB, clientID string) (int64, error) {
+	res, err := db.ExecContext(ctx, `UPDATE access_tokens SET active = 0 WHERE client_id = ? AND user_id IS NULL AND active = 1`, clientID)
+	if err != nil {
+		return 0, err
+	}
+	return res.RowsAffected()
+}
@@ -0,0 +1,495 @@
+package oidc
+
+import (
+	"database/sql"
+	"fmt"
+	"log/slog"
+	"net"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/lescuer97/nostr-oicd/internal/config"
+	"github.com/lescuer97/nostr-oicd/internal/middleware"
+	"github.com/lescuer97/nostr-oicd/internal/models"
+)
+
+// authCodeTTL is how long an issued authorization code can be redeemed.
+const authCodeTTL = 5 * time.Minute
+
+// authorizeError is an OAuth 2.0 error returned to the client's redirect_uri (RFC 6749 section 4.1.2.1).
+type authorizeError struct {
+	Code        string
+	Description string
+}
+
+func (e *authorizeError) Error() string {
+	return e.Code + ": " + e.Description
+}
+
+// authorizeRequest holds the validated parameters of an authorization request.
+type authorizeRequest struct {
+	Client      *models.Client
+	RedirectURI string
+	// ResponseType is normalized (see normalizeResponseType); ResponseMode says how the
+	// response is returned to RedirectURI.
+	ResponseType string
+	ResponseMode string
+	Scopes       []string
+	State        string
+	Nonce        string
+	// Prompt holds the space-separated prompt values (OIDC Core section 3.1.2.1).
+	Prompt []string
+	// MaxAge is the longest time since the user signed in that the client accepts, if set.
+	MaxAge *time.Duration
+	// LoginHint is the login_hint as sent; LoginHintPubkey is the hex public key it resolves to.
+	LoginHint       string
+	LoginHintPubkey string
+	// Claims holds the claims parameter (OIDC Core section 5.5), nil if none was sent.
+	Claims *claimsRequest
+	// CodeChallenge and CodeChallengeMethod carry the PKCE parameters (RFC 7636).
+	CodeChallenge       string
+	CodeChallengeMethod string
+	// PushedRequestID is the pushed authorization request the parameters came from (RFC 9126), if any.
+	PushedRequestID int64
+	// RequestObject is the request object the parameters came from, when it is used up by issuing
+	// the response; a pushed one was used up at /par.
+	RequestObject *verifiedRequestObject
+}
+
+// validateAuthorizeParams checks the parameters that are reported back to the client
+// once client_id and redirect_uri are known to be valid.
+func validateAuthorizeParams(req *authorizeRequest, params url.Values) *authorizeError {
+	req.ResponseType = normalizeResponseType(params.Get("response_type"))
+	req.ResponseMode = requestedResponseMode(params)
+	req.State = params.Get("state")
+	req.Nonce = params.Get("nonce")
+	req.Scopes = strings.Fields(params.Get("scope"))
+	req.Prompt = strings.Fields(params.Get("prompt"))
+	req.LoginHint = strings.TrimSpace(params.Get("login_hint"))
+
+	if req.ResponseType == "" {
+		return &authorizeError{"invalid_request", "response_type is required"}
+	}
+	if !hasScope(ResponseTypesSupported, req.ResponseType) {
+		return &authorizeError{"unsupported_response_type", "unsupported response_type"}
+	}
+	if !req.Client.AllowsResponseType(req.ResponseType) {
+		return &authorizeError{"unauthorized_client", "client is not allowed to use this response_type"}
+	}
+	if hasScope(strings.Fields(req.ResponseType), "code") && !req.Client.AllowsGrantType("authorization_code") {
+		return &authorizeError{"unauthorized_client", "client is not allowed to use the authorization code flow"}
+	}
+	if mode := params.Get("response_mode"); mode != "" && !hasScope(ResponseModes, mode) {
+		return &authorizeError{"invalid_request", "unsupported response_mode"}
+	}
+	if mode, _ := strings.CutSuffix(req.ResponseMode, ".jwt"); mode == ResponseModeQuery && req.ResponseType != "code" {
+		// tokens must not end up in server logs or referrers (Multiple Response Types section 5)
+		return &authorizeError{"invalid_request", "query response modes cannot be used with this response_type"}
+	}
+	if req.State == "" {
+		return &authorizeError{"invalid_request", "state is required"}
+	}
+	if !hasScope(req.Scopes, "openid") {
+		return &authorizeError{"invalid_scope", "scope must include openid"}
+	}
+	if req.Nonce == "" && hasScope(strings.Fields(req.ResponseType), "id_token") {
+		// the nonce is the only replay protection of an ID token sent through the browser (OIDC Core section 3.2.2.1)
+		return &authorizeError{"invalid_request", "nonce is required when an ID token is returned from the authorization endpoint"}
+	}
+	if !req.Client.AllowsScopes(req.Scopes) {
+		return &authorizeError{"invalid_scope", "requested scope is not allowed for this client"}
+	}
+	for _, p := range req.Prompt {
+		if p != "none" && p != "login" && p != "consent" && p != "select_account" {
+			return &authorizeError{"invalid_request", "unsupported prompt value"}
+		}
+	}
+	if hasScope(req.Prompt, "none") && len(req.Prompt) > 1 {
+		return &authorizeError{"invalid_request", "prompt=none cannot be combined with other values"}
+	}
+	claims, err := parseClaimsRequest(params.Get("claims"))
+	if err != nil {
+		return &authorizeError{"invalid_request", "claims is not a valid claims request"}
+	}
+	req.Claims = claims
+	if req.ResponseType == "id_token" && len(req.Claims.names(claimsForUserInfo)) > 0 {
+		// userinfo claims need an access token to fetch them with (OIDC Core section 5.5)
+		return &authorizeError{"invalid_request", "userinfo claims cannot be requested without an access token"}
+	}
+	if v := params.Get("max_age"); v != "" {
+		seconds, err := strconv.Atoi(v)
+		if err != nil || seconds < 0 {
+			return &authorizeError{"invalid_request", "max_age must be a non-negative number of seconds"}
+		}
+		maxAge := time.Duration(seconds) * time.Second
+		req.MaxAge = &maxAge
+	}
+
+	req.CodeChallenge = params.Get("code_challenge")
+	req.CodeChallengeMethod = params.Get("code_challenge_method")
+	if req.CodeChallenge == "" {
+		if req.Client.RequirePKCE {
+			return &authorizeError{"invalid_request", "code_challenge is required for this client"}
+		}
+		if req.CodeChallengeMethod != "" {
+			return &authorizeError{"invalid_request", "code_challenge_method without code_challenge"}
+		}
+		return nil
+	}
+	if req.CodeChallengeMethod == "" {
+		// defaults to plain when not present (RFC 7636 section 4.3)
+		req.CodeChallengeMethod = PKCEMethodPlain
+	}
+	if req.CodeChallengeMethod != PKCEMethodS256 && req.CodeChallengeMethod != PKCEMethodPlain {
+		return &authorizeError{"invalid_request", "code_challenge_method must be S256 or plain"}
+	}
+	if !pkceValue.MatchString(req.CodeChallenge) {
+		return &authorizeError{"invalid_request", "code_challenge is malformed"}
+	}
+	return nil
+}
+
+// ValidateRedirectURI checks a redirect URI registered for a client: an absolute https URL
+// without fragment, or http on a loopback host for native apps (RFC 8252 section 7.3). Any
+// other scheme, such as javascript:, would run in the provider's origin through form_post.
+func ValidateRedirectURI(uri string) error {
+	u, err := url.Parse(uri)
+	if err != nil || u.Host == "" || u.Fragment != "" || (u.Scheme != "https" && (u.Scheme != "http" || !isLoopbackHost(u.Hostname()))) {
+		return fmt.Errorf("invalid redirect URI %q: must be an https URL, or http on a loopback host, without fragment", uri)
+	}
+	return nil
+}
+
+// isLoopbackHost reports whether host is localhost or a loopback IP address.
+func isLoopbackHost(host string) bool {
+	if host == "localhost" {
+		return true
+	}
+	ip := net.ParseIP(host)
+	return ip != nil && ip.IsLoopback()
+}
+
+// redirectWithParams redirects the browser to redirectURI with params added to its query.
+func redirectWithParams(w http.ResponseWriter, r *http.Request, redirectURI string, params url.Values) {
+	target, err := urlWithParams(redirectURI, params)
+	if err != nil {
+		http.Error(w, "invalid redirect_uri", http.StatusBadRequest)
+		return
+	}
+	http.Redirect(w, r, target, http.StatusFound)
+}
+
+// urlWithParams adds params to the query of uri, keeping the parameters it already has.
+func urlWithParams(uri string, params url.Values) (string, error) {
+	u, err := url.Parse(uri)
+	if err != nil {
+		return "", err
+	}
+	q := u.Query()
+	for k, vs := range params {
+		for _, v := range vs {
+			q.Add(k, v)
+		}
+	}
+	u.RawQuery = q.Encode()
+	return u.String(), nil
+}
+
+// redirectError sends an OAuth error back to the client in the request's response mode,
+// echoing state when present.
+func redirectError(w http.ResponseWriter, r *http.Request, cfg *config.Config, keys *KeySet, req *authorizeRequest, e *authorizeError) {
+	params := url.Values{}
+	params.Set("error", e.Code)
+	params.Set("error_description", e.Description)
+	if req.State != "" {
+		params.Set("state", req.State)
+	}
+	sendAuthorizeResponse(w, r, cfg, keys, req, params)
+}
+
+// resolveAuthorizeRequest validates an authorization request. A request_uri is replaced by the
+// request the client pushed to /par or, for registered URLs, by the request object found there;
+// a signed request object replaces all other parameters. Errors are written to w, and nil is
+// returned, so callers just stop.
+func resolveAuthorizeRequest(w http.ResponseWriter, r *http.Request, cfg *config.Config, db *sql.DB, keys *KeySet, params url.Values) *authorizeRequest {
+	// Errors about client_id and redirect_uri must not redirect (RFC 6749 section 4.1.2.1).
+	clientID := params.Get("client_id")
+	if clientID == "" {
+		http.Error(w, "client_id is required", http.StatusBadRequest)
+		return nil
+	}
+	client, err := models.GetClientByClientID(r.Context(), db, clientID)
+	if err != nil {
+		if err != sql.ErrNoRows {
+			slog.Error("oidc_authorize_client_lookup_failed", "client_id", clientID, "error", err.Error())
+		}
+		http.Error(w, "unknown client_id", http.StatusBadRequest)
+		return nil
+	}
+	var pushedID int64
+	requestObject := params.Get("request")
+	if requestURI := params.Get("request_uri"); strings.HasPrefix(requestURI, requestURIPrefix) {
+		pushed, err := models.GetPushedRequest(r.Context(), db, hashToken(cfg, requestURI))
+		if err != nil || pushed.ClientID != client.ClientID {
+			if err != nil && err != sql.ErrNoRows {
+				slog.Error("oidc_authorize_pushed_request_lookup_failed", "client_id", clientID, "error", err.Error())
+			}
+			http.Error(w, "request_uri is invalid, expired or already used", http.StatusBadRequest)
+			return nil
+		}
+		// Only the pushed parameters count; anything else on the URL is ignored (RFC 9126 section 4).
+		if params, err = url.ParseQuery(pushed.Params); err != nil {
+			slog.Error("oidc_authorize_pushed_request_invalid", "client_id", clientID, "error", err.Error())
+			http.Error(w, "request_uri is invalid, expired or already used", http.StatusBadRequest)
+			return nil
+		}
+		if pushed.ResolvedAt.IsZero() {
+			// the login and consent steps resolve the request_uri again, long after the 90 seconds
+			if err := models.ResolvePushedRequest(r.Context(), db, pushed.ID, time.Now().Add(pushedRequestFlowTTL)); err != nil {
+				slog.Error("oidc_authorize_pushed_request_resolve_failed", "client_id", clientID, "error", err.Error())
+				http.Error(w, "failed to resolve request_uri", http.StatusInternalServerError)
+				return nil
+			}
+		}
+		pushedID = pushed.ID
+		requestObject = params.Get("request")
+	} else if requestURI != "" {
+		if requestObject != "" {
+			http.Error(w, "request and request_uri cannot both be used", http.StatusBadRequest)
+			return nil
+		}
+		if requestObject, err = fetchRequestObject(r.Context(), client, requestURI); err != nil {
+			slog.Warn("oidc_authorize_invalid_request_uri", "client_id", clientID, "error", err.Error())
+			http.Error(w, "invalid request_uri", http.StatusBadRequest)
+			return nil
+		}
+	}
+	// Only the signed parameters count (RFC 9101 section 6.3). They cannot be trusted before
+	// the signature checks out, so errors are not redirected, and their detail is only logged.
+	var obj *verifiedRequestObject
+	if requestObject != "" {
+		if obj, err = verifyRequestObject(r.Context(), cfg, client, requestObject); err != nil {
+			slog.Warn("oidc_authorize_invalid_request_object", "client_id", clientID, "error", err.Error())
+			http.Error(w, "invalid request object", http.StatusBadRequest)
+			return nil
+		}
+		params = obj.Params
+	}
+	redirectURI := params.Get("redirect_uri")
+	if redirectURI == "" || !client.HasRedirectURI(redirectURI) {
+		http.Error(w, "redirect_uri is not registered for this client", http.StatusBadRequest)
+		return nil
+	}
+
+	req := &authorizeRequest{
+		Client:          client,
+		RedirectURI:     redirectURI,
+		ResponseMode:    requestedResponseMode(params),
+		State:           params.Get("state"),
+		PushedRequestID: pushedID,
+	}
+	if pushedID == 0 {
+		req.RequestObject = obj
+	}
+	if pushedID == 0 && client.RequirePAR {
+		redirectError(w, r, cfg, keys, req, &authorizeError{"invalid_request", "this client must use pushed authorization requests"})
+		return nil
+	}
+	if requestObject == "" && client.RequireSignedRequestObject {
+		redirectError(w, r, cfg, keys, req, &authorizeError{"invalid_request", "this client must send a signed request object"})
+		return nil
+	}
+	if aerr := validateAuthorizeParams(req, params); aerr != nil {
+		redirectError(w, r, cfg, keys, req, aerr)
+		return nil
+	}
+	return req
+}
+
+// issueAuthorizationResponse issues what req.ResponseType asks for to the signed-in user: a code,
+// and for implicit and hybrid flows an access token and an ID token, and sends them back to the client.
+func issueAuthorizationResponse(w http.ResponseWriter, r *http.Request, cfg *config.Config, db *sql.DB, keys *KeySet, req *authorizeRequest, sess *models.Session, user *models.User) {
+	ctx := r.Context()
+	if req.PushedRequestID != 0 {
+		// a request_uri is good for one authorization only (RFC 9126 section 4)
+		if err := models.ConsumePushedRequest(ctx, db, req.PushedRequestID); err != nil {
+			if err != sql.ErrNoRows {
+				slog.Error("oidc_authorize_pushed_request_consume_failed", "client_id", req.Client.ClientID, "error", err.Error())
+			}
+			redirectError(w, r, cfg, keys, req, &authorizeError{"invalid_request", "request_uri was already used"})
+			return
+		}
+	}
+	if req.RequestObject != nil && !req.RequestObject.use(req.Client) {
+		redirectError(w, r, cfg, keys, req, &authorizeError{"invalid_request_object", "request object was already used"})
+		return
+	}
+	responseTypes := strings.Fields(req.ResponseType)
+	resp := url.Values{"state": {req.State}}
+
+	if hasScope(responseTypes, "code") {
+		code, err := generateRandomToken(32)
+		if err != nil {
+			redirectError(w, r, cfg, keys, req, &authorizeError{"server_error", "failed to generate code"})
+			return
+		}
+		if _, err := models.CreateAuthorizationCode(ctx, db, &models.AuthorizationCode{
+			CodeHash:            hashToken(cfg, code),
+			ClientID:            req.Client.ClientID,
+			UserID:              user.ID,
+			SessionID:           sess.ID,
+			RedirectURI:         req.RedirectURI,
+			Scope:               strings.Join(req.Scopes, " "),
+			Nonce:               req.Nonce,
+			CodeChallenge:       req.CodeChallenge,
+			CodeChallengeMethod: req.CodeChallengeMethod,
+			AuthTime:            sess.CreatedAt,
+			Claims:              req.Claims.String(),
+			ExpiresAt:           time.Now().Add(authCodeTTL),
+		}); err != nil {
+			slog.Error("oidc_authorize_store_code_failed", "client_id", req.Client.ClientID, "user_id", user.ID, "error", err.Error())
+			redirectError(w, r, cfg, keys, req, &authorizeError{"server_error", "failed to store code"})
+			return
+		}
+		resp.Set("code", code)
+	}
+
+	if hasScope(responseTypes, "token") {
+		// no refresh token from the authorization endpoint (RFC 6749 section 4.2.2)
+		at, terr := issueAccessToken(ctx, cfg, db, req.Client, &models.AccessToken{
+			UserID:    &user.ID,
+			SessionID: &sess.ID,
+			Scope:     strings.Join(req.Scopes, " "),
+			Claims:    req.Claims.String(),
+		})
+		if terr != nil {
+			redirectError(w, r, cfg, keys, req, &authorizeError{"server_error", terr.Description})
+			return
+		}
+		resp.Set("access_token", at.AccessToken)
+		resp.Set("token_type", at.TokenType)
+		resp.Set("expires_in", strconv.FormatInt(at.ExpiresIn, 10))
+		resp.Set("scope", at.Scope)
+	}
+
+	if hasScope(responseTypes, "id_token") {
+		at := resp.Get("access_token")
+		extra := idTokenUserClaims(ctx, cfg, db, req.Client, user, req.Scopes, req.Claims, at != "")
+		alg := keys.alg()
+		if code := resp.Get("code"); code != "" {
+			extra["c_hash"] = halfHash(alg, code)
+		}
+		if at != "" {
+			extra["at_hash"] = halfHash(alg, at)
+		}
+		idToken, terr := signIDToken(cfg, keys, req.Client, user, req.Nonce, sessionSID(cfg, sess.ID), sess.CreatedAt, time.Now(), extra)
+		if terr != nil {
+			redirectError(w, r, cfg, keys, req, &authorizeError{"server_error", terr.Description})
+			return
+		}
+		resp.Set("id_token", idToken)
+	}
+
+	if resp.Has("access_token") || resp.Has("id_token") {
+		if err := models.TouchConsent(ctx, db, user.ID, req.Client.ClientID); err != nil {
+			slog.Warn("oidc_authorize_touch_consent_failed", "client_id", req.Client.ClientID, "user_id", user.ID, "error", err.Error())
+		}
+		// the client now holds tokens from this session and is told when it ends
+		if err := models.AddSessionClient(ctx, db, sess.ID, req.Client.ClientID); err != nil {
+			slog.Warn("oidc_authorize_session_client_failed", "client_id", req.Client.ClientID, "session_id", sess.ID, "error", err.Error())
+		}
+	}
+
+	slog.Info("oidc_authorize_response_issued", "client_id", req.Client.ClientID, "user_id", user.ID, "response_type", req.ResponseType, "response_mode", req.ResponseMode, "remote", r.RemoteAddr)
+	sendAuthorizeResponse(w, r, cfg, keys, req, resp)
+}
+
+// loginRedirect sends the browser through the Nostr challenge login and back to /authorize
+// with the same parameters, plus a ticket showing when the sign-in started so a session
+// created afterwards counts as a fresh authentication. The login page is told which key
+// login_hint expects.
+func loginRedirect(w http.ResponseWriter, r *http.Request, cfg *config.Config, req *authorizeRequest, params url.Values) {
+	next := url.Values{}
+	for k, vs := range params {
+		if k != reauthParam {
+			next[k] = vs
+		}
+	}
+	next.Set(reauthParam, reauthTicket(cfg, req.Client.ClientID, time.Now()))
+	login := url.Values{"next": {AuthorizationPath + "?" + next.Encode()}}
+	if req.LoginHintPubkey != "" {
+		login.Set("login_hint", npubOf(req.LoginHintPubkey))
+	}
+	http.Redirect(w, r, "/login?"+login.Encode(), http.StatusFound)
+}
+
+// AuthorizeHandler implements the authorization code flow endpoint. Users without a session,
+// or whose session does not satisfy prompt=login, max_age, login_hint or the sub of a claims
+// request, are sent through the NIP-07 challenge login and returned here afterwards; users who
+// have not yet granted the requested scopes to the client are shown the consent page.
+func AuthorizeHandler(cfg *config.Config, db *sql.DB, keys *KeySet) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		if err := r.ParseForm(); err != nil {
+			http.Error(w, "invalid request", http.StatusBadRequest)
+			return
+		}
+		params := r.Form
+		req := resolveAuthorizeRequest(w, r, cfg, db, keys, params)
+		if req == nil {
+			return
+		}
+		if req.LoginHint != "" {
+			// an unusable hint is only a hint; the user can still sign in with any key
+			if pubkey, err := resolveLoginHint(r.Context(), req.LoginHint); err != nil {
+				slog.Warn("oidc_authorize_login_hint_unresolved", "client_id", req.Client.ClientID, "error", err.Error())
+			} else {
+				req.LoginHintPubkey = pubkey
+			}
+		}
+
+		sess, user, err := middleware.SessionFromRequest(r, cfg, db)
+		if err != nil {
+			if hasScope(req.Prompt, "none") {
+				redirectError(w, r, cfg, keys, req, &authorizeError{"login_required", "the user is not signed in"})
+				return
+			}
+			loginRedirect(w, r, cfg, req, params)
+			return
+		}
+		if reason, fresh := checkReauth(r, cfg, req, sess, user); reason != "" {
+			// a user who just signed in again and still does not qualify is not sent round again
+			if hasScope(req.Prompt, "none") || fresh {
+				redirectError(w, r, cfg, keys, req, &authorizeError{"login_required", reason})
+				return
+			}
+			slog.Info("oidc_authorize_reauth_required", "client_id", req.Client.ClientID, "user_id", user.ID, "reason", reason)
+			loginRedirect(w, r, cfg, req, params)
+			return
+		}
+
+		needed, err := needsConsent(r, db, req, user)
+		if err != nil {
+			slog.Error("oidc_authorize_consent_lookup_failed", "client_id", req.Client.ClientID, "user_id", user.ID, "error", err.Error())
+			redirectError(w, r, cfg, keys, req, &authorizeError{"server_error", "failed to load consent"})
+			return
+		}
+		if needed {
+			if hasScope(req.Prompt, "none") {
+				redirectError(w, r, cfg, keys, req, &authorizeError{"consent_required", "the user has not granted the requested scopes"})
+				return
+			}
+			renderConsent(w, r, cfg, req, sess, user, params)
+			return
+		}
+		if req.Client.FirstParty {
+			// no page is shown, but the grant is recorded so the app is listed under Connected apps and can be revoked
+			if err := models.SaveConsent(r.Context(), db, user.ID, req.Client.ClientID, req.Scopes); err != nil {
+				slog.Warn("oidc_authorize_consent_store_failed", "client_id", req.Client.ClientID, "user_id", user.ID, "error", err.Error())
+			}
+		}
+		issueAuthorizationResponse(w, r, cfg, db, keys, req, sess, user)
+	}
+}
@@ -0,0 +1,175 @@
+package oidc
+
+import (
+	"context"
+	"database/sql"
+	"fmt"
+	"io"
+	"log/slog"
+	"net/http"
+	"net/url"
+	"strings"
+	"time"
+
+	"github.com/lescuer97/nostr-oicd/internal/config"
+	"github.com/lescuer97/nostr-oicd/internal/models"
+	"github.com/lescuer97/nostr-oicd/internal/outbound"
+)
+
+const (
+	// backchannelLogoutEvent is the events member that marks a logout token (Back-Channel Logout section 2.4).
+	backchannelLogoutEvent = "http://schemas.openid.net/event/backchannel-logout"
+	// logoutTokenTTL bounds how long a relying party should accept a logout token.
+	logoutTokenTTL = 2 * time.Minute
+	// logoutPollInterval is how often the notifier looks for due deliveries.
+	logoutPollInterval = 5 * time.Second
+	// logoutBatchSize caps the deliveries attempted per poll.
+	logoutBatchSize = 20
+	// logoutMaxAttempts is the number of attempts before a delivery is given up on.
+	logoutMaxAttempts = 8
+	// logoutRetryBase and logoutRetryMax bound the exponential backoff between attempts.
+	logoutRetryBase = 30 * time.Second
+	logoutRetryMax  = time.Hour
+)
+
+// sessionSID returns the sid claim of a browser session. It is stable for the session
+// but does not reveal the session row id.
+func sessionSID(cfg *config.Config, sessionID int64) string {
+	return hashToken(cfg, fmt.Sprintf("sid:%d", sessionID))[:32]
+}
+
+// ValidateBackchannelLogoutURI checks a backchannel_logout_uri, which may be empty
+// (Back-Channel Logout section 2.2). The provider POSTs to it, so like fetched documents it
+// must be https on a public host.
+func ValidateBackchannelLogoutURI(uri string) error {
+	return validateFetchURL("backchannel_logout_uri", uri)
+}
+
+// validateOptionalURL checks an optional absolute http(s) URL registered as param.
+func validateOptionalURL(param, uri string) error {
+	if uri == "" {
+		return nil
+	}
+	u, err := url.Parse(uri)
+	if err != nil || (u.Scheme != "https" && u.Scheme != "http") || u.Host == "" || u.Fragment != "" {
+		return fmt.Errorf("invalid %s %q: must be an absolute http(s) URL without fragment", param, uri)
+	}
+	return nil
+}
+
+// logoutRetryDelay returns the wait before the next attempt after attempts failed ones.
+func logoutRetryDelay(attempts int) time.Duration {
+	d := logoutRetryBase
+	for i := 1; i < attempts && d < logoutRetryMax; i++ {
+		d *= 2
+	}
+	return min(d, logoutRetryMax)
+}
+
+// LogoutNotifier delivers the back-channel logout notifications queued by models.EndSession
+// (OpenID Connect Back-Channel Logout 1.0). Failed deliveries are retried with exponential
+// backoff and every attempt is recorded on the delivery row.
+type LogoutNotifier struct {
+	cfg    *config.Config
+	db     *sql.DB
+	keys   *KeySet
+	client *http.Client
+}
+
+// NewLogoutNotifier returns a notifier that signs logout tokens with keys.
+func NewLogoutNotifier(cfg *config.Config, db *sql.DB, keys *KeySet) *LogoutNotifier {
+	return &LogoutNotifier{
+		cfg:  cfg,
+		db:   db,
+		keys: keys,
+		// a redirect would send the logout token somewhere the client did not register
+		client: outbound.NewClient(10 * time.Second),
+	}
+}
+
+// Run delivers due notifications until ctx is cancelled.
+func (n *LogoutNotifier) Run(ctx context.Context) {
+	ticker := time.NewTicker(logoutPollInterval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+			n.deliverDue(ctx)
+		}
+	}
+}
+
+// deliverDue attempts every delivery whose next attempt is due.
+func (n *LogoutNotifier) deliverDue(ctx context.Context) {
+	due, err := models.DueLogoutDeliveries(ctx, n.db, time.Now(), logoutBatchSize)
+	if err != nil {
+		slog.Error("oidc_backchannel_logout_poll_failed", "error", err.Error())
+		return
+	}
+	for _, d := range due {
+		if err := n.deliver(ctx, &d); err != nil {
+			var next *time.Time
+			if d.Attempts+1 < logoutMaxAttempts {
+				t := time.Now().Add(logoutRetryDelay(d.Attempts + 1))
+				next = &t
+			}
+			if err := models.MarkLogoutAttemptFailed(ctx, n.db, d.ID, err.Error(), next); err != nil {
+				slog.Error("oidc_backchannel_logout_record_failed", "delivery_id", d.ID, "error", err.Error())
+			}
+			slog.Warn("oidc_backchannel_logout_failed", "delivery_id", d.ID, "client_id", d.ClientID, "attempt", d.Attempts+1, "retry", next != nil, "error", err.Error())
+			continue
+		}
+		if err := models.MarkLogoutDelivered(ctx, n.db, d.ID); err != nil {
+			slog.Error("oidc_backchannel_logout_record_failed", "delivery_id", d.ID, "error", err.Error())
+		}
+		slog.Info("oidc_backchannel_logout_delivered", "delivery_id", d.ID, "client_id", d.ClientID, "session_id", d.SessionID, "attempt", d.Attempts+1)
+	}
+}
+
+// deliver POSTs a logout token for d to the client (Back-Channel Logout section 2.5).
+func (n *LogoutNotifier) deliver(ctx context.Context, d *models.LogoutDelivery) error {
+	user, err := models.GetUserByID(ctx, n.db, d.UserID)
+	if err != nil {
+		return fmt.Errorf("load user: %w", err)
+	}
+	client, err := models.GetClientByClientID(ctx, n.db, d.ClientID)
+	if err != nil {
+		return fmt.Errorf("load client: %w", err)
+	}
+	// clients registered before the URI rules were tightened may still have another one
+	if err := ValidateBackchannelLogoutURI(d.URI); err != nil {
+		return err
+	}
+	now := time.Now()
+	token, err := n.keys.signTyped("logout+jwt", map[string]any{
+		"iss":    n.cfg.Issuer,
+		"sub":    subjectFor(n.cfg, client, user),
+		"aud":    d.ClientID,
+		"iat":    now.Unix(),
+		"exp":    now.Add(logoutTokenTTL).Unix(),
+		"jti":    d.JTI,
+		"sid":    sessionSID(n.cfg, d.SessionID),
+		"events": map[string]any{backchannelLogoutEvent: map[string]any{}},
+	})
+	if err != nil {
+		return fmt.Errorf("sign logout token: %w", err)
+	}
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, d.URI, strings.NewReader(url.Values{"logout_token": {token}}.Encode()))
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+	resp, err := n.client.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	_, _ = io.Copy(io.Discard, io.LimitReader(resp.Body, 4<<10))
+	if resp.StatusCode < 200 || resp.StatusCode > 299 {
+		return fmt.Errorf("unexpected status %d", resp.StatusCode)
+	}
+	return nil
+}
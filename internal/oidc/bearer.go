@@ -0,0 +1,86 @@
+package oidc
+
+import (
+	"context"
+	"database/sql"
+	"fmt"
+	"net/http"
+	"strings"
+
+	"github.com/lescuer97/nostr-oicd/internal/config"
+	"github.com/lescuer97/nostr-oicd/internal/models"
+)
+
+type contextKey string
+
+// ContextAccessTokenKey holds the *models.AccessToken that authorized the request.
+const ContextAccessTokenKey = contextKey("access_token")
+
+// writeBearerError answers with a RFC 6750 section 3 challenge.
+func writeBearerError(w http.ResponseWriter, status int, code, description string) {
+	challenge := `Bearer realm="oidc"`
+	if code != "" {
+		challenge += fmt.Sprintf(`, error=%q, error_description=%q`, code, description)
+	}
+	w.Header().Set("WWW-Authenticate", challenge)
+	http.Error(w, description, status)
+}
+
+// bearerToken extracts the token from an "Authorization: Bearer <token>" header.
+func bearerToken(r *http.Request) (string, bool) {
+	scheme, token, ok := strings.Cut(r.Header.Get("Authorization"), " ")
+	if !ok || !strings.EqualFold(scheme, "Bearer") || token == "" {
+		return "", false
+	}
+	return token, true
+}
+
+// dpopToken extracts the token from an "Authorization: DPoP <token>" header (RFC 9449 section 7.1).
+func dpopToken(r *http.Request) (string, bool) {
+	scheme, token, ok := strings.Cut(r.Header.Get("Authorization"), " ")
+	if !ok || !strings.EqualFold(scheme, "DPoP") || token == "" {
+		return "", false
+	}
+	return token, true
+}
+
+// BearerAuth validates an opaque access token sent as "Authorization: Bearer <token>", or as
+// "Authorization: DPoP <token>" with a matching DPoP proof for DPoP-bound tokens, and stores it
+// in the request context under ContextAccessTokenKey.
+func BearerAuth(cfg *config.Config, db *sql.DB) func(next http.Handler) http.Handler {
+	return func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			token, dpop := dpopToken(r)
+			if !dpop {
+				var ok bool
+				if token, ok = bearerToken(r); !ok {
+					writeBearerError(w, http.StatusUnauthorized, "", "missing bearer token")
+					return
+				}
+			}
+			at, err := models.GetAccessTokenByHash(r.Context(), db, hashToken(cfg, token))
+			if err != nil {
+				writeBearerError(w, http.StatusUnauthorized, "invalid_token", "access token is invalid or expired")
+				return
+			}
+			if at.DPoPJKT != "" || dpop {
+				// a bound token sent as a plain bearer token is as good as stolen
+				if at.DPoPJKT == "" || !dpop {
+					writeDPoPError(w, "invalid_token", "access token must be sent with the DPoP scheme if and only if it is DPoP-bound")
+					return
+				}
+				jkt, err := verifyDPoPProof(r, cfg, token)
+				if err != nil {
+					writeDPoPError(w, "invalid_dpop_proof", err.Error())
+					return
+				}
+				if jkt != at.DPoPJKT {
+					writeDPoPError(w, "invalid_token", "DPoP proof key does not match the access token")
+					return
+				}
+			}
+			ctx := context.WithValue(r.Context(), ContextAccessTokenKey, at)
+			next.ServeHTTP(w, r.WithContext(ctx))
+		})
+	}
+}
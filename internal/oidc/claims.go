@@ -0,0 +1,310 @@
+package oidc
+
+import (
+	"context"
+	"database/sql"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log/slog"
+	"regexp"
+	"strings"
+	"time"
+
+	"github.com/lescuer97/nostr-oicd/internal/config"
+	"github.com/lescuer97/nostr-oicd/internal/models"
+	"github.com/lescuer97/nostr-oicd/internal/profile"
+)
+
+// Claim mapper sources: what an admin-configured claim is computed from.
+const (
+	// ClaimSourceRoles is the list of local roles assigned to the user, left out when there are none.
+	ClaimSourceRoles = "roles"
+	// ClaimSourceNIP05Verified is the NIP-05 identifier of the user's profile, left out until its
+	// domain confirmed it names the user's key.
+	ClaimSourceNIP05Verified = "nip05_verified"
+	// ClaimSourceNpub is the bech32 encoding of the user's public key.
+	ClaimSourceNpub = "npub"
+	// ClaimSourceIsAdmin is whether the user is an admin of the provider.
+	ClaimSourceIsAdmin = "is_admin"
+)
+
+// ClaimSources lists the sources claim mappers can use.
+var ClaimSources = []string{ClaimSourceRoles, ClaimSourceNIP05Verified, ClaimSourceNpub, ClaimSourceIsAdmin}
+
+// Claim destinations, as named in the claims request parameter (OIDC Core section 5.5).
+const (
+	claimsForIDToken  = "id_token"
+	claimsForUserInfo = "userinfo"
+)
+
+// nip05MaxAge is how long a NIP-05 verification is trusted before it is redone.
+const nip05MaxAge = 24 * time.Hour
+
+// reservedClaims are set by the provider itself and cannot be mapped.
+var reservedClaims = []string{
+	"iss", "sub", "aud", "exp", "iat", "nbf", "jti", "nonce", "auth_time", "sid", "azp",
+	"acr", "amr", "c_hash", "at_hash", "s_hash", "cnf", "scope", "client_id", "events",
+}
+
+// claimName is the shape of mapped claim names.
+var claimName = regexp.MustCompile(`^[a-z][a-z0-9_]{0,63}$`)
+
+// ValidateClaimMapper checks the claim name, source and scope of m. Mapping the name of a
+// built-in claim, such as nip05, replaces it.
+func ValidateClaimMapper(m *models.ClaimMapper) error {
+	if !claimName.MatchString(m.Claim) {
+		return errors.New("claim names are lowercase letters, digits and underscores, starting with a letter")
+	}
+	if hasScope(reservedClaims, m.Claim) {
+		return fmt.Errorf("claim %q is set by the provider and cannot be mapped", m.Claim)
+	}
+	if !hasScope(ClaimSources, m.Source) {
+		return fmt.Errorf("unsupported claim source %q", m.Source)
+	}
+	if !hasScope(ScopesSupported, m.Scope) || m.Scope == "offline_access" {
+		return fmt.Errorf("claims cannot be released under scope %q", m.Scope)
+	}
+	return nil
+}
+
+// claimRequest asks for an individual claim (OIDC Core section 5.5.1). A null in the request
+// asks for the claim in the default manner and decodes to nil.
+type claimRequest struct {
+	Essential bool  `json:"essential,omitempty"`
+	Value     any   `json:"value,omitempty"`
+	Values    []any `json:"values,omitempty"`
+}
+
+// claimsRequest is the claims request parameter: the claims the client asks for in the ID token
+// and at userinfo. Claims are only released if a granted scope covers them.
+type claimsRequest struct {
+	UserInfo map[string]*claimRequest `json:"userinfo,omitempty"`
+	IDToken  map[string]*claimRequest `json:"id_token,omitempty"`
+}
+
+// parseClaimsRequest decodes a claims request parameter; an empty one is nil.
+func parseClaimsRequest(raw string) (*claimsRequest, error) {
+	if raw == "" {
+		return nil, nil
+	}
+	var c claimsRequest
+	if err := json.Unmarshal([]byte(raw), &c); err != nil {
+		return nil, err
+	}
+	return &c, nil
+}
+
+// storedClaimsRequest decodes the claims request stored with a code or token. It was validated
+// at the authorization endpoint, so anything else is treated as no request.
+func storedClaimsRequest(raw string) *claimsRequest {
+	c, err := parseClaimsRequest(raw)
+	if err != nil {
+		slog.Warn("oidc_stored_claims_request_invalid", "error", err.Error())
+		return nil
+	}
+	return c
+}
+
+// String returns c as compact JSON for storage, or "" when nothing is requested.
+func (c *claimsRequest) String() string {
+	if c == nil || (len(c.UserInfo) == 0 && len(c.IDToken) == 0) {
+		return ""
+	}
+	b, err := json.Marshal(c)
+	if err != nil {
+		return ""
+	}
+	return string(b)
+}
+
+// names returns the claims requested for dest.
+func (c *claimsRequest) names(dest string) map[string]*claimRequest {
+	if c == nil {
+		return nil
+	}
+	if dest == claimsForIDToken {
+		return c.IDToken
+	}
+	return c.UserInfo
+}
+
+// wants reports whether claim name is requested for dest.
+func (c *claimsRequest) wants(dest, name string) bool {
+	_, ok := c.names(dest)[name]
+	return ok
+}
+
+// subject returns the sub the client requires the ID token to be about, if any. The user must
+// be signed in as that subject (OIDC Core section 5.5.1).
+func (c *claimsRequest) subject() string {
+	if sub := c.names(claimsForIDToken)["sub"]; sub != nil {
+		if s, ok := sub.Value.(string); ok {
+			return s
+		}
+	}
+	return ""
+}
+
+// userClaims returns the claims about user that scopes grant client for dest, including sub.
+// Built-in claims go to userinfo, and into the ID token only when requested; mapped claims go
+// where their mapper says, or where requested. A mapper replaces the built-in claim of its name.
+func userClaims(ctx context.Context, cfg *config.Config, db *sql.DB, client *models.Client, user *models.User, scopes []string, requested *claimsRequest, dest string) map[string]any {
+	mappers, err := models.ListClaimMappers(ctx, db)
+	if err != nil {
+		slog.Error("oidc_claim_mappers_lookup_failed", "client_id", client.ClientID, "error", err.Error())
+	}
+	mapped := map[string]bool{}
+	for _, m := range mappers {
+		mapped[m.Claim] = true
+	}
+
+	var p *models.Profile
+	loaded := false
+	profileOf := func() *models.Profile {
+		if !loaded {
+			p, loaded = loadProfile(ctx, cfg, db, user), true
+		}
+		return p
+	}
+
+	claims := map[string]any{}
+	if dest == claimsForUserInfo || len(requested.names(dest)) > 0 {
+		builtin := map[string]any{}
+		if p := profileOf(); p != nil {
+			if m, err := profile.Parse(p); err == nil {
+				nip05 := ""
+				if hasScope(scopes, "profile") || hasScope(scopes, "email") {
+					nip05 = verifiedNIP05(ctx, db, user, p)
+				}
+				builtin = profileClaims(m, nip05, p.EventCreatedAt, scopes)
+			}
+		}
+		if hasScope(scopes, scopeNpub) {
+			builtin["npub"] = npubOf(user.PublicKey)
+		}
+		for name, v := range builtin {
+			if !mapped[name] && (dest == claimsForUserInfo || requested.wants(dest, name)) {
+				claims[name] = v
+			}
+		}
+	}
+	for _, m := range mappers {
+		if !hasScope(scopes, m.Scope) {
+			continue
+		}
+		if (dest == claimsForIDToken && m.IDToken) || (dest == claimsForUserInfo && m.UserInfo) || requested.wants(dest, m.Claim) {
+			if v := mappedClaim(ctx, db, m.Source, user, profileOf); v != nil {
+				claims[m.Claim] = v
+			}
+		}
+	}
+	claims["sub"] = subjectFor(cfg, client, user)
+	return claims
+}
+
+// idTokenUserClaims returns the user claims for an ID token. Without an access token the client
+// cannot call userinfo, so everything it would return goes in the ID token (OIDC Core section 5.4).
+func idTokenUserClaims(ctx context.Context, cfg *config.Config, db *sql.DB, client *models.Client, user *models.User, scopes []string, requested *claimsRequest, withAccessToken bool) map[string]any {
+	claims := userClaims(ctx, cfg, db, client, user, scopes, requested, claimsForIDToken)
+	if !withAccessToken {
+		for k, v := range userClaims(ctx, cfg, db, client, user, scopes, requested, claimsForUserInfo) {
+			claims[k] = v
+		}
+	}
+	return claims
+}
+
+// mappedClaim computes the value of a claim mapper source for user, or nil if it has none.
+func mappedClaim(ctx context.Context, db *sql.DB, source string, user *models.User, profileOf func() *models.Profile) any {
+	switch source {
+	case ClaimSourceRoles:
+		roles, err := models.GetUserRoles(ctx, db, user.ID)
+		if err != nil {
+			slog.Error("oidc_user_roles_lookup_failed", "user_id", user.ID, "error", err.Error())
+			return nil
+		}
+		if len(roles) == 0 {
+			return nil
+		}
+		return roles
+	case ClaimSourceNIP05Verified:
+		if nip05 := verifiedNIP05(ctx, db, user, profileOf()); nip05 != "" {
+			return nip05
+		}
+	case ClaimSourceNpub:
+		if npub := npubOf(user.PublicKey); npub != "" {
+			return npub
+		}
+	case ClaimSourceIsAdmin:
+		return user.IsAdmin
+	}
+	return nil
+}
+
+// verifiedNIP05 returns the NIP-05 identifier of p once its domain confirmed it names the user,
+// checking it first if that was never done for the current profile and redoing a stale check
+// in the background.
+func verifiedNIP05(ctx context.Context, db *sql.DB, user *models.User, p *models.Profile) string {
+	if p == nil {
+		return ""
+	}
+	if p.NIP05CheckedAt.IsZero() {
+		verified, err := profile.VerifyNIP05(ctx, db, p, user.PublicKey)
+		if err != nil {
+			slog.Warn("oidc_nip05_verify_failed", "user_id", user.ID, "error", err.Error())
+		}
+		return verified
+	}
+	if time.Since(p.NIP05CheckedAt) > nip05MaxAge {
+		go func() {
+			if _, err := profile.VerifyNIP05(context.Background(), db, p, user.PublicKey); err != nil {
+				slog.Warn("oidc_nip05_verify_failed", "user_id", user.ID, "error", err.Error())
+			}
+		}()
+	}
+	return p.NIP05Verified
+}
+
+// mappedClaimNames returns the names of the configured claims, for discovery.
+func mappedClaimNames(ctx context.Context, db *sql.DB) []string {
+	mappers, err := models.ListClaimMappers(ctx, db)
+	if err != nil {
+		slog.Error("oidc_claim_mappers_lookup_failed", "error", err.Error())
+		return nil
+	}
+	var names []string
+	for _, m := range mappers {
+		names = append(names, m.Claim)
+	}
+	return names
+}
+
+// ClaimsPreview holds what an ID token and a userinfo response would carry.
+type ClaimsPreview struct {
+	IDToken  map[string]any
+	UserInfo map[string]any
+}
+
+// PreviewClaims returns the claims client would receive about user in an ID token from the
+// token endpoint and at userinfo for an authorization with scope and the claims parameter
+// claims, without issuing anything. Errors describe a request the provider would refuse.
+func PreviewClaims(ctx context.Context, cfg *config.Config, db *sql.DB, client *models.Client, user *models.User, scope, claims string) (*ClaimsPreview, error) {
+	scopes := strings.Fields(scope)
+	if !hasScope(scopes, "openid") {
+		return nil, errors.New("scope must include openid")
+	}
+	if !client.AllowsScopes(scopes) {
+		return nil, errors.New("requested scope is not allowed for this client")
+	}
+	requested, err := parseClaimsRequest(claims)
+	if err != nil {
+		return nil, fmt.Errorf("claims is not a valid claims request: %v", err)
+	}
+	now := time.Now()
+	extra := userClaims(ctx, cfg, db, client, user, scopes, requested, claimsForIDToken)
+	return &ClaimsPreview{
+		IDToken:  idTokenClaims(cfg, client, user, "", "", now, now, extra),
+		UserInfo: userClaims(ctx, cfg, db, client, user, scopes, requested, claimsForUserInfo),
+	}, nil
+}
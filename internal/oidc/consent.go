@@ -0,0 +1,96 @@
+package oidc
+
+import (
+	"crypto/hmac"
+	"database/sql"
+	"fmt"
+	"log/slog"
+	"net/http"
+	"net/url"
+
+	"github.com/lescuer97/nostr-oicd/internal/config"
+	"github.com/lescuer97/nostr-oicd/internal/middleware"
+	"github.com/lescuer97/nostr-oicd/internal/models"
+	"github.com/lescuer97/nostr-oicd/templates/pages"
+)
+
+// needsConsent reports whether the user has to approve the request: always for prompt=consent,
+// never for first-party clients, and otherwise when a requested scope was not granted before.
+func needsConsent(r *http.Request, db *sql.DB, req *authorizeRequest, user *models.User) (bool, error) {
+	if req.Client.FirstParty {
+		return false, nil
+	}
+	if hasScope(req.Prompt, "consent") {
+		return true, nil
+	}
+	consent, err := models.GetConsent(r.Context(), db, user.ID, req.Client.ClientID)
+	if err == sql.ErrNoRows {
+		return true, nil
+	}
+	if err != nil {
+		return false, err
+	}
+	return !consent.Covers(req.Scopes), nil
+}
+
+// consentToken binds a consent form to the session that rendered it and to the exact request,
+// so the decision cannot be forged cross-site or replayed for other parameters.
+func consentToken(cfg *config.Config, sess *models.Session, request string) string {
+	return hashToken(cfg, fmt.Sprintf("consent:%d:%s", sess.ID, request))
+}
+
+// renderConsent shows the consent page for req. The original parameters travel in the form
+// and are validated again when the decision is posted.
+func renderConsent(w http.ResponseWriter, r *http.Request, cfg *config.Config, req *authorizeRequest, sess *models.Session, user *models.User, params url.Values) {
+	request := params.Encode()
+	w.Header().Set("Content-Type", "text/html; charset=utf-8")
+	w.Header().Set("Cache-Control", "no-store")
+	// the page must not be framed by the client to trick the user into clicking
+	w.Header().Set("X-Frame-Options", "DENY")
+	if err := pages.ConsentPage(user.PublicKey, *req.Client, req.Scopes, request, consentToken(cfg, sess, request)).Render(r.Context(), w); err != nil {
+		http.Error(w, "failed to render", http.StatusInternalServerError)
+	}
+}
+
+// ConsentHandler receives the decision posted from the consent page. Approvals are stored so
+// later requests for the same scopes skip the page.
+func ConsentHandler(cfg *config.Config, db *sql.DB, keys *KeySet) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		if err := r.ParseForm(); err != nil {
+			http.Error(w, "invalid request", http.StatusBadRequest)
+			return
+		}
+		request := r.PostForm.Get("request")
+		params, err := url.ParseQuery(request)
+		if err != nil {
+			http.Error(w, "invalid request", http.StatusBadRequest)
+			return
+		}
+		req := resolveAuthorizeRequest(w, r, cfg, db, keys, params)
+		if req == nil {
+			return
+		}
+		sess, user, err := middleware.SessionFromRequest(r, cfg, db)
+		if err != nil {
+			loginRedirect(w, r, cfg, req, params)
+			return
+		}
+		if !hmac.Equal([]byte(r.PostForm.Get("consent_token")), []byte(consentToken(cfg, sess, request))) {
+			http.Error(w, "invalid consent request", http.StatusBadRequest)
+			return
+		}
+
+		if r.PostForm.Get("decision") != "allow" {
+			slog.Info("oidc_consent_denied", "client_id", req.Client.ClientID, "user_id", user.ID, "remote", r.RemoteAddr)
+			redirectError(w, r, cfg, keys, req, &authorizeError{"access_denied", "the user denied the request"})
+			return
+		}
+		if err := models.SaveConsent(r.Context(), db, user.ID, req.Client.ClientID, req.Scopes); err != nil {
+			slog.Error("oidc_consent_store_failed", "client_id", req.Client.ClientID, "user_id", user.ID, "error", err.Error())
+			redirectError(w, r, cfg, keys, req, &authorizeError{"server_error", "failed to store consent"})
+			return
+		}
+		slog.Info("oidc_consent_granted", "client_id", req.Client.ClientID, "user_id", user.ID, "scopes", req.Scopes, "remote", r.RemoteAddr)
+		issueAuthorizationResponse(w, r, cfg, db, keys, req, sess, user)
+	}
+}
@@ -0,0 +1,327 @@
+package oidc
+
+import (
+	"crypto/hmac"
+	"crypto/rand"
+	"database/sql"
+	"fmt"
+	"log/slog"
+	"math/big"
+	"net/http"
+	"net/url"
+	"strings"
+	"time"
+
+	"github.com/lescuer97/nostr-oicd/internal/config"
+	"github.com/lescuer97/nostr-oicd/internal/middleware"
+	"github.com/lescuer97/nostr-oicd/internal/models"
+	"github.com/lescuer97/nostr-oicd/templates/pages"
+)
+
+// GrantTypeDeviceCode is the grant_type of the device authorization grant (RFC 8628 section 3.4).
+const GrantTypeDeviceCode = "urn:ietf:params:oauth:grant-type:device_code"
+
+const (
+	// deviceCodeTTL is how long the user has to approve a device.
+	deviceCodeTTL = 10 * time.Minute
+	// devicePollInterval is the minimum time between token requests; slow_down adds deviceSlowDown.
+	devicePollInterval = 5 * time.Second
+	deviceSlowDown     = 5 * time.Second
+	// userCodeAlphabet has no vowels, so codes cannot spell words, and no look-alike characters
+	// (RFC 8628 section 6.1). 20^8 codes give enough entropy for a 10 minute window while
+	// POST /device allows each client IP 20 attempts a minute (see RegisterRoutes).
+	userCodeAlphabet = "BCDFGHJKLMNPQRSTVWXZ"
+	userCodeLength   = 8
+)
+
+// deviceAuthorizationResponse is the device authorization response (RFC 8628 section 3.2).
+type deviceAuthorizationResponse struct {
+	DeviceCode              string `json:"device_code"`
+	UserCode                string `json:"user_code"`
+	VerificationURI         string `json:"verification_uri"`
+	VerificationURIComplete string `json:"verification_uri_complete"`
+	ExpiresIn               int64  `json:"expires_in"`
+	Interval                int64  `json:"interval"`
+}
+
+// newUserCode returns a random user code formatted as XXXX-XXXX.
+func newUserCode() (string, error) {
+	max := big.NewInt(int64(len(userCodeAlphabet)))
+	var b strings.Builder
+	for i := 0; i < userCodeLength; i++ {
+		if i == userCodeLength/2 {
+			b.WriteByte('-')
+		}
+		n, err := rand.Int(rand.Reader, max)
+		if err != nil {
+			return "", err
+		}
+		b.WriteByte(userCodeAlphabet[n.Int64()])
+	}
+	return b.String(), nil
+}
+
+// normalizeUserCode makes user input comparable to an issued code: case, dashes and spaces
+// do not matter (RFC 8628 section 6.1).
+func normalizeUserCode(code string) string {
+	code = strings.ToUpper(code)
+	return strings.Map(func(r rune) rune {
+		if r == '-' || r == ' ' {
+			return -1
+		}
+		return r
+	}, code)
+}
+
+// formatUserCode renders a normalized user code the way it was shown on the device.
+func formatUserCode(normalized string) string {
+	if len(normalized) != userCodeLength {
+		return normalized
+	}
+	return normalized[:userCodeLength/2] + "-" + normalized[userCodeLength/2:]
+}
+
+// DeviceAuthorizationHandler implements the device authorization endpoint (RFC 8628 section 3.1).
+// Clients authenticate like at the token endpoint and get a device_code to poll with and a
+// user_code for the user to enter at the verification URI.
+func DeviceAuthorizationHandler(cfg *config.Config, db *sql.DB) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		if err := r.ParseForm(); err != nil {
+			writeTokenError(w, &tokenError{http.StatusBadRequest, "invalid_request", "invalid form body"})
+			return
+		}
+		client, terr := authenticateClient(r, cfg, db)
+		if terr != nil {
+			writeTokenError(w, terr)
+			return
+		}
+		if !client.AllowsGrantType(GrantTypeDeviceCode) {
+			writeTokenError(w, &tokenError{http.StatusBadRequest, "unauthorized_client", "client is not allowed to use the device authorization grant"})
+			return
+		}
+		scopes := strings.Fields(r.PostForm.Get("scope"))
+		if len(scopes) == 0 {
+			scopes = []string{"openid"}
+		}
+		if !client.AllowsScopes(scopes) {
+			writeTokenError(w, &tokenError{http.StatusBadRequest, "invalid_scope", "requested scope is not allowed for this client"})
+			return
+		}
+
+		deviceCode, err := generateRandomToken(32)
+		if err != nil {
+			writeTokenError(w, &tokenError{http.StatusInternalServerError, "server_error", "failed to generate code"})
+			return
+		}
+		userCode, err := newUserCode()
+		if err != nil {
+			writeTokenError(w, &tokenError{http.StatusInternalServerError, "server_error", "failed to generate code"})
+			return
+		}
+		if _, err := models.CreateDeviceAuthorization(r.Context(), db, &models.DeviceAuthorization{
+			DeviceCodeHash: hashToken(cfg, deviceCode),
+			UserCodeHash:   hashToken(cfg, normalizeUserCode(userCode)),
+			ClientID:       client.ClientID,
+			Scope:          strings.Join(scopes, " "),
+			Interval:       devicePollInterval,
+			ExpiresAt:      time.Now().Add(deviceCodeTTL),
+		}); err != nil {
+			slog.Error("oidc_device_authorization_store_failed", "client_id", client.ClientID, "error", err.Error())
+			writeTokenError(w, &tokenError{http.StatusInternalServerError, "server_error", "failed to store device authorization"})
+			return
+		}
+
+		slog.Info("oidc_device_authorization", "client_id", client.ClientID, "scope", strings.Join(scopes, " "), "remote", r.RemoteAddr)
+		writeJSON(w, http.StatusOK, &deviceAuthorizationResponse{
+			DeviceCode:              deviceCode,
+			UserCode:                userCode,
+			VerificationURI:         cfg.Issuer + DevicePath,
+			VerificationURIComplete: cfg.Issuer + DevicePath + "?user_code=" + url.QueryEscape(userCode),
+			ExpiresIn:               int64(deviceCodeTTL.Seconds()),
+			Interval:                int64(devicePollInterval.Seconds()),
+		})
+	}
+}
+
+// deviceToken binds the device approval form to the session that rendered it and to the user code.
+func deviceToken(cfg *config.Config, sess *models.Session, userCode string) string {
+	return hashToken(cfg, fmt.Sprintf("device:%d:%s", sess.ID, userCode))
+}
+
+// renderDevicePage shows the user code entry form, with message explaining why the last code was rejected.
+func renderDevicePage(w http.ResponseWriter, r *http.Request, user *models.User, userCode, message string) {
+	w.Header().Set("Content-Type", "text/html; charset=utf-8")
+	w.Header().Set("Cache-Control", "no-store")
+	w.Header().Set("X-Frame-Options", "DENY")
+	if err := pages.DevicePage(user.PublicKey, userCode, message).Render(r.Context(), w); err != nil {
+		http.Error(w, "failed to render", http.StatusInternalServerError)
+	}
+}
+
+// DeviceHandler is the verification page of the device authorization grant (RFC 8628 section 3.3).
+// A signed-in user enters the user code shown on the device, checks which app asks for which
+// scopes and approves or denies it. Users without a session are sent through the NIP-07 login
+// first; verification_uri_complete carries the code through the login.
+func DeviceHandler(cfg *config.Config, db *sql.DB) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		if err := r.ParseForm(); err != nil {
+			http.Error(w, "invalid request", http.StatusBadRequest)
+			return
+		}
+		userCode := strings.TrimSpace(r.Form.Get("user_code"))
+		sess, user, err := middleware.SessionFromRequest(r, cfg, db)
+		if err != nil {
+			next := DevicePath
+			if userCode != "" {
+				next += "?user_code=" + url.QueryEscape(userCode)
+			}
+			http.Redirect(w, r, "/login?next="+url.QueryEscape(next), http.StatusFound)
+			return
+		}
+		if r.Method != http.MethodPost {
+			renderDevicePage(w, r, user, userCode, "")
+			return
+		}
+
+		normalized := normalizeUserCode(userCode)
+		d, err := models.GetPendingDeviceAuthorization(r.Context(), db, hashToken(cfg, normalized))
+		if err != nil {
+			if err != sql.ErrNoRows {
+				slog.Error("oidc_device_lookup_failed", "user_id", user.ID, "error", err.Error())
+			}
+			renderDevicePage(w, r, user, userCode, "This code is invalid or has expired. Check the code on your device.")
+			return
+		}
+		client, err := models.GetClientByClientID(r.Context(), db, d.ClientID)
+		if err != nil {
+			slog.Error("oidc_device_client_lookup_failed", "client_id", d.ClientID, "error", err.Error())
+			renderDevicePage(w, r, user, userCode, "This code is invalid or has expired. Check the code on your device.")
+			return
+		}
+
+		decision := r.PostForm.Get("decision")
+		if decision == "" {
+			w.Header().Set("Content-Type", "text/html; charset=utf-8")
+			w.Header().Set("Cache-Control", "no-store")
+			w.Header().Set("X-Frame-Options", "DENY")
+			if err := pages.DeviceConfirmPage(user.PublicKey, *client, strings.Fields(d.Scope), formatUserCode(normalized), deviceToken(cfg, sess, normalized)).Render(r.Context(), w); err != nil {
+				http.Error(w, "failed to render", http.StatusInternalServerError)
+			}
+			return
+		}
+		if !hmac.Equal([]byte(r.PostForm.Get("device_token")), []byte(deviceToken(cfg, sess, normalized))) {
+			http.Error(w, "invalid device request", http.StatusBadRequest)
+			return
+		}
+
+		approved := decision == "allow"
+		if err := models.DecideDeviceAuthorization(r.Context(), db, d.ID, user.ID, approved); err != nil {
+			if err != sql.ErrNoRows {
+				slog.Error("oidc_device_decision_failed", "client_id", d.ClientID, "user_id", user.ID, "error", err.Error())
+			}
+			renderDevicePage(w, r, user, "", "This code is invalid or has expired. Check the code on your device.")
+			return
+		}
+		if approved {
+			// approving the device grants the scopes like the consent page does
+			if err := models.SaveConsent(r.Context(), db, user.ID, d.ClientID, strings.Fields(d.Scope)); err != nil {
+				slog.Warn("oidc_device_consent_store_failed", "client_id", d.ClientID, "user_id", user.ID, "error", err.Error())
+			}
+		}
+		slog.Info("oidc_device_decision", "client_id", d.ClientID, "user_id", user.ID, "approved", approved, "remote", r.RemoteAddr)
+		w.Header().Set("Content-Type", "text/html; charset=utf-8")
+		if err := pages.DeviceDonePage(user.PublicKey, client.Name, approved).Render(r.Context(), w); err != nil {
+			http.Error(w, "failed to render", http.StatusInternalServerError)
+		}
+	}
+}
+
+// exchangeDeviceCode answers a device polling for tokens (RFC 8628 section 3.4). Until the user
+// decides, polls get authorization_pending, or slow_down when they come faster than the interval.
+func exchangeDeviceCode(r *http.Request, cfg *config.Config, db *sql.DB, keys *KeySet, client *models.Client) (*tokenResponse, *tokenError) {
+	ctx := r.Context()
+	invalidGrant := &tokenError{http.StatusBadRequest, "invalid_grant", "device code is invalid or already used"}
+
+	code := r.PostForm.Get("device_code")
+	if code == "" {
+		return nil, &tokenError{http.StatusBadRequest, "invalid_request", "device_code is required"}
+	}
+	d, err := models.GetDeviceAuthorizationByDeviceCode(ctx, db, hashToken(cfg, code))
+	if err != nil {
+		if err != sql.ErrNoRows {
+			slog.Error("oidc_token_device_lookup_failed", "client_id", client.ClientID, "error", err.Error())
+			return nil, &tokenError{http.StatusInternalServerError, "server_error", "failed to redeem device code"}
+		}
+		return nil, invalidGrant
+	}
+	if d.ClientID != client.ClientID {
+		return nil, invalidGrant
+	}
+	now := time.Now()
+	switch {
+	case d.Status == models.DeviceDenied:
+		return nil, &tokenError{http.StatusBadRequest, "access_denied", "the user denied the request"}
+	case d.Status == models.DeviceUsed:
+		return nil, invalidGrant
+	case now.After(d.ExpiresAt):
+		return nil, &tokenError{http.StatusBadRequest, "expired_token", "device code has expired"}
+	case d.Status == models.DevicePending:
+		interval := d.Interval
+		slowDown := d.LastPolledAt != nil && now.Sub(*d.LastPolledAt) < interval
+		if slowDown {
+			interval += deviceSlowDown
+		}
+		if err := models.RecordDevicePoll(ctx, db, d.ID, now, interval); err != nil {
+			slog.Warn("oidc_token_device_poll_failed", "client_id", client.ClientID, "error", err.Error())
+		}
+		if slowDown {
+			return nil, &tokenError{http.StatusBadRequest, "slow_down", "polling too fast"}
+		}
+		return nil, &tokenError{http.StatusBadRequest, "authorization_pending", "the user has not approved the device yet"}
+	}
+
+	if err := models.ConsumeDeviceAuthorization(ctx, db, d.ID); err != nil {
+		return nil, invalidGrant
+	}
+	user, err := models.GetUserByID(ctx, db, *d.UserID)
+	if err != nil {
+		return nil, invalidGrant
+	}
+
+	familyID := ""
+	if hasScope(strings.Fields(d.Scope), "offline_access") && client.AllowsGrantType("refresh_token") {
+		if familyID, err = generateRandomToken(16); err != nil {
+			return nil, &tokenError{http.StatusInternalServerError, "server_error", "failed to generate token"}
+		}
+	}
+	resp, terr := issueAccessToken(ctx, cfg, db, client, &models.AccessToken{
+		UserID:          &user.ID,
+		RefreshFamilyID: familyID,
+		Scope:           d.Scope,
+	})
+	if terr != nil {
+		return nil, terr
+	}
+	if familyID != "" {
+		if resp.RefreshToken, terr = issueRefreshToken(ctx, cfg, db, client, &models.RefreshToken{
+			FamilyID: familyID,
+			UserID:   user.ID,
+			Scope:    d.Scope,
+		}); terr != nil {
+			return nil, terr
+		}
+	}
+	if hasScope(strings.Fields(d.Scope), "openid") {
+		// the device is not part of the browser session that approved it, so no sid
+		extra := idTokenUserClaims(ctx, cfg, db, client, user, strings.Fields(d.Scope), nil, true)
+		if resp.IDToken, terr = signIDToken(cfg, keys, client, user, "", "", time.Time{}, now, extra); terr != nil {
+			return nil, terr
+		}
+	}
+
+	if err := models.TouchConsent(ctx, db, user.ID, client.ClientID); err != nil {
+		slog.Warn("oidc_token_touch_consent_failed", "client_id", client.ClientID, "user_id", user.ID, "error", err.Error())
+	}
+	slog.Info("oidc_token_issued", "client_id", client.ClientID, "user_id", user.ID, "grant_type", GrantTypeDeviceCode, "refresh_token", familyID != "")
+	return resp, nil
+}
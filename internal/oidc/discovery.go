@@ -0,0 +1,147 @@
+package oidc
+
+import (
+	"database/sql"
+	"encoding/json"
+	"net/http"
+
+	"github.com/lescuer97/nostr-oicd/internal/config"
+)
+
+// Endpoint paths served by the provider. They are joined with cfg.Issuer to build absolute URLs.
+const (
+	DiscoveryPath     = "/.well-known/openid-configuration"
+	AuthorizationPath = "/authorize"
+	ConsentPath       = "/authorize/consent"
+	TokenPath         = "/token"
+	UserInfoPath      = "/userinfo"
+	JWKSPath          = "/jwks.json"
+	RegistrationPath  = "/register"
+	IntrospectionPath = "/introspect"
+	RevocationPath    = "/revoke"
+	EndSessionPath    = "/end_session"
+	// DeviceAuthorizationPath and DevicePath are the device grant endpoint and its verification page.
+	DeviceAuthorizationPath = "/device_authorization"
+	DevicePath              = "/device"
+	// PARPath is the pushed authorization request endpoint (RFC 9126).
+	PARPath = "/par"
+)
+
+// ScopesSupported lists the scopes the provider understands.
+var ScopesSupported = []string{"openid", "profile", "email", "offline_access", scopeNpub}
+
+// GrantTypesSupported lists the grant types clients can be registered for.
+var GrantTypesSupported = []string{"authorization_code", "refresh_token", "client_credentials", GrantTypeDeviceCode}
+
+// discoveryGrantTypes are the grant types advertised in discovery: implicit is not a token
+// endpoint grant, but is how id_token and token responses from /authorize are described.
+var discoveryGrantTypes = append(append([]string{}, GrantTypesSupported...), "implicit")
+
+// TokenEndpointAuthMethods lists the supported client authentication methods.
+var TokenEndpointAuthMethods = []string{"client_secret_basic", "client_secret_post", "none"}
+
+// Discovery is the OpenID Provider Metadata document (OpenID Connect Discovery 1.0, section 3).
+type Discovery struct {
+	Issuer                             string   `json:"issuer"`
+	AuthorizationEndpoint              string   `json:"authorization_endpoint"`
+	TokenEndpoint                      string   `json:"token_endpoint"`
+	UserInfoEndpoint                   string   `json:"userinfo_endpoint"`
+	JWKSURI                            string   `json:"jwks_uri"`
+	RegistrationEndpoint               string   `json:"registration_endpoint"`
+	IntrospectionEndpoint              string   `json:"introspection_endpoint"`
+	RevocationEndpoint                 string   `json:"revocation_endpoint"`
+	EndSessionEndpoint                 string   `json:"end_session_endpoint"`
+	DeviceAuthorizationEndpoint        string   `json:"device_authorization_endpoint"`
+	PushedAuthorizationRequestEndpoint string   `json:"pushed_authorization_request_endpoint"`
+	ScopesSupported                    []string `json:"scopes_supported"`
+	ResponseTypesSupported             []string `json:"response_types_supported"`
+	ResponseModesSupported             []string `json:"response_modes_supported"`
+	GrantTypesSupported                []string `json:"grant_types_supported"`
+	SubjectTypesSupported              []string `json:"subject_types_supported"`
+	IDTokenSigningAlgValuesSupported   []string `json:"id_token_signing_alg_values_supported"`
+	TokenEndpointAuthMethodsSupported  []string `json:"token_endpoint_auth_methods_supported"`
+	// introspection needs a confidential client, revocation also accepts public ones
+	IntrospectionEndpointAuthMethodsSupported []string `json:"introspection_endpoint_auth_methods_supported"`
+	RevocationEndpointAuthMethodsSupported    []string `json:"revocation_endpoint_auth_methods_supported"`
+	ClaimsSupported                           []string `json:"claims_supported"`
+	CodeChallengeMethodsSupported             []string `json:"code_challenge_methods_supported"`
+	// logout tokens always carry sid (Back-Channel Logout section 2.1)
+	BackchannelLogoutSupported        bool `json:"backchannel_logout_supported"`
+	BackchannelLogoutSessionSupported bool `json:"backchannel_logout_session_supported"`
+	// front-channel logout URLs always carry iss and sid (Front-Channel Logout section 3)
+	FrontchannelLogoutSupported        bool `json:"frontchannel_logout_supported"`
+	FrontchannelLogoutSessionSupported bool `json:"frontchannel_logout_session_supported"`
+	// request objects by value and by reference; request_uri values must be registered, except /par ones
+	RequestParameterSupported              bool     `json:"request_parameter_supported"`
+	RequestURIParameterSupported           bool     `json:"request_uri_parameter_supported"`
+	RequireRequestURIRegistration          bool     `json:"require_request_uri_registration"`
+	RequestObjectSigningAlgValuesSupported []string `json:"request_object_signing_alg_values_supported"`
+	DPoPSigningAlgValuesSupported          []string `json:"dpop_signing_alg_values_supported"`
+	// JWT secured authorization responses are signed with the ID token key (JARM section 4)
+	AuthorizationSigningAlgValuesSupported []string `json:"authorization_signing_alg_values_supported"`
+	// PAR is only enforced for clients registered with require_pushed_authorization_requests
+	RequirePushedAuthorizationRequests bool `json:"require_pushed_authorization_requests"`
+	// clients can ask for individual claims in the ID token and at userinfo (OIDC Core section 5.5)
+	ClaimsParameterSupported bool `json:"claims_parameter_supported"`
+}
+
+// NewDiscovery builds the provider metadata from the configured issuer.
+func NewDiscovery(cfg *config.Config) Discovery {
+	return Discovery{
+		Issuer:                                    cfg.Issuer,
+		AuthorizationEndpoint:                     cfg.Issuer + AuthorizationPath,
+		TokenEndpoint:                             cfg.Issuer + TokenPath,
+		UserInfoEndpoint:                          cfg.Issuer + UserInfoPath,
+		JWKSURI:                                   cfg.Issuer + JWKSPath,
+		RegistrationEndpoint:                      cfg.Issuer + RegistrationPath,
+		IntrospectionEndpoint:                     cfg.Issuer + IntrospectionPath,
+		RevocationEndpoint:                        cfg.Issuer + RevocationPath,
+		EndSessionEndpoint:                        cfg.Issuer + EndSessionPath,
+		DeviceAuthorizationEndpoint:               cfg.Issuer + DeviceAuthorizationPath,
+		PushedAuthorizationRequestEndpoint:        cfg.Issuer + PARPath,
+		ScopesSupported:                           ScopesSupported,
+		ResponseTypesSupported:                    ResponseTypesSupported,
+		ResponseModesSupported:                    ResponseModes,
+		GrantTypesSupported:                       discoveryGrantTypes,
+		SubjectTypesSupported:                     SubjectTypes,
+		IDTokenSigningAlgValuesSupported:          []string{cfg.SigningAlg},
+		TokenEndpointAuthMethodsSupported:         TokenEndpointAuthMethods,
+		IntrospectionEndpointAuthMethodsSupported: []string{"client_secret_basic", "client_secret_post"},
+		RevocationEndpointAuthMethodsSupported:    TokenEndpointAuthMethods,
+		ClaimsSupported: []string{
+			"sub", "iss", "aud", "exp", "iat", "nonce", "sid", "auth_time",
+			"name", "preferred_username", "picture", "website", "about", "nip05", "updated_at",
+			"email", "email_verified", "npub",
+		},
+		CodeChallengeMethodsSupported:          []string{PKCEMethodS256, PKCEMethodPlain},
+		BackchannelLogoutSupported:             true,
+		BackchannelLogoutSessionSupported:      true,
+		FrontchannelLogoutSupported:            true,
+		FrontchannelLogoutSessionSupported:     true,
+		RequestParameterSupported:              true,
+		RequestURIParameterSupported:           true,
+		RequireRequestURIRegistration:          true,
+		RequestObjectSigningAlgValuesSupported: RequestObjectAlgs,
+		DPoPSigningAlgValuesSupported:          DPoPAlgs,
+		AuthorizationSigningAlgValuesSupported: []string{cfg.SigningAlg},
+		ClaimsParameterSupported:               true,
+	}
+}
+
+// DiscoveryHandler serves the discovery document as JSON, listing the claims admins mapped
+// along with the built-in ones.
+func DiscoveryHandler(cfg *config.Config, db *sql.DB) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		d := NewDiscovery(cfg)
+		for _, name := range mappedClaimNames(r.Context(), db) {
+			if !hasScope(d.ClaimsSupported, name) {
+				d.ClaimsSupported = append(d.ClaimsSupported, name)
+			}
+		}
+		w.Header().Set("Content-Type", "application/json")
+		w.Header().Set("Cache-Control", "public, max-age=3600")
+		if err := json.NewEncoder(w).Encode(d); err != nil {
+			http.Error(w, "failed to encode discovery document", http.StatusInternalServerError)
+		}
+	}
+}
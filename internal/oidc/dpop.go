@@ -0,0 +1,130 @@
+package oidc
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"time"
+
+	"github.com/lescuer97/nostr-oicd/internal/config"
+)
+
+const (
+	// dpopProofType is the JWS typ of DPoP proofs (RFC 9449 section 4.2).
+	dpopProofType = "dpop+jwt"
+	// dpopProofWindow is how far a proof's iat may be from now, either way.
+	dpopProofWindow = time.Minute
+	// maxDPoPJTIs caps the proof jtis remembered at once; past it, proofs are refused until
+	// older ones leave the window.
+	maxDPoPJTIs = 100000
+)
+
+// DPoPAlgs lists the algorithms accepted for DPoP proofs.
+var DPoPAlgs = []string{AlgES256, AlgRS256, AlgEdDSA}
+
+// contextDPoPKey holds the thumbprint of the key proven at the token endpoint, if any.
+const contextDPoPKey = contextKey("dpop_jkt")
+
+// dpopJTIs remembers the jti of every accepted proof until its iat leaves the window, so each
+// proof is accepted once (RFC 9449 section 11.1).
+var dpopJTIs = newReplayCache(maxDPoPJTIs)
+
+// accessTokenHash returns the ath claim for accessToken (RFC 9449 section 4.2).
+func accessTokenHash(accessToken string) string {
+	sum := sha256.Sum256([]byte(accessToken))
+	return base64.RawURLEncoding.EncodeToString(sum[:])
+}
+
+// verifyDPoPProof checks the DPoP header of r (RFC 9449 section 4.3) and returns the thumbprint
+// of the proof key. accessToken is the token the proof must be bound to at a protected resource;
+// empty at the token endpoint.
+func verifyDPoPProof(r *http.Request, cfg *config.Config, accessToken string) (string, error) {
+	values := r.Header.Values("DPoP")
+	if len(values) != 1 {
+		return "", errors.New("exactly one DPoP header is required")
+	}
+	proof := values[0]
+	header, _, _, _, err := parseJWS(proof)
+	if err != nil {
+		return "", err
+	}
+	if typ, _ := header["typ"].(string); typ != dpopProofType {
+		return "", errors.New("DPoP proof typ must be dpop+jwt")
+	}
+	alg, _ := header["alg"].(string)
+	if !hasScope(DPoPAlgs, alg) {
+		return "", fmt.Errorf("DPoP proof algorithm %q is not accepted", alg)
+	}
+	rawJWK, ok := header["jwk"].(map[string]any)
+	if !ok {
+		return "", errors.New("DPoP proof has no jwk header")
+	}
+	if _, private := rawJWK["d"]; private {
+		return "", errors.New("DPoP proof jwk must not contain a private key")
+	}
+	b, _ := json.Marshal(rawJWK)
+	var jwk JWK
+	if err := json.Unmarshal(b, &jwk); err != nil {
+		return "", errors.New("malformed DPoP proof jwk")
+	}
+	pub, err := jwk.PublicKey()
+	if err != nil {
+		return "", err
+	}
+	_, payload, err := verifyJWS(proof, alg, pub)
+	if err != nil {
+		return "", err
+	}
+
+	var claims struct {
+		JTI string  `json:"jti"`
+		HTM string  `json:"htm"`
+		HTU string  `json:"htu"`
+		IAT float64 `json:"iat"`
+		ATH string  `json:"ath"`
+	}
+	if err := json.Unmarshal(payload, &claims); err != nil {
+		return "", errors.New("malformed DPoP proof claims")
+	}
+	if claims.JTI == "" {
+		return "", errors.New("DPoP proof has no jti")
+	}
+	if claims.HTM != r.Method {
+		return "", errors.New("DPoP proof htm does not match the request method")
+	}
+	// htu is compared without query and fragment (RFC 9449 section 4.3)
+	if claims.HTU != cfg.Issuer+r.URL.Path {
+		return "", errors.New("DPoP proof htu does not match the request URL")
+	}
+	iat := time.Unix(int64(claims.IAT), 0)
+	if time.Since(iat) > dpopProofWindow || time.Until(iat) > dpopProofWindow {
+		return "", errors.New("DPoP proof iat is too far from now")
+	}
+	if accessToken != "" && claims.ATH != accessTokenHash(accessToken) {
+		return "", errors.New("DPoP proof ath does not match the access token")
+	}
+	jkt, err := jwk.Thumbprint()
+	if err != nil {
+		return "", err
+	}
+	if !dpopJTIs.use(jkt+":"+claims.JTI, iat.Add(dpopProofWindow)) {
+		return "", errors.New("DPoP proof was already used, or too many proofs are in use")
+	}
+	return jkt, nil
+}
+
+// dpopThumbprint returns the DPoP key thumbprint the token endpoint verified for ctx, if any.
+func dpopThumbprint(ctx context.Context) string {
+	jkt, _ := ctx.Value(contextDPoPKey).(string)
+	return jkt
+}
+
+// writeDPoPError answers a protected resource request with a DPoP challenge (RFC 9449 section 7.1).
+func writeDPoPError(w http.ResponseWriter, code, description string) {
+	w.Header().Set("WWW-Authenticate", fmt.Sprintf(`DPoP algs="ES256 RS256 EdDSA", error=%q, error_description=%q`, code, description))
+	http.Error(w, description, http.StatusUnauthorized)
+}
@@ -0,0 +1,279 @@
+package oidc
+
+import (
+	"crypto"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/lescuer97/nostr-oicd/internal/config"
+)
+
+// dpopProof returns a DPoP proof signed by key for POST to the token endpoint, with changes
+// applied to the header and claims; a nil value removes an entry.
+func dpopProof(t *testing.T, key crypto.Signer, alg string, headerChanges, claimChanges map[string]any) string {
+	t.Helper()
+	jwk := testJWK(t, key)
+	header := map[string]any{"typ": dpopProofType, "jwk": jwk}
+	claims := map[string]any{
+		"jti": "proof-" + t.Name(),
+		"htm": http.MethodPost,
+		"htu": "https://op.test" + TokenPath,
+		"iat": time.Now().Unix(),
+	}
+	apply := func(m, changes map[string]any) {
+		for k, v := range changes {
+			if v == nil {
+				delete(m, k)
+			} else {
+				m[k] = v
+			}
+		}
+	}
+	apply(header, headerChanges)
+	apply(claims, claimChanges)
+	payload, err := json.Marshal(claims)
+	if err != nil {
+		t.Fatalf("marshal claims: %v", err)
+	}
+	proof, err := signJWS(key, alg, header, payload)
+	if err != nil {
+		t.Fatalf("sign proof: %v", err)
+	}
+	return proof
+}
+
+// dpopRequest returns a request to target carrying proofs as DPoP headers.
+func dpopRequest(method, target string, proofs ...string) *http.Request {
+	r := httptest.NewRequest(method, target, nil)
+	for _, p := range proofs {
+		r.Header.Add("DPoP", p)
+	}
+	return r
+}
+
+func TestVerifyDPoPProof(t *testing.T) {
+	cfg := &config.Config{Issuer: "https://op.test"}
+	key := newTestKey(t, AlgES256)
+	jwk := testJWK(t, key)
+	wantJKT, err := jwk.Thumbprint()
+	if err != nil {
+		t.Fatalf("thumbprint: %v", err)
+	}
+	privateJWK := map[string]any{"kty": jwk.Kty, "crv": jwk.Crv, "x": jwk.X, "y": jwk.Y, "d": "AAAA"}
+	hs256, err := signHS256([]byte("secret"), map[string]any{"typ": dpopProofType, "jwk": jwk}, []byte(`{"jti":"x","htm":"POST","htu":"https://op.test/token"}`))
+	if err != nil {
+		t.Fatalf("sign HS256: %v", err)
+	}
+	const accessToken = "access-token"
+	tokenURL := "https://op.test" + TokenPath
+
+	tests := []struct {
+		name        string
+		method      string
+		target      string
+		accessToken string
+		// proofs builds the DPoP headers; the subtest name keeps jtis unique
+		proofs  func(t *testing.T) []string
+		wantErr string
+	}{
+		{
+			name: "valid", method: http.MethodPost, target: tokenURL,
+			proofs: func(t *testing.T) []string { return []string{dpopProof(t, key, AlgES256, nil, nil)} },
+		},
+		{
+			name: "htu ignores the query", method: http.MethodPost, target: tokenURL + "?x=1",
+			proofs: func(t *testing.T) []string { return []string{dpopProof(t, key, AlgES256, nil, nil)} },
+		},
+		{
+			name: "RS256", method: http.MethodPost, target: tokenURL,
+			proofs: func(t *testing.T) []string {
+				return []string{dpopProof(t, newTestKey(t, AlgRS256), AlgRS256, nil, nil)}
+			},
+		},
+		{
+			name: "EdDSA", method: http.MethodPost, target: tokenURL,
+			proofs: func(t *testing.T) []string {
+				return []string{dpopProof(t, newTestKey(t, AlgEdDSA), AlgEdDSA, nil, nil)}
+			},
+		},
+		{
+			name: "no proof", method: http.MethodPost, target: tokenURL,
+			proofs:  func(t *testing.T) []string { return nil },
+			wantErr: "exactly one DPoP header",
+		},
+		{
+			name: "two proofs", method: http.MethodPost, target: tokenURL,
+			proofs: func(t *testing.T) []string {
+				return []string{dpopProof(t, key, AlgES256, nil, nil), dpopProof(t, key, AlgES256, nil, map[string]any{"jti": "other"})}
+			},
+			wantErr: "exactly one DPoP header",
+		},
+		{
+			name: "other htm", method: http.MethodGet, target: tokenURL,
+			proofs:  func(t *testing.T) []string { return []string{dpopProof(t, key, AlgES256, nil, nil)} },
+			wantErr: "htm does not match",
+		},
+		{
+			name: "other htu", method: http.MethodPost, target: tokenURL,
+			proofs: func(t *testing.T) []string {
+				return []string{dpopProof(t, key, AlgES256, nil, map[string]any{"htu": "https://op.test" + UserInfoPath})}
+			},
+			wantErr: "htu does not match",
+		},
+		{
+			name: "htu of another host", method: http.MethodPost, target: tokenURL,
+			proofs: func(t *testing.T) []string {
+				return []string{dpopProof(t, key, AlgES256, nil, map[string]any{"htu": "https://evil.test" + TokenPath})}
+			},
+			wantErr: "htu does not match",
+		},
+		{
+			name: "no jti", method: http.MethodPost, target: tokenURL,
+			proofs: func(t *testing.T) []string {
+				return []string{dpopProof(t, key, AlgES256, nil, map[string]any{"jti": nil})}
+			},
+			wantErr: "no jti",
+		},
+		{
+			name: "old iat", method: http.MethodPost, target: tokenURL,
+			proofs: func(t *testing.T) []string {
+				return []string{dpopProof(t, key, AlgES256, nil, map[string]any{"iat": time.Now().Add(-2 * dpopProofWindow).Unix()})}
+			},
+			wantErr: "iat is too far from now",
+		},
+		{
+			name: "future iat", method: http.MethodPost, target: tokenURL,
+			proofs: func(t *testing.T) []string {
+				return []string{dpopProof(t, key, AlgES256, nil, map[string]any{"iat": time.Now().Add(2 * dpopProofWindow).Unix()})}
+			},
+			wantErr: "iat is too far from now",
+		},
+		{
+			name: "ath", method: http.MethodGet, target: "https://op.test" + UserInfoPath, accessToken: accessToken,
+			proofs: func(t *testing.T) []string {
+				return []string{dpopProof(t, key, AlgES256, nil, map[string]any{"htm": http.MethodGet, "htu": "https://op.test" + UserInfoPath, "ath": accessTokenHash(accessToken)})}
+			},
+		},
+		{
+			name: "missing ath", method: http.MethodGet, target: "https://op.test" + UserInfoPath, accessToken: accessToken,
+			proofs: func(t *testing.T) []string {
+				return []string{dpopProof(t, key, AlgES256, nil, map[string]any{"htm": http.MethodGet, "htu": "https://op.test" + UserInfoPath})}
+			},
+			wantErr: "ath does not match",
+		},
+		{
+			name: "ath of another token", method: http.MethodGet, target: "https://op.test" + UserInfoPath, accessToken: accessToken,
+			proofs: func(t *testing.T) []string {
+				return []string{dpopProof(t, key, AlgES256, nil, map[string]any{"htm": http.MethodGet, "htu": "https://op.test" + UserInfoPath, "ath": accessTokenHash("other")})}
+			},
+			wantErr: "ath does not match",
+		},
+		{
+			name: "other typ", method: http.MethodPost, target: tokenURL,
+			proofs: func(t *testing.T) []string {
+				return []string{dpopProof(t, key, AlgES256, map[string]any{"typ": "JWT"}, nil)}
+			},
+			wantErr: "typ must be dpop+jwt",
+		},
+		{
+			name: "HS256", method: http.MethodPost, target: tokenURL,
+			proofs:  func(t *testing.T) []string { return []string{hs256} },
+			wantErr: "is not accepted",
+		},
+		{
+			name: "no jwk", method: http.MethodPost, target: tokenURL,
+			proofs: func(t *testing.T) []string {
+				return []string{dpopProof(t, key, AlgES256, map[string]any{"jwk": nil}, nil)}
+			},
+			wantErr: "no jwk header",
+		},
+		{
+			name: "private jwk", method: http.MethodPost, target: tokenURL,
+			proofs: func(t *testing.T) []string {
+				return []string{dpopProof(t, key, AlgES256, map[string]any{"jwk": privateJWK}, nil)}
+			},
+			wantErr: "must not contain a private key",
+		},
+		{
+			name: "jwk of another key", method: http.MethodPost, target: tokenURL,
+			proofs: func(t *testing.T) []string {
+				return []string{dpopProof(t, key, AlgES256, map[string]any{"jwk": testJWK(t, newTestKey(t, AlgES256))}, nil)}
+			},
+			wantErr: "invalid JWS signature",
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			jkt, err := verifyDPoPProof(dpopRequest(tt.method, tt.target, tt.proofs(t)...), cfg, tt.accessToken)
+			if tt.wantErr != "" {
+				if err == nil || !strings.Contains(err.Error(), tt.wantErr) {
+					t.Fatalf("verifyDPoPProof() error = %v, want %q", err, tt.wantErr)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("verifyDPoPProof() error = %v", err)
+			}
+			if jkt == "" {
+				t.Error("no thumbprint returned")
+			}
+		})
+	}
+
+	t.Run("thumbprint", func(t *testing.T) {
+		jkt, err := verifyDPoPProof(dpopRequest(http.MethodPost, tokenURL, dpopProof(t, key, AlgES256, nil, nil)), cfg, "")
+		if err != nil {
+			t.Fatalf("verifyDPoPProof() error = %v", err)
+		}
+		if jkt != wantJKT {
+			t.Errorf("thumbprint = %s, want %s", jkt, wantJKT)
+		}
+	})
+}
+
+func TestVerifyDPoPProofReplay(t *testing.T) {
+	cfg := &config.Config{Issuer: "https://op.test"}
+	key := newTestKey(t, AlgES256)
+	tokenURL := "https://op.test" + TokenPath
+	proof := dpopProof(t, key, AlgES256, nil, nil)
+
+	if _, err := verifyDPoPProof(dpopRequest(http.MethodPost, tokenURL, proof), cfg, ""); err != nil {
+		t.Fatalf("first use: %v", err)
+	}
+	if _, err := verifyDPoPProof(dpopRequest(http.MethodPost, tokenURL, proof), cfg, ""); err == nil || !strings.Contains(err.Error(), "already used") {
+		t.Fatalf("replay: error = %v, want already used", err)
+	}
+	// jtis are remembered per key
+	other := dpopProof(t, newTestKey(t, AlgES256), AlgES256, nil, nil)
+	if _, err := verifyDPoPProof(dpopRequest(http.MethodPost, tokenURL, other), cfg, ""); err != nil {
+		t.Fatalf("same jti from another key: %v", err)
+	}
+}
+
+func TestReplayCache(t *testing.T) {
+	now := time.Now()
+	c := newReplayCache(2)
+	steps := []struct {
+		id      string
+		expires time.Time
+		want    bool
+	}{
+		{"a", now.Add(time.Minute), true},
+		{"a", now.Add(time.Minute), false},
+		{"b", now.Add(-time.Second), true},
+		// b has expired and is swept out to make room
+		{"c", now.Add(time.Minute), true},
+		// full of unexpired entries, and the last sweep was too recent to try again
+		{"d", now.Add(time.Minute), false},
+		{"c", now.Add(time.Minute), false},
+	}
+	for i, s := range steps {
+		if got := c.use(s.id, s.expires); got != s.want {
+			t.Fatalf("step %d: use(%q) = %v, want %v", i, s.id, got, s.want)
+		}
+	}
+}
@@ -0,0 +1,183 @@
+package oidc
+
+import (
+	"crypto/hmac"
+	"database/sql"
+	"fmt"
+	"log/slog"
+	"net/http"
+	"net/url"
+
+	"github.com/lescuer97/nostr-oicd/internal/config"
+	"github.com/lescuer97/nostr-oicd/internal/middleware"
+	"github.com/lescuer97/nostr-oicd/internal/models"
+	"github.com/lescuer97/nostr-oicd/templates/pages"
+)
+
+// endSessionRequest holds the validated parameters of a logout request.
+type endSessionRequest struct {
+	// Client is set when the request identified the relying party.
+	Client                *models.Client
+	PostLogoutRedirectURI string
+	State                 string
+	// HintSubject is the sub of a valid id_token_hint, empty without one.
+	HintSubject string
+}
+
+// ValidatePostLogoutRedirectURI checks a post-logout redirect URI registered for a client. It
+// must be an absolute http(s) URL: the signed-out page sends the browser there from script.
+func ValidatePostLogoutRedirectURI(uri string) error {
+	return validateOptionalURL("post-logout redirect URI", uri)
+}
+
+// resolveEndSession validates a logout request. The returned message is shown to the user;
+// errors never redirect since the redirect target itself may be what is invalid.
+func resolveEndSession(r *http.Request, db *sql.DB, keys *KeySet, params url.Values) (*endSessionRequest, string) {
+	req := &endSessionRequest{State: params.Get("state")}
+	clientID := params.Get("client_id")
+	if hint := params.Get("id_token_hint"); hint != "" {
+		// expired ID tokens are still good hints (RP-Initiated Logout section 2)
+		claims, err := keys.Verify(hint)
+		if err != nil {
+			return nil, "invalid id_token_hint"
+		}
+		aud, _ := claims["aud"].(string)
+		if clientID != "" && clientID != aud {
+			return nil, "client_id does not match id_token_hint"
+		}
+		clientID = aud
+		req.HintSubject, _ = claims["sub"].(string)
+	}
+	if clientID != "" {
+		client, err := models.GetClientByClientID(r.Context(), db, clientID)
+		if err != nil {
+			if err != sql.ErrNoRows {
+				slog.Error("oidc_end_session_client_lookup_failed", "client_id", clientID, "error", err.Error())
+			}
+			return nil, "unknown client_id"
+		}
+		req.Client = client
+	}
+	if uri := params.Get("post_logout_redirect_uri"); uri != "" {
+		if req.Client == nil {
+			return nil, "post_logout_redirect_uri requires id_token_hint or client_id"
+		}
+		if !req.Client.HasPostLogoutRedirectURI(uri) {
+			return nil, "post_logout_redirect_uri is not registered for this client"
+		}
+		req.PostLogoutRedirectURI = uri
+	}
+	return req, ""
+}
+
+// logoutConfirmToken binds the logout confirmation form to the session and the exact request.
+func logoutConfirmToken(cfg *config.Config, sess *models.Session, request string) string {
+	return hashToken(cfg, fmt.Sprintf("logout:%d:%s", sess.ID, request))
+}
+
+// clearSessionCookie removes the browser session cookie.
+func clearSessionCookie(w http.ResponseWriter, cfg *config.Config) {
+	http.SetCookie(w, &http.Cookie{
+		Name:     cfg.CookieName,
+		Value:    "",
+		Path:     "/",
+		HttpOnly: true,
+		Secure:   cfg.CookieSecure,
+		MaxAge:   -1,
+	})
+}
+
+// EndSessionHandler implements RP-initiated logout (OpenID Connect RP-Initiated Logout 1.0).
+// A valid id_token_hint for the signed-in user ends the session right away; otherwise the user
+// confirms first, so other sites cannot sign them out. Afterwards the browser is sent to the
+// registered post_logout_redirect_uri with state, or shown the signed-out page.
+func EndSessionHandler(cfg *config.Config, db *sql.DB, keys *KeySet) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		if err := r.ParseForm(); err != nil {
+			http.Error(w, "invalid request", http.StatusBadRequest)
+			return
+		}
+		params := r.Form
+		confirming := r.Method == http.MethodPost && r.PostForm.Has("confirm_token")
+		request := params.Encode()
+		if confirming {
+			request = r.PostForm.Get("request")
+			var err error
+			if params, err = url.ParseQuery(request); err != nil {
+				http.Error(w, "invalid request", http.StatusBadRequest)
+				return
+			}
+		}
+		req, msg := resolveEndSession(r, db, keys, params)
+		if msg != "" {
+			http.Error(w, msg, http.StatusBadRequest)
+			return
+		}
+
+		var frames []string
+		if sess, user, err := middleware.SessionFromRequest(r, cfg, db); err == nil {
+			switch {
+			case req.HintSubject != "" && req.HintSubject != subjectFor(cfg, req.Client, user):
+				// the relying party's user is not the one signed in here; leave this session alone
+				slog.Info("oidc_end_session_hint_mismatch", "user_id", user.ID, "remote", r.RemoteAddr)
+			case req.HintSubject == "" && !confirming:
+				w.Header().Set("Content-Type", "text/html; charset=utf-8")
+				w.Header().Set("Cache-Control", "no-store")
+				w.Header().Set("X-Frame-Options", "DENY")
+				clientName := ""
+				if req.Client != nil {
+					clientName = req.Client.Name
+				}
+				if err := pages.LogoutConfirmPage(user.PublicKey, clientName, request, logoutConfirmToken(cfg, sess, request)).Render(r.Context(), w); err != nil {
+					http.Error(w, "failed to render", http.StatusInternalServerError)
+				}
+				return
+			case confirming && !hmac.Equal([]byte(r.PostForm.Get("confirm_token")), []byte(logoutConfirmToken(cfg, sess, request))):
+				http.Error(w, "invalid logout request", http.StatusBadRequest)
+				return
+			case confirming && r.PostForm.Get("decision") != "logout":
+				http.Redirect(w, r, "/dashboard", http.StatusSeeOther)
+				return
+			default:
+				queued, err := models.EndSession(r.Context(), db, sess.ID)
+				if err != nil {
+					slog.Error("oidc_end_session_failed", "user_id", user.ID, "session_id", sess.ID, "error", err.Error())
+					http.Error(w, "failed to end session", http.StatusInternalServerError)
+					return
+				}
+				clearSessionCookie(w, cfg)
+				if frames, err = FrontchannelLogoutURLs(r.Context(), cfg, db, sess.ID); err != nil {
+					slog.Error("oidc_frontchannel_logout_lookup_failed", "session_id", sess.ID, "error", err.Error())
+				}
+				clientID := ""
+				if req.Client != nil {
+					clientID = req.Client.ClientID
+				}
+				slog.Info("oidc_end_session", "user_id", user.ID, "session_id", sess.ID, "client_id", clientID, "backchannel_notifications", queued, "remote", r.RemoteAddr)
+			}
+		}
+
+		next := ""
+		if req.PostLogoutRedirectURI != "" {
+			params := url.Values{}
+			if req.State != "" {
+				params.Set("state", req.State)
+			}
+			if len(frames) == 0 {
+				redirectWithParams(w, r, req.PostLogoutRedirectURI, params)
+				return
+			}
+			var err error
+			if next, err = urlWithParams(req.PostLogoutRedirectURI, params); err != nil {
+				http.Error(w, "invalid post_logout_redirect_uri", http.StatusBadRequest)
+				return
+			}
+		}
+		// the front-channel iframes must load before the browser moves on to next
+		w.Header().Set("Content-Type", "text/html; charset=utf-8")
+		w.Header().Set("Cache-Control", "no-store")
+		if err := pages.LoggedOutPage(frames, next).Render(r.Context(), w); err != nil {
+			http.Error(w, "failed to render", http.StatusInternalServerError)
+		}
+	}
+}
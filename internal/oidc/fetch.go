@@ -0,0 +1,60 @@
+package oidc
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log/slog"
+	"net/netip"
+	"net/url"
+	"strings"
+	"time"
+
+	"github.com/lescuer97/nostr-oicd/internal/outbound"
+)
+
+// errFetchFailed is the only error fetchDocument reports, so clients cannot use the provider to
+// learn which hosts and ports it reaches. The cause is logged.
+var errFetchFailed = errors.New("the document could not be fetched")
+
+// fetchClient fetches client-hosted documents: request objects by reference, jwks_uri and
+// sector_identifier_uri.
+var fetchClient = outbound.NewClient(5 * time.Second)
+
+// validateFetchURL checks a URL the provider fetches or posts to for a client: an absolute https
+// URL without fragment or credentials, whose host, when an IP address, is public. Host names are
+// checked when they are resolved.
+func validateFetchURL(param, uri string) error {
+	if uri == "" {
+		return nil
+	}
+	u, err := url.Parse(uri)
+	if err != nil || u.Scheme != "https" || u.Host == "" || u.Fragment != "" || u.User != nil {
+		return fmt.Errorf("invalid %s %q: must be an absolute https URL without fragment", param, uri)
+	}
+	host := u.Hostname()
+	if addr, err := netip.ParseAddr(host); (err == nil && !outbound.IsPublicAddr(addr)) || isLoopbackHost(host) {
+		return fmt.Errorf("invalid %s %q: must be on a public host", param, uri)
+	}
+	return nil
+}
+
+// fetchDocument GETs uri and returns its body, which must be 200 OK and at most maxFetchedDocument bytes.
+func fetchDocument(ctx context.Context, uri string) ([]byte, error) {
+	body, err := getDocument(ctx, uri)
+	if err != nil {
+		slog.Warn("oidc_fetch_failed", "url", uri, "error", err.Error())
+		return nil, errFetchFailed
+	}
+	return body, nil
+}
+
+// getDocument does the work of fetchDocument, with a descriptive error.
+func getDocument(ctx context.Context, uri string) ([]byte, error) {
+	// the fragment is never sent, a request_uri may carry one
+	uri, _, _ = strings.Cut(uri, "#")
+	if err := validateFetchURL("URL", uri); err != nil {
+		return nil, err
+	}
+	return outbound.Get(ctx, fetchClient, uri, maxFetchedDocument)
+}
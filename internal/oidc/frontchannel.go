@@ -0,0 +1,42 @@
+package oidc
+
+import (
+	"context"
+	"database/sql"
+	"log/slog"
+	"net/url"
+
+	"github.com/lescuer97/nostr-oicd/internal/config"
+	"github.com/lescuer97/nostr-oicd/internal/models"
+)
+
+// ValidateFrontchannelLogoutURI checks a frontchannel_logout_uri, which may be empty
+// (Front-Channel Logout section 2).
+func ValidateFrontchannelLogoutURI(uri string) error {
+	return validateOptionalURL("frontchannel_logout_uri", uri)
+}
+
+// FrontchannelLogoutURLs returns the iframe URLs that tell the browser-side clients of a
+// session that it ended (OpenID Connect Front-Channel Logout 1.0). Each URL carries iss and
+// the session's sid, the same sid as in the ID tokens issued in it.
+func FrontchannelLogoutURLs(ctx context.Context, cfg *config.Config, db *sql.DB, sessionID int64) ([]string, error) {
+	clients, err := models.ListSessionClients(ctx, db, sessionID)
+	if err != nil {
+		return nil, err
+	}
+	params := url.Values{"iss": {cfg.Issuer}, "sid": {sessionSID(cfg, sessionID)}}
+	var frames []string
+	for _, c := range clients {
+		if c.FrontchannelLogoutURI == "" {
+			continue
+		}
+		frame, err := urlWithParams(c.FrontchannelLogoutURI, params)
+		if err != nil {
+			// validated on registration, so only a hand-edited row gets here
+			slog.Warn("oidc_frontchannel_logout_invalid_uri", "client_id", c.ClientID, "error", err.Error())
+			continue
+		}
+		frames = append(frames, frame)
+	}
+	return frames, nil
+}
@@ -0,0 +1,152 @@
+package oidc
+
+import (
+	"context"
+	"database/sql"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/lescuer97/nostr-oicd/internal/config"
+	"github.com/lescuer97/nostr-oicd/internal/database"
+	"github.com/lescuer97/nostr-oicd/internal/models"
+)
+
+const (
+	testClientID     = "app"
+	testClientSecret = "s3cret"
+	testRedirectURI  = "https://rp.test/cb"
+	testPubKey       = "4f355bdcb7cc0af728ef3cceb9615d90684bb5b2ca5f859ab0f0b704075871aa"
+)
+
+// testProvider is a provider on a fresh, migrated database with one confidential client and
+// one signed-in user.
+type testProvider struct {
+	cfg       *config.Config
+	db        *sql.DB
+	keys      *KeySet
+	client    *models.Client
+	userID    int64
+	sessionID int64
+}
+
+func newTestProvider(t *testing.T) *testProvider {
+	t.Helper()
+	ctx := context.Background()
+	db, err := database.Open(filepath.Join(t.TempDir(), "test.sqlite3"))
+	if err != nil {
+		t.Fatalf("open database: %v", err)
+	}
+	t.Cleanup(func() { db.Close() })
+	if err := database.RunMigrations(db, "../../database/migrations"); err != nil {
+		t.Fatalf("run migrations: %v", err)
+	}
+	cfg := config.LoadFromEnv()
+	cfg.Issuer = "https://op.test"
+	keys, err := NewKeySet(ctx, cfg, db)
+	if err != nil {
+		t.Fatalf("create keyset: %v", err)
+	}
+
+	client := &models.Client{
+		ClientID:                testClientID,
+		Name:                    "App",
+		RedirectURIs:            []string{testRedirectURI},
+		Scopes:                  []string{"openid", "profile", "offline_access"},
+		SecretHash:              hashToken(cfg, testClientSecret),
+		TokenEndpointAuthMethod: "client_secret_basic",
+		GrantTypes:              []string{"authorization_code", "refresh_token"},
+		ResponseTypes:           []string{"code"},
+		SubjectType:             SubjectPublic,
+	}
+	if client.ID, err = models.CreateClient(ctx, db, client); err != nil {
+		t.Fatalf("create client: %v", err)
+	}
+	userID, err := models.EnsureUser(ctx, db, testPubKey)
+	if err != nil {
+		t.Fatalf("create user: %v", err)
+	}
+	sessionID, err := models.CreateSession(ctx, db, userID, hashToken(cfg, "session"), time.Now().Add(time.Hour))
+	if err != nil {
+		t.Fatalf("create session: %v", err)
+	}
+	return &testProvider{cfg: cfg, db: db, keys: keys, client: client, userID: userID, sessionID: sessionID}
+}
+
+// newCode stores an authorization code for the test client and user and returns it. challenge
+// and method are the PKCE code_challenge and code_challenge_method, empty for none.
+func (p *testProvider) newCode(t *testing.T, scope, challenge, method string) string {
+	t.Helper()
+	code, err := generateRandomToken(32)
+	if err != nil {
+		t.Fatalf("generate code: %v", err)
+	}
+	now := time.Now()
+	if _, err := models.CreateAuthorizationCode(context.Background(), p.db, &models.AuthorizationCode{
+		CodeHash:            hashToken(p.cfg, code),
+		ClientID:            testClientID,
+		UserID:              p.userID,
+		SessionID:           p.sessionID,
+		RedirectURI:         testRedirectURI,
+		Scope:               scope,
+		Nonce:               "n1",
+		CodeChallenge:       challenge,
+		CodeChallengeMethod: method,
+		AuthTime:            now,
+		ExpiresAt:           now.Add(authCodeTTL),
+	}); err != nil {
+		t.Fatalf("create code: %v", err)
+	}
+	return code
+}
+
+// token posts form to the token endpoint as the test client and returns the status and the
+// decoded JSON response.
+func (p *testProvider) token(t *testing.T, form url.Values) (int, map[string]any) {
+	t.Helper()
+	req := httptest.NewRequest(http.MethodPost, TokenPath, strings.NewReader(form.Encode()))
+	req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+	req.SetBasicAuth(testClientID, testClientSecret)
+	rec := httptest.NewRecorder()
+	TokenHandler(p.cfg, p.db, p.keys).ServeHTTP(rec, req)
+	var body map[string]any
+	if err := json.Unmarshal(rec.Body.Bytes(), &body); err != nil {
+		t.Fatalf("decode token response %q: %v", rec.Body.String(), err)
+	}
+	return rec.Code, body
+}
+
+// exchangeCode redeems code at the token endpoint.
+func (p *testProvider) exchangeCode(t *testing.T, code, verifier string) (int, map[string]any) {
+	t.Helper()
+	form := url.Values{"grant_type": {"authorization_code"}, "code": {code}, "redirect_uri": {testRedirectURI}}
+	if verifier != "" {
+		form.Set("code_verifier", verifier)
+	}
+	return p.token(t, form)
+}
+
+// accessTokenActive reports whether the access token is still active.
+func (p *testProvider) accessTokenActive(t *testing.T, token string) bool {
+	t.Helper()
+	_, err := models.GetAccessTokenByHash(context.Background(), p.db, hashToken(p.cfg, token))
+	if err != nil && err != sql.ErrNoRows {
+		t.Fatalf("look up access token: %v", err)
+	}
+	return err == nil
+}
+
+// refreshTokenActive reports whether the refresh token is still active.
+func (p *testProvider) refreshTokenActive(t *testing.T, token string) bool {
+	t.Helper()
+	rt, err := models.GetRefreshTokenByHash(context.Background(), p.db, hashToken(p.cfg, token))
+	if err != nil {
+		t.Fatalf("look up refresh token: %v", err)
+	}
+	return rt.Active
+}
@@ -0,0 +1,186 @@
+package oidc
+
+import (
+	"database/sql"
+	"log/slog"
+	"net/http"
+	"time"
+
+	"github.com/lescuer97/nostr-oicd/internal/config"
+	"github.com/lescuer97/nostr-oicd/internal/models"
+)
+
+// introspectionResponse is the RFC 7662 section 2.2 response. Inactive tokens only carry active=false.
+type introspectionResponse struct {
+	Active    bool   `json:"active"`
+	Scope     string `json:"scope,omitempty"`
+	ClientID  string `json:"client_id,omitempty"`
+	Sub       string `json:"sub,omitempty"`
+	TokenType string `json:"token_type,omitempty"`
+	Exp       int64  `json:"exp,omitempty"`
+	Iat       int64  `json:"iat,omitempty"`
+	Iss       string `json:"iss,omitempty"`
+	// Cnf carries the thumbprint of the key a DPoP-bound token is bound to (RFC 9449 section 6.2).
+	Cnf *confirmation `json:"cnf,omitempty"`
+}
+
+// confirmation is the cnf claim of a sender-constrained token.
+type confirmation struct {
+	JKT string `json:"jkt"`
+}
+
+// subjectOf returns the sub of the user a token was issued for, as seen by the token's client.
+// Tokens issued without a user (client_credentials) act for the client itself, so their subject
+// is clientID.
+func subjectOf(r *http.Request, cfg *config.Config, db *sql.DB, userID *int64, clientID string) (string, bool) {
+	if userID == nil {
+		return clientID, true
+	}
+	user, err := models.GetUserByID(r.Context(), db, *userID)
+	if err != nil {
+		return "", false
+	}
+	client, err := models.GetClientByClientID(r.Context(), db, clientID)
+	if err != nil {
+		return "", false
+	}
+	return subjectFor(cfg, client, user), true
+}
+
+// introspectAccessToken returns the introspection response for an access token, or nil if unknown or inactive.
+func introspectAccessToken(r *http.Request, cfg *config.Config, db *sql.DB, tokenHash string) *introspectionResponse {
+	at, err := models.GetAccessTokenByHash(r.Context(), db, tokenHash)
+	if err != nil {
+		return nil
+	}
+	sub, ok := subjectOf(r, cfg, db, at.UserID, at.ClientID)
+	if !ok {
+		return nil
+	}
+	resp := &introspectionResponse{
+		Active:    true,
+		Scope:     at.Scope,
+		ClientID:  at.ClientID,
+		Sub:       sub,
+		TokenType: "Bearer",
+		Exp:       at.ExpiresAt.Unix(),
+		Iat:       at.CreatedAt.Unix(),
+		Iss:       cfg.Issuer,
+	}
+	if at.DPoPJKT != "" {
+		resp.TokenType = "DPoP"
+		resp.Cnf = &confirmation{JKT: at.DPoPJKT}
+	}
+	return resp
+}
+
+// introspectRefreshToken returns the introspection response for a refresh token, or nil if unknown,
+// revoked, already rotated or expired.
+func introspectRefreshToken(r *http.Request, cfg *config.Config, db *sql.DB, tokenHash string) *introspectionResponse {
+	rt, err := models.GetRefreshTokenByHash(r.Context(), db, tokenHash)
+	if err != nil || !rt.Active || rt.Used || time.Now().After(rt.ExpiresAt) {
+		return nil
+	}
+	sub, ok := subjectOf(r, cfg, db, &rt.UserID, rt.ClientID)
+	if !ok {
+		return nil
+	}
+	return &introspectionResponse{
+		Active:   true,
+		Scope:    rt.Scope,
+		ClientID: rt.ClientID,
+		Sub:      sub,
+		Exp:      rt.ExpiresAt.Unix(),
+		Iat:      rt.CreatedAt.Unix(),
+		Iss:      cfg.Issuer,
+	}
+}
+
+// IntrospectHandler implements token introspection (RFC 7662) for resource servers such as API
+// gateways. Callers authenticate as confidential clients and may introspect any token.
+func IntrospectHandler(cfg *config.Config, db *sql.DB) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		if err := r.ParseForm(); err != nil {
+			writeTokenError(w, &tokenError{http.StatusBadRequest, "invalid_request", "invalid form body"})
+			return
+		}
+		client, terr := authenticateClient(r, cfg, db)
+		if terr != nil {
+			writeTokenError(w, terr)
+			return
+		}
+		if client.IsPublic() {
+			// public clients cannot prove their identity, so they must not probe tokens
+			writeTokenError(w, &tokenError{http.StatusUnauthorized, "invalid_client", "introspection requires client authentication"})
+			return
+		}
+		token := r.PostForm.Get("token")
+		if token == "" {
+			writeTokenError(w, &tokenError{http.StatusBadRequest, "invalid_request", "token is required"})
+			return
+		}
+
+		tokenHash := hashToken(cfg, token)
+		var resp *introspectionResponse
+		if r.PostForm.Get("token_type_hint") == "refresh_token" {
+			if resp = introspectRefreshToken(r, cfg, db, tokenHash); resp == nil {
+				resp = introspectAccessToken(r, cfg, db, tokenHash)
+			}
+		} else if resp = introspectAccessToken(r, cfg, db, tokenHash); resp == nil {
+			resp = introspectRefreshToken(r, cfg, db, tokenHash)
+		}
+		if resp == nil {
+			resp = &introspectionResponse{Active: false}
+		}
+		slog.Info("oidc_token_introspected", "client_id", client.ClientID, "active", resp.Active, "token_client_id", resp.ClientID)
+		writeJSON(w, http.StatusOK, resp)
+	}
+}
+
+// RevokeHandler implements token revocation (RFC 7009). Revoking a refresh token revokes its whole
+// family, including access tokens issued from it. Unknown tokens and tokens of other clients are
+// answered with 200 as well, so the endpoint cannot be used to probe tokens.
+func RevokeHandler(cfg *config.Config, db *sql.DB) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		ctx := r.Context()
+		if err := r.ParseForm(); err != nil {
+			writeTokenError(w, &tokenError{http.StatusBadRequest, "invalid_request", "invalid form body"})
+			return
+		}
+		client, terr := authenticateClient(r, cfg, db)
+		if terr != nil {
+			writeTokenError(w, terr)
+			return
+		}
+		token := r.PostForm.Get("token")
+		if token == "" {
+			writeTokenError(w, &tokenError{http.StatusBadRequest, "invalid_request", "token is required"})
+			return
+		}
+		tokenHash := hashToken(cfg, token)
+
+		if rt, err := models.GetRefreshTokenByHash(ctx, db, tokenHash); err == nil {
+			if rt.ClientID != client.ClientID {
+				slog.Warn("oidc_revoke_foreign_token", "client_id", client.ClientID, "token_client_id", rt.ClientID, "remote", r.RemoteAddr)
+			} else if n, err := models.RevokeRefreshTokenFamily(ctx, db, rt.FamilyID); err != nil {
+				slog.Error("oidc_revoke_failed", "client_id", client.ClientID, "error", err.Error())
+				writeTokenError(w, &tokenError{http.StatusServiceUnavailable, "server_error", "failed to revoke token"})
+				return
+			} else {
+				slog.Info("oidc_token_revoked", "client_id", client.ClientID, "token_type", "refresh_token", "family_id", rt.FamilyID, "revoked_refresh_tokens", n)
+			}
+		} else if at, err := models.GetAccessTokenByHash(ctx, db, tokenHash); err == nil {
+			if at.ClientID != client.ClientID {
+				slog.Warn("oidc_revoke_foreign_token", "client_id", client.ClientID, "token_client_id", at.ClientID, "remote", r.RemoteAddr)
+			} else if _, err := models.DeactivateAccessTokenByHash(ctx, db, tokenHash); err != nil {
+				slog.Error("oidc_revoke_failed", "client_id", client.ClientID, "error", err.Error())
+				writeTokenError(w, &tokenError{http.StatusServiceUnavailable, "server_error", "failed to revoke token"})
+				return
+			} else {
+				slog.Info("oidc_token_revoked", "client_id", client.ClientID, "token_type", "access_token")
+			}
+		}
+		w.Header().Set("Cache-Control", "no-store")
+		w.WriteHeader(http.StatusOK)
+	}
+}
@@ -0,0 +1,261 @@
+package oidc
+
+import (
+	"crypto"
+	"crypto/ecdsa"
+	"crypto/ed25519"
+	"crypto/elliptic"
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"math/big"
+	"strings"
+)
+
+// Supported JWS algorithms for provider signing keys.
+const (
+	AlgES256 = "ES256"
+	AlgRS256 = "RS256"
+	AlgEdDSA = "EdDSA"
+)
+
+// JWK is a public JSON Web Key (RFC 7517) for one of the supported key types.
+type JWK struct {
+	Kty string `json:"kty"`
+	Kid string `json:"kid,omitempty"`
+	Use string `json:"use,omitempty"`
+	Alg string `json:"alg,omitempty"`
+	Crv string `json:"crv,omitempty"`
+	X   string `json:"x,omitempty"`
+	Y   string `json:"y,omitempty"`
+	N   string `json:"n,omitempty"`
+	E   string `json:"e,omitempty"`
+}
+
+// JWKSet is the document served at the jwks_uri.
+type JWKSet struct {
+	Keys []JWK `json:"keys"`
+}
+
+// generateSigningKey creates a new private key for alg.
+func generateSigningKey(alg string) (crypto.Signer, error) {
+	switch alg {
+	case AlgES256:
+		return ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	case AlgRS256:
+		return rsa.GenerateKey(rand.Reader, 2048)
+	case AlgEdDSA:
+		_, priv, err := ed25519.GenerateKey(rand.Reader)
+		return priv, err
+	default:
+		return nil, fmt.Errorf("unsupported signing algorithm %q", alg)
+	}
+}
+
+// publicJWK returns the public JWK for key (without kid/use/alg).
+func publicJWK(pub crypto.PublicKey) (JWK, error) {
+	b64 := base64.RawURLEncoding.EncodeToString
+	switch k := pub.(type) {
+	case *ecdsa.PublicKey:
+		if k.Curve != elliptic.P256() {
+			return JWK{}, errors.New("unsupported EC curve")
+		}
+		return JWK{Kty: "EC", Crv: "P-256", X: b64(k.X.FillBytes(make([]byte, 32))), Y: b64(k.Y.FillBytes(make([]byte, 32)))}, nil
+	case *rsa.PublicKey:
+		return JWK{Kty: "RSA", N: b64(k.N.Bytes()), E: b64(big.NewInt(int64(k.E)).Bytes())}, nil
+	case ed25519.PublicKey:
+		return JWK{Kty: "OKP", Crv: "Ed25519", X: b64(k)}, nil
+	default:
+		return JWK{}, errors.New("unsupported public key type")
+	}
+}
+
+// PublicKey decodes k into the crypto public key verifySignature expects for its key type.
+func (k JWK) PublicKey() (crypto.PublicKey, error) {
+	b64 := base64.RawURLEncoding.DecodeString
+	switch k.Kty {
+	case "EC":
+		if k.Crv != "P-256" {
+			return nil, fmt.Errorf("unsupported EC curve %q", k.Crv)
+		}
+		x, errX := b64(k.X)
+		y, errY := b64(k.Y)
+		if errX != nil || errY != nil || len(x) != 32 || len(y) != 32 {
+			return nil, errors.New("malformed EC key")
+		}
+		pub := &ecdsa.PublicKey{Curve: elliptic.P256(), X: new(big.Int).SetBytes(x), Y: new(big.Int).SetBytes(y)}
+		if !pub.Curve.IsOnCurve(pub.X, pub.Y) {
+			return nil, errors.New("EC key is not on the curve")
+		}
+		return pub, nil
+	case "RSA":
+		n, errN := b64(k.N)
+		e, errE := b64(k.E)
+		if errN != nil || errE != nil || len(e) == 0 || len(e) > 4 {
+			return nil, errors.New("malformed RSA key")
+		}
+		pub := &rsa.PublicKey{N: new(big.Int).SetBytes(n), E: int(new(big.Int).SetBytes(e).Int64())}
+		if pub.N.BitLen() < 2048 {
+			return nil, errors.New("RSA key must be at least 2048 bits")
+		}
+		return pub, nil
+	case "OKP":
+		x, err := b64(k.X)
+		if k.Crv != "Ed25519" || err != nil || len(x) != ed25519.PublicKeySize {
+			return nil, errors.New("malformed OKP key, only Ed25519 is supported")
+		}
+		return ed25519.PublicKey(x), nil
+	default:
+		return nil, fmt.Errorf("unsupported key type %q", k.Kty)
+	}
+}
+
+// Thumbprint returns the base64url SHA-256 JWK thumbprint (RFC 7638) of the key.
+func (k JWK) Thumbprint() (string, error) {
+	// required members only, in lexicographic order
+	var canonical string
+	switch k.Kty {
+	case "EC":
+		canonical = fmt.Sprintf(`{"crv":%q,"kty":"EC","x":%q,"y":%q}`, k.Crv, k.X, k.Y)
+	case "RSA":
+		canonical = fmt.Sprintf(`{"e":%q,"kty":"RSA","n":%q}`, k.E, k.N)
+	case "OKP":
+		canonical = fmt.Sprintf(`{"crv":%q,"kty":"OKP","x":%q}`, k.Crv, k.X)
+	default:
+		return "", fmt.Errorf("unsupported key type %q", k.Kty)
+	}
+	sum := sha256.Sum256([]byte(canonical))
+	return base64.RawURLEncoding.EncodeToString(sum[:]), nil
+}
+
+// signJWS returns a compact JWS of payload signed with key using alg.
+func signJWS(key crypto.Signer, alg string, header map[string]any, payload []byte) (string, error) {
+	header["alg"] = alg
+	hb, err := json.Marshal(header)
+	if err != nil {
+		return "", err
+	}
+	signingInput := base64.RawURLEncoding.EncodeToString(hb) + "." + base64.RawURLEncoding.EncodeToString(payload)
+
+	var sig []byte
+	switch alg {
+	case AlgES256:
+		ek, ok := key.(*ecdsa.PrivateKey)
+		if !ok {
+			return "", errors.New("ES256 requires an ECDSA key")
+		}
+		digest := sha256.Sum256([]byte(signingInput))
+		r, s, err := ecdsa.Sign(rand.Reader, ek, digest[:])
+		if err != nil {
+			return "", err
+		}
+		// JWS uses the fixed-size R || S encoding, not ASN.1 (RFC 7518 section 3.4)
+		sig = append(r.FillBytes(make([]byte, 32)), s.FillBytes(make([]byte, 32))...)
+	case AlgRS256:
+		digest := sha256.Sum256([]byte(signingInput))
+		sig, err = key.Sign(rand.Reader, digest[:], crypto.SHA256)
+	case AlgEdDSA:
+		sig, err = key.Sign(rand.Reader, []byte(signingInput), crypto.Hash(0))
+	default:
+		return "", fmt.Errorf("unsupported signing algorithm %q", alg)
+	}
+	if err != nil {
+		return "", err
+	}
+	return signingInput + "." + base64.RawURLEncoding.EncodeToString(sig), nil
+}
+
+// AlgHS256 is only used for provider-internal tokens that are never verified by third parties.
+const AlgHS256 = "HS256"
+
+// signHS256 returns a compact JWS of payload authenticated with HMAC-SHA256 under key.
+func signHS256(key []byte, header map[string]any, payload []byte) (string, error) {
+	header["alg"] = AlgHS256
+	hb, err := json.Marshal(header)
+	if err != nil {
+		return "", err
+	}
+	signingInput := base64.RawURLEncoding.EncodeToString(hb) + "." + base64.RawURLEncoding.EncodeToString(payload)
+	mac := hmac.New(sha256.New, key)
+	mac.Write([]byte(signingInput))
+	return signingInput + "." + base64.RawURLEncoding.EncodeToString(mac.Sum(nil)), nil
+}
+
+// parseJWS splits a compact JWS into its decoded header, payload, signing input and signature.
+func parseJWS(token string) (map[string]any, []byte, string, []byte, error) {
+	parts := strings.Split(token, ".")
+	if len(parts) != 3 {
+		return nil, nil, "", nil, errors.New("malformed JWS")
+	}
+	hb, err := base64.RawURLEncoding.DecodeString(parts[0])
+	if err != nil {
+		return nil, nil, "", nil, errors.New("malformed JWS header")
+	}
+	var header map[string]any
+	if err := json.Unmarshal(hb, &header); err != nil {
+		return nil, nil, "", nil, errors.New("malformed JWS header")
+	}
+	payload, err := base64.RawURLEncoding.DecodeString(parts[1])
+	if err != nil {
+		return nil, nil, "", nil, errors.New("malformed JWS payload")
+	}
+	sig, err := base64.RawURLEncoding.DecodeString(parts[2])
+	if err != nil {
+		return nil, nil, "", nil, errors.New("malformed JWS signature")
+	}
+	return header, payload, parts[0] + "." + parts[1], sig, nil
+}
+
+// verifySignature checks sig over signingInput for alg. key is a []byte secret for HS256
+// or the matching public key type for ES256, RS256 and EdDSA.
+func verifySignature(alg string, key any, signingInput string, sig []byte) error {
+	digest := sha256.Sum256([]byte(signingInput))
+	ok := false
+	switch alg {
+	case AlgHS256:
+		secret, isSecret := key.([]byte)
+		if isSecret {
+			mac := hmac.New(sha256.New, secret)
+			mac.Write([]byte(signingInput))
+			ok = hmac.Equal(mac.Sum(nil), sig)
+		}
+	case AlgES256:
+		pub, isEC := key.(*ecdsa.PublicKey)
+		if isEC && len(sig) == 64 {
+			ok = ecdsa.Verify(pub, digest[:], new(big.Int).SetBytes(sig[:32]), new(big.Int).SetBytes(sig[32:]))
+		}
+	case AlgRS256:
+		pub, isRSA := key.(*rsa.PublicKey)
+		ok = isRSA && rsa.VerifyPKCS1v15(pub, crypto.SHA256, digest[:], sig) == nil
+	case AlgEdDSA:
+		pub, isEd := key.(ed25519.PublicKey)
+		ok = isEd && ed25519.Verify(pub, []byte(signingInput), sig)
+	default:
+		return fmt.Errorf("unsupported JWS algorithm %q", alg)
+	}
+	if !ok {
+		return errors.New("invalid JWS signature")
+	}
+	return nil
+}
+
+// verifyJWS checks token's signature with key (see verifySignature) and returns its header and payload.
+// The header alg must equal alg, so callers decide which algorithm they accept for a key.
+func verifyJWS(token, alg string, key any) (map[string]any, []byte, error) {
+	header, payload, signingInput, sig, err := parseJWS(token)
+	if err != nil {
+		return nil, nil, err
+	}
+	if h, _ := header["alg"].(string); h != alg {
+		return nil, nil, fmt.Errorf("unexpected JWS algorithm %q", h)
+	}
+	if err := verifySignature(alg, key, signingInput, sig); err != nil {
+		return nil, nil, err
+	}
+	return header, payload, nil
+}
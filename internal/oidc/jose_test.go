@@ -0,0 +1,163 @@
+package oidc
+
+import (
+	"crypto"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"encoding/base64"
+	"math/big"
+	"strings"
+	"testing"
+)
+
+// newTestKey generates a signing key for alg, failing the test on error.
+func newTestKey(t *testing.T, alg string) crypto.Signer {
+	t.Helper()
+	key, err := generateSigningKey(alg)
+	if err != nil {
+		t.Fatalf("generate %s key: %v", alg, err)
+	}
+	return key
+}
+
+// testJWK returns the public JWK of key, failing the test on error.
+func testJWK(t *testing.T, key crypto.Signer) JWK {
+	t.Helper()
+	jwk, err := publicJWK(key.Public())
+	if err != nil {
+		t.Fatalf("public JWK: %v", err)
+	}
+	return jwk
+}
+
+func TestJWKPublicKey(t *testing.T) {
+	b64 := base64.RawURLEncoding.EncodeToString
+	ec := testJWK(t, newTestKey(t, AlgES256))
+	rsaKey := testJWK(t, newTestKey(t, AlgRS256))
+	ed := testJWK(t, newTestKey(t, AlgEdDSA))
+
+	// (1, 1) is not a point of P-256
+	offCurve := JWK{Kty: "EC", Crv: "P-256", X: b64(big.NewInt(1).FillBytes(make([]byte, 32))), Y: b64(big.NewInt(1).FillBytes(make([]byte, 32)))}
+	p384, err := ecdsa.GenerateKey(elliptic.P384(), rand.Reader)
+	if err != nil {
+		t.Fatalf("generate P-384 key: %v", err)
+	}
+	// a 2047-bit modulus: only the bit length is looked at
+	shortN := new(big.Int).Lsh(big.NewInt(1), 2046)
+	shortN.SetBit(shortN, 0, 1)
+
+	tests := []struct {
+		name    string
+		key     JWK
+		wantErr string
+	}{
+		{"EC P-256", ec, ""},
+		{"EC off the curve", offCurve, "not on the curve"},
+		{"EC short coordinate", JWK{Kty: "EC", Crv: "P-256", X: ec.X[:10], Y: ec.Y}, "malformed EC key"},
+		{"EC P-384", JWK{Kty: "EC", Crv: "P-384", X: b64(p384.X.Bytes()), Y: b64(p384.Y.Bytes())}, "unsupported EC curve"},
+		{"RSA 2048", rsaKey, ""},
+		{"RSA under 2048 bits", JWK{Kty: "RSA", N: b64(shortN.Bytes()), E: rsaKey.E}, "at least 2048 bits"},
+		{"RSA without exponent", JWK{Kty: "RSA", N: rsaKey.N}, "malformed RSA key"},
+		{"RSA oversized exponent", JWK{Kty: "RSA", N: rsaKey.N, E: b64([]byte{1, 0, 0, 0, 1})}, "malformed RSA key"},
+		{"Ed25519", ed, ""},
+		{"Ed448", JWK{Kty: "OKP", Crv: "Ed448", X: ed.X}, "only Ed25519"},
+		{"Ed25519 short key", JWK{Kty: "OKP", Crv: "Ed25519", X: b64(make([]byte, 31))}, "only Ed25519"},
+		{"symmetric key", JWK{Kty: "oct"}, "unsupported key type"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			_, err := tt.key.PublicKey()
+			if tt.wantErr == "" {
+				if err != nil {
+					t.Fatalf("PublicKey() error = %v", err)
+				}
+				return
+			}
+			if err == nil || !strings.Contains(err.Error(), tt.wantErr) {
+				t.Fatalf("PublicKey() error = %v, want %q", err, tt.wantErr)
+			}
+		})
+	}
+}
+
+func TestVerifyJWS(t *testing.T) {
+	keys := map[string]crypto.Signer{
+		AlgES256: newTestKey(t, AlgES256),
+		AlgRS256: newTestKey(t, AlgRS256),
+		AlgEdDSA: newTestKey(t, AlgEdDSA),
+	}
+	other := newTestKey(t, AlgES256)
+	payload := []byte(`{"sub":"alice"}`)
+	sign := func(alg string) string {
+		token, err := signJWS(keys[alg], alg, map[string]any{"typ": "JWT"}, payload)
+		if err != nil {
+			t.Fatalf("sign %s: %v", alg, err)
+		}
+		return token
+	}
+	es256 := sign(AlgES256)
+	parts := strings.Split(es256, ".")
+	hs256, err := signHS256([]byte("secret"), map[string]any{}, payload)
+	if err != nil {
+		t.Fatalf("sign HS256: %v", err)
+	}
+	none := base64.RawURLEncoding.EncodeToString([]byte(`{"alg":"none"}`)) + "." + parts[1] + "."
+
+	tests := []struct {
+		name    string
+		token   string
+		alg     string
+		key     any
+		wantErr bool
+	}{
+		{"ES256", es256, AlgES256, keys[AlgES256].Public(), false},
+		{"RS256", sign(AlgRS256), AlgRS256, keys[AlgRS256].Public(), false},
+		{"EdDSA", sign(AlgEdDSA), AlgEdDSA, keys[AlgEdDSA].Public(), false},
+		{"HS256", hs256, AlgHS256, []byte("secret"), false},
+		{"HS256 wrong secret", hs256, AlgHS256, []byte("other"), true},
+		{"wrong key", es256, AlgES256, other.Public(), true},
+		{"key of another type", es256, AlgES256, keys[AlgEdDSA].Public(), true},
+		{"algorithm other than expected", es256, AlgRS256, keys[AlgRS256].Public(), true},
+		{"HS256 token for an asymmetric key", hs256, AlgES256, keys[AlgES256].Public(), true},
+		{"alg none", none, AlgES256, keys[AlgES256].Public(), true},
+		{"tampered payload", parts[0] + "." + base64.RawURLEncoding.EncodeToString([]byte(`{"sub":"mallory"}`)) + "." + parts[2], AlgES256, keys[AlgES256].Public(), true},
+		{"truncated signature", parts[0] + "." + parts[1] + "." + parts[2][:20], AlgES256, keys[AlgES256].Public(), true},
+		{"two parts", parts[0] + "." + parts[1], AlgES256, keys[AlgES256].Public(), true},
+		{"bad base64", parts[0] + ".!!." + parts[2], AlgES256, keys[AlgES256].Public(), true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			_, got, err := verifyJWS(tt.token, tt.alg, tt.key)
+			if tt.wantErr {
+				if err == nil {
+					t.Fatal("verifyJWS() succeeded, want an error")
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("verifyJWS() error = %v", err)
+			}
+			if string(got) != string(payload) {
+				t.Errorf("payload = %s, want %s", got, payload)
+			}
+		})
+	}
+}
+
+func TestJWKThumbprint(t *testing.T) {
+	// RFC 7638 section 3.1
+	key := JWK{
+		Kty: "RSA",
+		E:   "AQAB",
+		N:   "0vx7agoebGcQSuuPiLJXZptN9nndrQmbXEps2aiAFbWhM78LhWx4cbbfAAtVT86zwu1RK7aPFFxuhDR1L6tSoc_BJECPebWKRXjBZCiFV4n3oknjhMstn64tZ_2W-5JsGY4Hc5n9yBXArwl93lqt7_RN5w6Cf0h4QyQ5v-65YGjQR0_FDW2QvzqY368QQMicAtaSqzs8KJZgnYb9c7d0zgdAZHzu6qMQvRL5hajrn1n91CbOpbISD08qNLyrdkt-bFTWhAI4vMQFh6WeZu0fM4lFd2NcRwr3XPksINHaQ-G_xBniIqbw0Ls1jF44-csFCur-kEgU8awapJzKnqDKgw",
+		Kid: "ignored",
+	}
+	got, err := key.Thumbprint()
+	if err != nil {
+		t.Fatalf("Thumbprint() error = %v", err)
+	}
+	if want := "NzbLsXh8uDCcd-6MNwXF4W_7noWXFZAfHkxZsRGC9Xs"; got != want {
+		t.Errorf("Thumbprint() = %s, want %s", got, want)
+	}
+}
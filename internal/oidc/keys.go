@@ -0,0 +1,266 @@
+package oidc
+
+import (
+	"context"
+	"crypto"
+	"crypto/x509"
+	"database/sql"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log/slog"
+	"net/http"
+	"sync"
+	"time"
+
+	"github.com/lescuer97/nostr-oicd/internal/config"
+	"github.com/lescuer97/nostr-oicd/internal/models"
+)
+
+// keyRotationCheckInterval is how often Run re-evaluates the key schedule.
+const keyRotationCheckInterval = 10 * time.Minute
+
+// signingKey is a decoded key from the signing_keys table.
+type signingKey struct {
+	models.SigningKey
+	signer crypto.Signer
+	jwk    JWK
+}
+
+// KeySet holds the provider's signing keys. The newest activated key signs; pending keys
+// are published ahead of activation and retired keys remain published for the grace period.
+type KeySet struct {
+	cfg *config.Config
+	db  *sql.DB
+
+	mu     sync.RWMutex
+	keys   []*signingKey
+	active *signingKey
+}
+
+// NewKeySet loads the keyset from the database, generating and rotating keys as needed.
+func NewKeySet(ctx context.Context, cfg *config.Config, db *sql.DB) (*KeySet, error) {
+	if _, err := generateSigningKey(cfg.SigningAlg); err != nil {
+		return nil, err
+	}
+	ks := &KeySet{cfg: cfg, db: db}
+	if err := ks.Rotate(ctx); err != nil {
+		return nil, err
+	}
+	return ks, nil
+}
+
+// Run periodically applies the rotation schedule until ctx is cancelled.
+func (ks *KeySet) Run(ctx context.Context) {
+	ticker := time.NewTicker(keyRotationCheckInterval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+			if err := ks.Rotate(ctx); err != nil {
+				slog.Error("oidc_key_rotation_failed", "error", err.Error())
+			}
+		}
+	}
+}
+
+// Rotate makes sure there is an active key and a published next key, retires keys
+// that have been superseded and drops retired keys once the grace period is over.
+func (ks *KeySet) Rotate(ctx context.Context) error {
+	now := time.Now()
+	if _, err := models.DeleteSigningKeysRetiredBefore(ctx, ks.db, now.Add(-ks.cfg.KeyGracePeriod)); err != nil {
+		return fmt.Errorf("failed to prune signing keys: %w", err)
+	}
+	stored, err := models.ListSigningKeys(ctx, ks.db)
+	if err != nil {
+		return fmt.Errorf("failed to load signing keys: %w", err)
+	}
+
+	// the current signer is the most recently activated key
+	var current *models.SigningKey
+	hasPending := false
+	for i := range stored {
+		k := &stored[i]
+		if k.ActivatesAt.After(now) {
+			hasPending = true
+			continue
+		}
+		if current == nil || !k.ActivatesAt.Before(current.ActivatesAt) {
+			current = k
+		}
+	}
+	if current == nil {
+		k, err := ks.createKey(ctx, now)
+		if err != nil {
+			return err
+		}
+		current = k
+		slog.Info("oidc_signing_key_created", "kid", k.KID, "alg", k.Alg, "state", "active")
+	}
+	for i := range stored {
+		k := &stored[i]
+		if k.ID != current.ID && k.RetiredAt == nil && !k.ActivatesAt.After(current.ActivatesAt) {
+			if err := models.RetireSigningKey(ctx, ks.db, k.ID, current.ActivatesAt); err != nil {
+				return fmt.Errorf("failed to retire signing key %s: %w", k.KID, err)
+			}
+			slog.Info("oidc_signing_key_retired", "kid", k.KID)
+		}
+	}
+	if !hasPending {
+		// publish the next key ahead of time so relying parties have it cached before it signs
+		activatesAt := current.ActivatesAt.Add(ks.cfg.KeyRotationInterval)
+		if minActivation := now.Add(min(ks.cfg.KeyRotationInterval, 24*time.Hour)); activatesAt.Before(minActivation) {
+			activatesAt = minActivation
+		}
+		k, err := ks.createKey(ctx, activatesAt)
+		if err != nil {
+			return err
+		}
+		slog.Info("oidc_signing_key_created", "kid", k.KID, "alg", k.Alg, "state", "pending", "activates_at", k.ActivatesAt)
+	}
+	return ks.reload(ctx, now)
+}
+
+// createKey generates and stores a new key that starts signing at activatesAt.
+func (ks *KeySet) createKey(ctx context.Context, activatesAt time.Time) (*models.SigningKey, error) {
+	signer, err := generateSigningKey(ks.cfg.SigningAlg)
+	if err != nil {
+		return nil, err
+	}
+	der, err := x509.MarshalPKCS8PrivateKey(signer)
+	if err != nil {
+		return nil, err
+	}
+	jwk, err := publicJWK(signer.Public())
+	if err != nil {
+		return nil, err
+	}
+	kid, err := jwk.Thumbprint()
+	if err != nil {
+		return nil, err
+	}
+	k := &models.SigningKey{KID: kid, Alg: ks.cfg.SigningAlg, PrivateKey: der, ActivatesAt: activatesAt}
+	if k.ID, err = models.CreateSigningKey(ctx, ks.db, k); err != nil {
+		return nil, fmt.Errorf("failed to store signing key: %w", err)
+	}
+	return k, nil
+}
+
+// reload decodes the stored keys into the in-memory cache.
+func (ks *KeySet) reload(ctx context.Context, now time.Time) error {
+	stored, err := models.ListSigningKeys(ctx, ks.db)
+	if err != nil {
+		return fmt.Errorf("failed to load signing keys: %w", err)
+	}
+	var keys []*signingKey
+	var active *signingKey
+	for _, k := range stored {
+		priv, err := x509.ParsePKCS8PrivateKey(k.PrivateKey)
+		if err != nil {
+			return fmt.Errorf("failed to decode signing key %s: %w", k.KID, err)
+		}
+		signer, ok := priv.(crypto.Signer)
+		if !ok {
+			return fmt.Errorf("signing key %s is not a signer", k.KID)
+		}
+		jwk, err := publicJWK(signer.Public())
+		if err != nil {
+			return err
+		}
+		jwk.Kid, jwk.Use, jwk.Alg = k.KID, "sig", k.Alg
+		sk := &signingKey{SigningKey: k, signer: signer, jwk: jwk}
+		keys = append(keys, sk)
+		if !k.ActivatesAt.After(now) && k.RetiredAt == nil {
+			active = sk
+		}
+	}
+	if active == nil {
+		return errors.New("no active signing key")
+	}
+	ks.mu.Lock()
+	ks.keys, ks.active = keys, active
+	ks.mu.Unlock()
+	return nil
+}
+
+// Sign returns claims as a JWT signed by the active key.
+func (ks *KeySet) Sign(claims map[string]any) (string, error) {
+	return ks.signTyped("JWT", claims)
+}
+
+// alg returns the algorithm of the active key, which tokens signed now will use.
+func (ks *KeySet) alg() string {
+	ks.mu.RLock()
+	defer ks.mu.RUnlock()
+	return ks.active.Alg
+}
+
+// signTyped is Sign with an explicit typ header, for tokens that must not be mistaken for ID tokens.
+func (ks *KeySet) signTyped(typ string, claims map[string]any) (string, error) {
+	payload, err := json.Marshal(claims)
+	if err != nil {
+		return "", err
+	}
+	ks.mu.RLock()
+	active := ks.active
+	ks.mu.RUnlock()
+	return signJWS(active.signer, active.Alg, map[string]any{"typ": typ, "kid": active.KID}, payload)
+}
+
+// JWKS returns the public keys currently published (pending, active and retired within grace).
+func (ks *KeySet) JWKS() JWKSet {
+	ks.mu.RLock()
+	defer ks.mu.RUnlock()
+	set := JWKSet{Keys: make([]JWK, 0, len(ks.keys))}
+	for _, k := range ks.keys {
+		set.Keys = append(set.Keys, k.jwk)
+	}
+	return set
+}
+
+// Verify checks a JWT signed by one of the published keys and returns its claims. The issuer
+// must be this provider; expiry is left to the caller since some uses accept expired tokens.
+func (ks *KeySet) Verify(token string) (map[string]any, error) {
+	header, _, _, _, err := parseJWS(token)
+	if err != nil {
+		return nil, err
+	}
+	kid, _ := header["kid"].(string)
+	ks.mu.RLock()
+	var key *signingKey
+	for _, k := range ks.keys {
+		if k.KID == kid {
+			key = k
+			break
+		}
+	}
+	ks.mu.RUnlock()
+	if key == nil {
+		return nil, errors.New("unknown signing key")
+	}
+	_, payload, err := verifyJWS(token, key.Alg, key.signer.Public())
+	if err != nil {
+		return nil, err
+	}
+	var claims map[string]any
+	if err := json.Unmarshal(payload, &claims); err != nil {
+		return nil, errors.New("malformed token claims")
+	}
+	if iss, _ := claims["iss"].(string); iss != ks.cfg.Issuer {
+		return nil, errors.New("token was issued by another provider")
+	}
+	return claims, nil
+}
+
+// JWKSHandler serves the public keyset at the jwks_uri.
+func JWKSHandler(keys *KeySet) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "application/json")
+		w.Header().Set("Cache-Control", "public, max-age=3600")
+		if err := json.NewEncoder(w).Encode(keys.JWKS()); err != nil {
+			http.Error(w, "failed to encode jwks", http.StatusInternalServerError)
+		}
+	}
+}
@@ -0,0 +1,113 @@
+package oidc
+
+import (
+	"database/sql"
+	"log/slog"
+	"net/http"
+	"net/url"
+	"time"
+
+	"github.com/lescuer97/nostr-oicd/internal/config"
+	"github.com/lescuer97/nostr-oicd/internal/models"
+)
+
+const (
+	// requestURIPrefix is the URN namespace of the request_uri values handed out by /par (RFC 9126 section 2.2).
+	requestURIPrefix = "urn:ietf:params:oauth:request_uri:"
+	// pushedRequestTTL is how long the client has to bring the browser to the authorization endpoint.
+	pushedRequestTTL = 90 * time.Second
+	// pushedRequestFlowTTL is how long a pushed request stays usable once the authorization
+	// endpoint resolved it, for the user to log in and consent.
+	pushedRequestFlowTTL = 10 * time.Minute
+)
+
+// parResponse is the pushed authorization response (RFC 9126 section 2.2).
+type parResponse struct {
+	RequestURI string `json:"request_uri"`
+	ExpiresIn  int64  `json:"expires_in"`
+}
+
+// ParHandler implements the pushed authorization request endpoint (RFC 9126). Clients authenticate
+// like at the token endpoint and post the authorization request parameters, which are validated
+// as /authorize would and stored behind a one-time request_uri.
+func ParHandler(cfg *config.Config, db *sql.DB) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		if err := r.ParseForm(); err != nil {
+			writeTokenError(w, &tokenError{http.StatusBadRequest, "invalid_request", "invalid form body"})
+			return
+		}
+		client, terr := authenticateClient(r, cfg, db)
+		if terr != nil {
+			writeTokenError(w, terr)
+			return
+		}
+		if clientID := r.PostForm.Get("client_id"); clientID != "" && clientID != client.ClientID {
+			writeTokenError(w, &tokenError{http.StatusBadRequest, "invalid_request", "client_id does not match the authenticated client"})
+			return
+		}
+		if r.PostForm.Has("request_uri") {
+			writeTokenError(w, &tokenError{http.StatusBadRequest, "invalid_request", "request_uri must not be pushed"})
+			return
+		}
+
+		// the stored request is what /authorize will see, minus the client credentials
+		params := url.Values{}
+		for k, vs := range r.PostForm {
+			if k == "client_secret" {
+				continue
+			}
+			params[k] = vs
+		}
+		params.Set("client_id", client.ClientID)
+
+		// a pushed request object is stored as is and checked again at /authorize; it is used up
+		// here, since the one-time request_uri then stands for it
+		authorizeParams := params
+		if requestObject := params.Get("request"); requestObject != "" {
+			obj, err := verifyRequestObject(r.Context(), cfg, client, requestObject)
+			if err != nil {
+				writeTokenError(w, &tokenError{http.StatusBadRequest, "invalid_request_object", err.Error()})
+				return
+			}
+			if !obj.use(client) {
+				writeTokenError(w, &tokenError{http.StatusBadRequest, "invalid_request_object", "request object was already used"})
+				return
+			}
+			authorizeParams = obj.Params
+		} else if client.RequireSignedRequestObject {
+			writeTokenError(w, &tokenError{http.StatusBadRequest, "invalid_request", "this client must send a signed request object"})
+			return
+		}
+
+		redirectURI := authorizeParams.Get("redirect_uri")
+		if redirectURI == "" || !client.HasRedirectURI(redirectURI) {
+			writeTokenError(w, &tokenError{http.StatusBadRequest, "invalid_request", "redirect_uri is not registered for this client"})
+			return
+		}
+		req := &authorizeRequest{Client: client, RedirectURI: redirectURI}
+		if aerr := validateAuthorizeParams(req, authorizeParams); aerr != nil {
+			writeTokenError(w, &tokenError{http.StatusBadRequest, aerr.Code, aerr.Description})
+			return
+		}
+
+		token, err := generateRandomToken(32)
+		if err != nil {
+			writeTokenError(w, &tokenError{http.StatusInternalServerError, "server_error", "failed to generate request_uri"})
+			return
+		}
+		requestURI := requestURIPrefix + token
+		if _, err := models.CreatePushedRequest(r.Context(), db, &models.PushedRequest{
+			RequestURIHash: hashToken(cfg, requestURI),
+			ClientID:       client.ClientID,
+			Params:         params.Encode(),
+			ExpiresAt:      time.Now().Add(pushedRequestTTL),
+		}); err != nil {
+			slog.Error("oidc_par_store_failed", "client_id", client.ClientID, "error", err.Error())
+			writeTokenError(w, &tokenError{http.StatusInternalServerError, "server_error", "failed to store request"})
+			return
+		}
+
+		slog.Info("oidc_par_request_pushed", "client_id", client.ClientID, "remote", r.RemoteAddr)
+		writeJSON(w, http.StatusCreated, &parResponse{RequestURI: requestURI, ExpiresIn: int64(pushedRequestTTL.Seconds())})
+	}
+}
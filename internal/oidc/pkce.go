@@ -0,0 +1,30 @@
+package oidc
+
+import (
+	"crypto/sha256"
+	"crypto/subtle"
+	"encoding/base64"
+	"regexp"
+)
+
+// PKCE code challenge methods (RFC 7636 section 4.2).
+const (
+	PKCEMethodS256  = "S256"
+	PKCEMethodPlain = "plain"
+)
+
+// pkceValue matches a code_verifier or code_challenge: 43-128 unreserved characters (RFC 7636 section 4.1).
+var pkceValue = regexp.MustCompile(`^[A-Za-z0-9\-._~]{43,128}$`)
+
+// verifyPKCE reports whether verifier matches the challenge stored with the authorization code.
+func verifyPKCE(challenge, method, verifier string) bool {
+	if !pkceValue.MatchString(verifier) {
+		return false
+	}
+	expected := verifier
+	if method == PKCEMethodS256 {
+		sum := sha256.Sum256([]byte(verifier))
+		expected = base64.RawURLEncoding.EncodeToString(sum[:])
+	}
+	return subtle.ConstantTimeCompare([]byte(expected), []byte(challenge)) == 1
+}
@@ -0,0 +1,70 @@
+package oidc
+
+import (
+	"net/http"
+	"strings"
+	"testing"
+)
+
+// RFC 7636 appendix B
+const (
+	testVerifier      = "dBjftJeZ4CVP-mB92K27uhbUJU1p1r_wW1gFWFOEjXk"
+	testS256Challenge = "E9Melhoa2OwvFrEMTJguCHaoeK1t8URWbuGJSstw-cM"
+)
+
+func TestVerifyPKCE(t *testing.T) {
+	tests := []struct {
+		name      string
+		challenge string
+		method    string
+		verifier  string
+		want      bool
+	}{
+		{"S256", testS256Challenge, PKCEMethodS256, testVerifier, true},
+		{"S256 wrong verifier", testS256Challenge, PKCEMethodS256, strings.Repeat("a", 43), false},
+		{"S256 verifier as challenge", testVerifier, PKCEMethodS256, testVerifier, false},
+		{"plain", testVerifier, PKCEMethodPlain, testVerifier, true},
+		{"plain wrong verifier", testVerifier, PKCEMethodPlain, strings.Repeat("a", 43), false},
+		{"plain against S256 challenge", testS256Challenge, PKCEMethodPlain, testVerifier, false},
+		{"verifier too short", strings.Repeat("a", 42), PKCEMethodPlain, strings.Repeat("a", 42), false},
+		{"verifier too long", strings.Repeat("a", 129), PKCEMethodPlain, strings.Repeat("a", 129), false},
+		{"verifier with invalid characters", strings.Repeat("a", 42) + "+", PKCEMethodPlain, strings.Repeat("a", 42) + "+", false},
+		{"empty verifier", testS256Challenge, PKCEMethodS256, "", false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := verifyPKCE(tt.challenge, tt.method, tt.verifier); got != tt.want {
+				t.Errorf("verifyPKCE(%q, %q, %q) = %v, want %v", tt.challenge, tt.method, tt.verifier, got, tt.want)
+			}
+		})
+	}
+}
+
+func TestExchangeAuthorizationCodePKCE(t *testing.T) {
+	p := newTestProvider(t)
+	tests := []struct {
+		name       string
+		challenge  string
+		method     string
+		verifier   string
+		wantStatus int
+	}{
+		{"S256", testS256Challenge, PKCEMethodS256, testVerifier, http.StatusOK},
+		{"plain", testVerifier, PKCEMethodPlain, testVerifier, http.StatusOK},
+		{"missing verifier", testS256Challenge, PKCEMethodS256, "", http.StatusBadRequest},
+		{"wrong verifier", testS256Challenge, PKCEMethodS256, strings.Repeat("a", 43), http.StatusBadRequest},
+		{"verifier without challenge", "", "", testVerifier, http.StatusBadRequest},
+		{"no PKCE", "", "", "", http.StatusOK},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			status, body := p.exchangeCode(t, p.newCode(t, "openid", tt.challenge, tt.method), tt.verifier)
+			if status != tt.wantStatus {
+				t.Fatalf("got %d %v, want %d", status, body, tt.wantStatus)
+			}
+			if status != http.StatusOK && body["error"] != "invalid_grant" {
+				t.Errorf("error = %v, want invalid_grant", body["error"])
+			}
+		})
+	}
+}
@@ -0,0 +1,99 @@
+package oidc
+
+import (
+	"context"
+	"crypto/hmac"
+	"errors"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/lescuer97/nostr-oicd/internal/config"
+	"github.com/lescuer97/nostr-oicd/internal/models"
+	"github.com/lescuer97/nostr-oicd/internal/profile"
+	"github.com/nbd-wtf/go-nostr"
+	"github.com/nbd-wtf/go-nostr/nip05"
+	"github.com/nbd-wtf/go-nostr/nip19"
+)
+
+const (
+	// reauthParam carries the ticket added to /authorize when the user is sent to sign in again.
+	reauthParam = "reauth"
+	// reauthTicketTTL is how long the user has to sign in after being sent to the login page.
+	reauthTicketTTL = 10 * time.Minute
+	// loginHintTimeout bounds the NIP-05 lookup of a login_hint.
+	loginHintTimeout = 5 * time.Second
+)
+
+// reauthTicket returns a ticket recording that a sign-in for clientID was started at now.
+// A session created after that time proves the user signed the challenge again.
+func reauthTicket(cfg *config.Config, clientID string, now time.Time) string {
+	ts := strconv.FormatInt(now.Unix(), 10)
+	return ts + "." + hashToken(cfg, "reauth:"+clientID+":"+ts)[:16]
+}
+
+// reauthStartedAt returns when the sign-in recorded by ticket was started, if the ticket was
+// issued for clientID and has not expired.
+func reauthStartedAt(cfg *config.Config, clientID, ticket string) (time.Time, bool) {
+	ts, mac, ok := strings.Cut(ticket, ".")
+	if !ok {
+		return time.Time{}, false
+	}
+	if !hmac.Equal([]byte(mac), []byte(hashToken(cfg, "reauth:"+clientID+":"+ts)[:16])) {
+		return time.Time{}, false
+	}
+	unix, err := strconv.ParseInt(ts, 10, 64)
+	if err != nil {
+		return time.Time{}, false
+	}
+	started := time.Unix(unix, 0)
+	if time.Since(started) > reauthTicketTTL {
+		return time.Time{}, false
+	}
+	return started, true
+}
+
+// checkReauth reports why sess cannot be used for req without a new signature (OIDC Core
+// section 3.1.2.3), or "" if it can. fresh is true when the user signed in again after being
+// sent to the login page for this request.
+func checkReauth(r *http.Request, cfg *config.Config, req *authorizeRequest, sess *models.Session, user *models.User) (reason string, fresh bool) {
+	started, ok := reauthStartedAt(cfg, req.Client.ClientID, r.Form.Get(reauthParam))
+	fresh = ok && !sess.CreatedAt.Before(started)
+	switch {
+	case hasScope(req.Prompt, "login") && !fresh:
+		return "prompt=login requires signing in again", fresh
+	case req.MaxAge != nil && time.Since(sess.CreatedAt) > *req.MaxAge && !fresh:
+		return "the session is older than max_age", fresh
+	case req.LoginHintPubkey != "" && req.LoginHintPubkey != user.PublicKey:
+		return "the user is signed in with a different key than login_hint", fresh
+	case req.Claims.subject() != "" && req.Claims.subject() != subjectFor(cfg, req.Client, user):
+		return "the user is signed in as a different subject than the claims request names", fresh
+	}
+	return "", fresh
+}
+
+// resolveLoginHint returns the hex public key a login_hint names: an npub, a hex public key,
+// or a NIP-05 address, which is looked up.
+func resolveLoginHint(ctx context.Context, hint string) (string, error) {
+	switch {
+	case strings.HasPrefix(hint, "npub1"):
+		prefix, value, err := nip19.Decode(hint)
+		if err != nil {
+			return "", err
+		}
+		pubkey, ok := value.(string)
+		if prefix != "npub" || !ok {
+			return "", errors.New("login_hint is not an npub")
+		}
+		return pubkey, nil
+	case nostr.IsValidPublicKey(strings.ToLower(hint)):
+		return strings.ToLower(hint), nil
+	case nip05.IsValidIdentifier(hint):
+		ctx, cancel := context.WithTimeout(ctx, loginHintTimeout)
+		defer cancel()
+		// the hint is unauthenticated input: its domain is only contacted on a public address
+		return profile.LookupNIP05(ctx, hint)
+	}
+	return "", errors.New("login_hint is not an npub, hex public key or NIP-05 address")
+}
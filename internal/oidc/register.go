@@ -0,0 +1,471 @@
+package oidc
+
+import (
+	"bytes"
+	"context"
+	"crypto/hmac"
+	"database/sql"
+	"encoding/json"
+	"fmt"
+	"log/slog"
+	"net/http"
+	"net/url"
+	"strings"
+	"time"
+
+	"github.com/go-chi/chi/v5"
+	"github.com/lescuer97/nostr-oicd/internal/config"
+	"github.com/lescuer97/nostr-oicd/internal/models"
+)
+
+// maxRegistrationBody bounds the size of a registration request.
+const maxRegistrationBody = 64 << 10
+
+// clientMetadata is the client metadata exchanged with the registration endpoint (RFC 7591 section 2).
+type clientMetadata struct {
+	RedirectURIs            []string `json:"redirect_uris,omitempty"`
+	PostLogoutRedirectURIs  []string `json:"post_logout_redirect_uris,omitempty"`
+	BackchannelLogoutURI    string   `json:"backchannel_logout_uri,omitempty"`
+	FrontchannelLogoutURI   string   `json:"frontchannel_logout_uri,omitempty"`
+	ClientName              string   `json:"client_name,omitempty"`
+	LogoURI                 string   `json:"logo_uri,omitempty"`
+	Scope                   string   `json:"scope,omitempty"`
+	GrantTypes              []string `json:"grant_types,omitempty"`
+	ResponseTypes           []string `json:"response_types,omitempty"`
+	TokenEndpointAuthMethod string   `json:"token_endpoint_auth_method,omitempty"`
+	SoftwareID              string   `json:"software_id,omitempty"`
+	// JWKS or JWKSURI hold the keys request objects are signed with (RFC 9101).
+	JWKS        json.RawMessage `json:"jwks,omitempty"`
+	JWKSURI     string          `json:"jwks_uri,omitempty"`
+	RequestURIs []string        `json:"request_uris,omitempty"`
+	// RequireSignedRequestObject makes /authorize accept only signed request objects (RFC 9101 section 10.5).
+	RequireSignedRequestObject bool   `json:"require_signed_request_object,omitempty"`
+	SubjectType                string `json:"subject_type,omitempty"`
+	SectorIdentifierURI        string `json:"sector_identifier_uri,omitempty"`
+	// RequirePushedAuthorizationRequests makes /authorize accept only pushed requests (RFC 9126 section 6).
+	RequirePushedAuthorizationRequests bool   `json:"require_pushed_authorization_requests,omitempty"`
+	SoftwareStatement                  string `json:"software_statement,omitempty"`
+}
+
+// registrationUpdate is the body of a client update request (RFC 7592 section 2.2).
+type registrationUpdate struct {
+	ClientID string `json:"client_id"`
+	clientMetadata
+}
+
+// registrationResponse is the client information response (RFC 7591 section 3.2.1, RFC 7592 section 3).
+type registrationResponse struct {
+	ClientID         string `json:"client_id"`
+	ClientSecret     string `json:"client_secret,omitempty"`
+	ClientIDIssuedAt int64  `json:"client_id_issued_at"`
+	// ClientSecretExpiresAt is required whenever a secret is returned; 0 means it does not expire.
+	ClientSecretExpiresAt   *int64 `json:"client_secret_expires_at,omitempty"`
+	RegistrationAccessToken string `json:"registration_access_token,omitempty"`
+	RegistrationClientURI   string `json:"registration_client_uri"`
+	clientMetadata
+}
+
+// registrationLimits caps what a registration may ask for; nil fields allow everything supported.
+type registrationLimits struct {
+	Scopes     []string
+	GrantTypes []string
+}
+
+// writeRegistrationError writes a RFC 7591 section 3.2.2 error response.
+func writeRegistrationError(w http.ResponseWriter, code, description string) {
+	writeJSON(w, http.StatusBadRequest, &tokenError{Code: code, Description: description})
+}
+
+// clientFromMetadata validates m and applies it to c. Omitted values get the RFC 7591 defaults.
+func clientFromMetadata(ctx context.Context, m *clientMetadata, c *models.Client, limits registrationLimits) *tokenError {
+	invalid := func(format string, args ...any) *tokenError {
+		return &tokenError{http.StatusBadRequest, "invalid_client_metadata", fmt.Sprintf(format, args...)}
+	}
+	// the client as saved, for the checks that depend on what changes
+	var prev *models.Client
+	if c.ID != 0 {
+		saved := *c
+		prev = &saved
+	}
+
+	for _, raw := range m.RedirectURIs {
+		if err := ValidateRedirectURI(raw); err != nil {
+			return &tokenError{http.StatusBadRequest, "invalid_redirect_uri", err.Error()}
+		}
+	}
+	c.RedirectURIs = m.RedirectURIs
+	for _, raw := range m.PostLogoutRedirectURIs {
+		if err := ValidatePostLogoutRedirectURI(raw); err != nil {
+			return invalid("%s", err.Error())
+		}
+	}
+	c.PostLogoutRedirectURIs = m.PostLogoutRedirectURIs
+	c.BackchannelLogoutURI = strings.TrimSpace(m.BackchannelLogoutURI)
+	if err := ValidateBackchannelLogoutURI(c.BackchannelLogoutURI); err != nil {
+		return invalid("%s", err.Error())
+	}
+	c.FrontchannelLogoutURI = strings.TrimSpace(m.FrontchannelLogoutURI)
+	if err := ValidateFrontchannelLogoutURI(c.FrontchannelLogoutURI); err != nil {
+		return invalid("%s", err.Error())
+	}
+
+	c.GrantTypes = m.GrantTypes
+	if len(c.GrantTypes) == 0 {
+		c.GrantTypes = []string{"authorization_code"}
+	}
+	for _, g := range c.GrantTypes {
+		if !hasScope(GrantTypesSupported, g) || (limits.GrantTypes != nil && !hasScope(limits.GrantTypes, g)) {
+			return invalid("grant type %q is not allowed", g)
+		}
+	}
+	// Implicit and hybrid response types are only for clients an admin allowed them for; an
+	// update may keep them but registration cannot add them.
+	allowed := c.ResponseTypes
+	c.ResponseTypes = nil
+	for _, rt := range m.ResponseTypes {
+		rt = normalizeResponseType(rt)
+		if !hasScope(ResponseTypesSupported, rt) {
+			return invalid("response type %q is not supported", rt)
+		}
+		if rt != "code" && !hasScope(allowed, rt) {
+			return invalid("response type %q must be enabled by an administrator", rt)
+		}
+		if !hasScope(c.ResponseTypes, rt) {
+			c.ResponseTypes = append(c.ResponseTypes, rt)
+		}
+	}
+	if len(m.ResponseTypes) == 0 && hasScope(c.GrantTypes, "authorization_code") {
+		c.ResponseTypes = []string{"code"}
+	}
+	for _, rt := range c.ResponseTypes {
+		if hasScope(strings.Fields(rt), "code") && !hasScope(c.GrantTypes, "authorization_code") {
+			return invalid("response type %q requires the authorization_code grant type", rt)
+		}
+	}
+	if hasScope(c.GrantTypes, "authorization_code") && len(c.RedirectURIs) == 0 {
+		return &tokenError{http.StatusBadRequest, "invalid_redirect_uri", "the authorization_code grant needs at least one redirect URI"}
+	}
+
+	c.Scopes = strings.Fields(m.Scope)
+	if len(c.Scopes) == 0 {
+		c.Scopes = []string{"openid"}
+	}
+	if !hasScope(c.Scopes, "openid") {
+		return invalid("scope must include openid")
+	}
+	for _, s := range c.Scopes {
+		if !hasScope(ScopesSupported, s) || (limits.Scopes != nil && !hasScope(limits.Scopes, s)) {
+			return invalid("scope %q is not allowed", s)
+		}
+	}
+
+	c.TokenEndpointAuthMethod = m.TokenEndpointAuthMethod
+	if c.TokenEndpointAuthMethod == "" {
+		c.TokenEndpointAuthMethod = "client_secret_basic"
+	}
+	if !hasScope(TokenEndpointAuthMethods, c.TokenEndpointAuthMethod) {
+		return invalid("token endpoint authentication method %q is not supported", c.TokenEndpointAuthMethod)
+	}
+	if c.IsPublic() && hasScope(c.GrantTypes, "client_credentials") {
+		return invalid("the client_credentials grant needs a confidential client")
+	}
+	// self-registered public clients cannot keep a secret, so they must use PKCE
+	c.RequirePKCE = c.IsPublic()
+	c.RequirePAR = m.RequirePushedAuthorizationRequests
+
+	c.JWKS = ""
+	if len(m.JWKS) > 0 && string(m.JWKS) != "null" {
+		var compact bytes.Buffer
+		if err := json.Compact(&compact, m.JWKS); err != nil {
+			return invalid("jwks must be a JSON JWK set")
+		}
+		c.JWKS = compact.String()
+	}
+	c.JWKSURI = strings.TrimSpace(m.JWKSURI)
+	if err := ValidateClientKeys(c.JWKS, c.JWKSURI); err != nil {
+		return invalid("%s", err.Error())
+	}
+	if err := ValidateRequestURIs(m.RequestURIs); err != nil {
+		return invalid("%s", err.Error())
+	}
+	c.RequestURIs = m.RequestURIs
+	c.RequireSignedRequestObject = m.RequireSignedRequestObject
+	if c.RequireSignedRequestObject && c.JWKS == "" && c.JWKSURI == "" {
+		return invalid("require_signed_request_object needs jwks or jwks_uri")
+	}
+
+	c.SubjectType = m.SubjectType
+	c.SectorIdentifierURI = strings.TrimSpace(m.SectorIdentifierURI)
+	if err := ValidateSubjectType(ctx, c, prev); err != nil {
+		return invalid("%s", err.Error())
+	}
+
+	c.LogoURI = strings.TrimSpace(m.LogoURI)
+	if c.LogoURI != "" {
+		if u, err := url.Parse(c.LogoURI); err != nil || (u.Scheme != "https" && u.Scheme != "http") {
+			return invalid("logo_uri must be an http(s) URL")
+		}
+	}
+	c.Name = strings.TrimSpace(m.ClientName)
+	return nil
+}
+
+// clientInformation builds the registration response for c. secret and registrationToken
+// are only included when they were generated by the current request.
+func clientInformation(cfg *config.Config, c *models.Client, secret, registrationToken string) *registrationResponse {
+	resp := &registrationResponse{
+		ClientID:                c.ClientID,
+		ClientSecret:            secret,
+		ClientIDIssuedAt:        c.CreatedAt.Unix(),
+		RegistrationAccessToken: registrationToken,
+		RegistrationClientURI:   cfg.Issuer + RegistrationPath + "/" + url.PathEscape(c.ClientID),
+		clientMetadata: clientMetadata{
+			RedirectURIs:                       c.RedirectURIs,
+			PostLogoutRedirectURIs:             c.PostLogoutRedirectURIs,
+			BackchannelLogoutURI:               c.BackchannelLogoutURI,
+			FrontchannelLogoutURI:              c.FrontchannelLogoutURI,
+			ClientName:                         c.Name,
+			LogoURI:                            c.LogoURI,
+			Scope:                              strings.Join(c.Scopes, " "),
+			GrantTypes:                         c.GrantTypes,
+			TokenEndpointAuthMethod:            c.TokenEndpointAuthMethod,
+			SoftwareID:                         c.SoftwareID,
+			JWKSURI:                            c.JWKSURI,
+			RequestURIs:                        c.RequestURIs,
+			RequireSignedRequestObject:         c.RequireSignedRequestObject,
+			SubjectType:                        c.SubjectType,
+			SectorIdentifierURI:                c.SectorIdentifierURI,
+			RequirePushedAuthorizationRequests: c.RequirePAR,
+		},
+	}
+	if c.JWKS != "" {
+		resp.JWKS = json.RawMessage(c.JWKS)
+	}
+	resp.ResponseTypes = c.ResponseTypes
+	if secret != "" {
+		var never int64
+		resp.ClientSecretExpiresAt = &never
+	}
+	return resp
+}
+
+// newClientSecret sets a fresh secret on confidential clients and clears it on public ones.
+// It returns the plaintext secret, or "" when none was generated.
+func newClientSecret(cfg *config.Config, c *models.Client) (string, error) {
+	if c.IsPublic() {
+		c.SecretHash = ""
+		return "", nil
+	}
+	secret, err := generateRandomToken(32)
+	if err != nil {
+		return "", err
+	}
+	c.SecretHash = hashToken(cfg, secret)
+	return secret, nil
+}
+
+// RegisterHandler implements dynamic client registration (RFC 7591). Callers present either an
+// admin-issued initial access token as a bearer token or an admin-issued software statement.
+func RegisterHandler(cfg *config.Config, db *sql.DB) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		ctx := r.Context()
+		var m clientMetadata
+		if err := json.NewDecoder(http.MaxBytesReader(w, r.Body, maxRegistrationBody)).Decode(&m); err != nil {
+			writeRegistrationError(w, "invalid_client_metadata", "request body must be a JSON object")
+			return
+		}
+
+		var initialTokenID int64
+		if token, ok := bearerToken(r); ok {
+			iat, err := models.GetInitialAccessTokenByHash(ctx, db, hashToken(cfg, token))
+			if err != nil {
+				if err != sql.ErrNoRows {
+					slog.Error("oidc_register_token_lookup_failed", "error", err.Error())
+				}
+				writeBearerError(w, http.StatusUnauthorized, "invalid_token", "initial access token is invalid or expired")
+				return
+			}
+			initialTokenID = iat.ID
+		} else if m.SoftwareStatement == "" {
+			writeBearerError(w, http.StatusUnauthorized, "", "an initial access token or a software statement is required")
+			return
+		}
+
+		if m.SoftwareStatement != "" {
+			st, err := verifySoftwareStatement(cfg, m.SoftwareStatement)
+			if err != nil {
+				slog.Warn("oidc_register_invalid_software_statement", "remote", r.RemoteAddr, "error", err.Error())
+				writeRegistrationError(w, "invalid_software_statement", err.Error())
+				return
+			}
+			// statement values take precedence over the plain JSON ones (RFC 7591 section 3.1.1)
+			m.SoftwareID = st.SoftwareID
+			if st.ClientName != "" {
+				m.ClientName = st.ClientName
+			}
+			if st.Scope != "" {
+				m.Scope = st.Scope
+			}
+			if len(st.GrantTypes) > 0 {
+				m.GrantTypes = st.GrantTypes
+			}
+			if st.TokenEndpointAuthMethod != "" {
+				m.TokenEndpointAuthMethod = st.TokenEndpointAuthMethod
+			}
+		}
+
+		var c models.Client
+		if terr := clientFromMetadata(ctx, &m, &c, registrationLimits{}); terr != nil {
+			writeJSON(w, terr.Status, terr)
+			return
+		}
+		c.SoftwareID = strings.TrimSpace(m.SoftwareID)
+
+		clientID, err := generateRandomToken(16)
+		if err != nil {
+			writeJSON(w, http.StatusInternalServerError, &tokenError{Code: "server_error", Description: "failed to generate client_id"})
+			return
+		}
+		c.ClientID = clientID
+		if c.Name == "" {
+			c.Name = c.SoftwareID
+		}
+		if c.Name == "" {
+			c.Name = c.ClientID
+		}
+		secret, err := newClientSecret(cfg, &c)
+		if err != nil {
+			writeJSON(w, http.StatusInternalServerError, &tokenError{Code: "server_error", Description: "failed to generate client secret"})
+			return
+		}
+		registrationToken, err := generateRandomToken(32)
+		if err != nil {
+			writeJSON(w, http.StatusInternalServerError, &tokenError{Code: "server_error", Description: "failed to generate registration access token"})
+			return
+		}
+		c.RegistrationTokenHash = hashToken(cfg, registrationToken)
+
+		if c.ID, err = models.CreateClient(ctx, db, &c); err != nil {
+			slog.Error("oidc_register_store_failed", "client_id", c.ClientID, "error", err.Error())
+			writeJSON(w, http.StatusInternalServerError, &tokenError{Code: "server_error", Description: "failed to store client"})
+			return
+		}
+		created, err := models.GetClientByID(ctx, db, c.ID)
+		if err != nil {
+			writeJSON(w, http.StatusInternalServerError, &tokenError{Code: "server_error", Description: "failed to load client"})
+			return
+		}
+
+		slog.Info("oidc_client_registered", "client_id", c.ClientID, "software_id", c.SoftwareID, "initial_access_token_id", initialTokenID, "remote", r.RemoteAddr)
+		resp := clientInformation(cfg, created, secret, registrationToken)
+		resp.SoftwareStatement = m.SoftwareStatement
+		writeJSON(w, http.StatusCreated, resp)
+	}
+}
+
+// registeredClient authenticates a client configuration request with the registration access
+// token issued to the client in the URL (RFC 7592 section 2). Clients created by an admin have
+// no registration access token and cannot be managed here.
+func registeredClient(r *http.Request, cfg *config.Config, db *sql.DB) (*models.Client, bool) {
+	token, ok := bearerToken(r)
+	if !ok {
+		return nil, false
+	}
+	c, err := models.GetClientByClientID(r.Context(), db, chi.URLParam(r, "client_id"))
+	if err != nil {
+		if err != sql.ErrNoRows {
+			slog.Error("oidc_register_client_lookup_failed", "error", err.Error())
+		}
+		return nil, false
+	}
+	if c.RegistrationTokenHash == "" || !hmac.Equal([]byte(hashToken(cfg, token)), []byte(c.RegistrationTokenHash)) {
+		return nil, false
+	}
+	return c, true
+}
+
+// ClientConfigurationHandler implements reading, updating and deleting a dynamically
+// registered client at its registration_client_uri (RFC 7592).
+func ClientConfigurationHandler(cfg *config.Config, db *sql.DB) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		ctx := r.Context()
+		c, ok := registeredClient(r, cfg, db)
+		if !ok {
+			// unknown clients get the same answer so client_ids cannot be probed
+			writeBearerError(w, http.StatusUnauthorized, "invalid_token", "registration access token is invalid")
+			return
+		}
+
+		switch r.Method {
+		case http.MethodGet:
+			writeJSON(w, http.StatusOK, clientInformation(cfg, c, "", ""))
+
+		case http.MethodPut:
+			var u registrationUpdate
+			if err := json.NewDecoder(http.MaxBytesReader(w, r.Body, maxRegistrationBody)).Decode(&u); err != nil {
+				writeRegistrationError(w, "invalid_client_metadata", "request body must be a JSON object")
+				return
+			}
+			if u.ClientID != c.ClientID {
+				writeRegistrationError(w, "invalid_client_metadata", "client_id does not match the registration_client_uri")
+				return
+			}
+			wasPublic := c.IsPublic()
+			// an update may narrow but never widen what the client was registered for
+			limits := registrationLimits{Scopes: c.Scopes, GrantTypes: c.GrantTypes}
+			if terr := clientFromMetadata(ctx, &u.clientMetadata, c, limits); terr != nil {
+				writeJSON(w, terr.Status, terr)
+				return
+			}
+			if c.Name == "" {
+				c.Name = c.ClientID
+			}
+			secret := ""
+			if c.IsPublic() != wasPublic {
+				var err error
+				if secret, err = newClientSecret(cfg, c); err != nil {
+					writeJSON(w, http.StatusInternalServerError, &tokenError{Code: "server_error", Description: "failed to generate client secret"})
+					return
+				}
+			}
+			if err := models.UpdateClient(ctx, db, c); err != nil {
+				slog.Error("oidc_register_update_failed", "client_id", c.ClientID, "error", err.Error())
+				writeJSON(w, http.StatusInternalServerError, &tokenError{Code: "server_error", Description: "failed to update client"})
+				return
+			}
+			slog.Info("oidc_client_updated", "client_id", c.ClientID, "software_id", c.SoftwareID, "remote", r.RemoteAddr)
+			writeJSON(w, http.StatusOK, clientInformation(cfg, c, secret, ""))
+
+		case http.MethodDelete:
+			if err := models.DeleteClient(ctx, db, c.ID); err != nil {
+				slog.Error("oidc_register_delete_failed", "client_id", c.ClientID, "error", err.Error())
+				writeJSON(w, http.StatusInternalServerError, &tokenError{Code: "server_error", Description: "failed to delete client"})
+				return
+			}
+			slog.Info("oidc_client_deregistered", "client_id", c.ClientID, "software_id", c.SoftwareID, "remote", r.RemoteAddr)
+			w.WriteHeader(http.StatusNoContent)
+
+		default:
+			w.Header().Set("Allow", "GET, PUT, DELETE")
+			http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+		}
+	}
+}
+
+// NewInitialAccessToken issues a registration credential valid for ttl and returns its plaintext;
+// only the hash is stored. createdBy is the issuing admin's user id, if known.
+func NewInitialAccessToken(ctx context.Context, cfg *config.Config, db *sql.DB, label string, createdBy *int64, ttl time.Duration) (string, error) {
+	token, err := generateRandomToken(32)
+	if err != nil {
+		return "", err
+	}
+	if _, err := models.CreateInitialAccessToken(ctx, db, &models.InitialAccessToken{
+		TokenHash: hashToken(cfg, token),
+		Label:     label,
+		CreatedBy: createdBy,
+		ExpiresAt: time.Now().Add(ttl),
+	}); err != nil {
+		return "", err
+	}
+	return token, nil
+}
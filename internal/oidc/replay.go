@@ -0,0 +1,57 @@
+package oidc
+
+import (
+	"sync"
+	"time"
+)
+
+const (
+	// replaySweepSize is the size at which a replay cache first sweeps out expired entries. After
+	// a sweep, the next one waits until the cache has doubled, so uses stay cheap under load.
+	replaySweepSize = 1024
+	// replaySweepInterval is the least time between sweeps, which bounds the work done when the
+	// cache is full of unexpired entries.
+	replaySweepInterval = time.Second
+)
+
+// replayCache remembers one-time identifiers, such as the jti of a DPoP proof, until they
+// expire, so each is accepted once. It holds at most max entries: when full of unexpired ones
+// it refuses new identifiers rather than grow without bound, since accepting them unrecorded
+// would allow replays.
+type replayCache struct {
+	mu        sync.Mutex
+	seen      map[string]time.Time
+	max       int
+	nextSweep int
+	lastSweep time.Time
+}
+
+// newReplayCache returns an empty cache holding at most limit identifiers.
+func newReplayCache(limit int) *replayCache {
+	return &replayCache{seen: make(map[string]time.Time), max: limit, nextSweep: min(replaySweepSize, limit)}
+}
+
+// use records id as used until expires. It returns false if id was used before, or if the
+// cache is full.
+func (c *replayCache) use(id string, expires time.Time) bool {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	now := time.Now()
+	if exp, ok := c.seen[id]; ok && now.Before(exp) {
+		return false
+	}
+	if len(c.seen) >= c.nextSweep && now.Sub(c.lastSweep) >= replaySweepInterval {
+		for k, exp := range c.seen {
+			if !now.Before(exp) {
+				delete(c.seen, k)
+			}
+		}
+		c.lastSweep = now
+		c.nextSweep = min(max(2*len(c.seen), replaySweepSize), c.max)
+	}
+	if len(c.seen) >= c.max {
+		return false
+	}
+	c.seen[id] = expires
+	return true
+}
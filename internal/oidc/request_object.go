@@ -0,0 +1,253 @@
+package oidc
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/url"
+	"sync"
+	"time"
+
+	"github.com/lescuer97/nostr-oicd/internal/config"
+	"github.com/lescuer97/nostr-oicd/internal/models"
+)
+
+const (
+	// requestObjectType is the JWS typ of request objects (RFC 9101 section 10.8). Objects without
+	// a typ, or with the generic JWT, are accepted too since older clients do not set it.
+	requestObjectType = "oauth-authz-req+jwt"
+	// maxFetchedDocument bounds request objects and JWK sets fetched from client URLs.
+	maxFetchedDocument = 64 << 10
+	// requestObjectMaxLifetime is the furthest in the future a request object's exp may be, so a
+	// captured object cannot be replayed for long.
+	requestObjectMaxLifetime = time.Hour
+	// maxRequestObjectJTIs caps the request object jtis remembered at once.
+	maxRequestObjectJTIs = 100000
+	// jwksCacheTTL is how long keys fetched from a client's jwks_uri are used before fetching again.
+	jwksCacheTTL = 5 * time.Minute
+)
+
+// requestObjectJTIs remembers the jti of every used request object until it expires.
+var requestObjectJTIs = newReplayCache(maxRequestObjectJTIs)
+
+// cachedJWKS is a client's key set as fetched from its jwks_uri.
+type cachedJWKS struct {
+	uri     string
+	keys    []JWK
+	fetched time.Time
+}
+
+var (
+	jwksMu sync.Mutex
+	// jwksCache holds the keys fetched from each client's jwks_uri, by client_id.
+	jwksCache = make(map[string]cachedJWKS)
+)
+
+// verifiedRequestObject holds the authorization parameters of a request object whose signature
+// and claims checked out, with its jti and exp so that it can be used only once.
+type verifiedRequestObject struct {
+	Params  url.Values
+	JTI     string
+	Expires time.Time
+}
+
+// use records the request object of client as used. It returns false if it was used before.
+// Objects without a jti cannot be told apart and are only bounded by their exp.
+func (o *verifiedRequestObject) use(client *models.Client) bool {
+	if o.JTI == "" {
+		return true
+	}
+	return requestObjectJTIs.use(client.ClientID+" "+o.JTI, o.Expires)
+}
+
+// RequestObjectAlgs lists the algorithms accepted for signed request objects. Unsigned ("none")
+// and HMAC objects are rejected, the point is that the browser cannot alter the request.
+var RequestObjectAlgs = []string{AlgES256, AlgRS256, AlgEdDSA}
+
+// ValidateClientKeys checks the jwks and jwks_uri a client registers; at most one may be set
+// (RFC 7591 section 2).
+func ValidateClientKeys(jwks, jwksURI string) error {
+	if jwks != "" && jwksURI != "" {
+		return errors.New("jwks and jwks_uri cannot both be set")
+	}
+	if jwks != "" {
+		_, err := parseJWKSet([]byte(jwks))
+		return err
+	}
+	return validateFetchURL("jwks_uri", jwksURI)
+}
+
+// ValidateRequestURIs checks the URLs a client registers for passing request objects by reference.
+func ValidateRequestURIs(uris []string) error {
+	for _, uri := range uris {
+		if err := validateFetchURL("request_uri", uri); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// parseJWKSet decodes a JWK set and checks that every key is usable.
+func parseJWKSet(data []byte) ([]JWK, error) {
+	var set JWKSet
+	if err := json.Unmarshal(data, &set); err != nil {
+		return nil, errors.New("jwks must be a JSON JWK set")
+	}
+	if len(set.Keys) == 0 {
+		return nil, errors.New("jwks has no keys")
+	}
+	for _, k := range set.Keys {
+		if _, err := k.PublicKey(); err != nil {
+			return nil, fmt.Errorf("jwks key %q: %w", k.Kid, err)
+		}
+	}
+	return set.Keys, nil
+}
+
+// clientKeys returns the keys client registered inline or at its jwks_uri.
+func clientKeys(ctx context.Context, client *models.Client) ([]JWK, error) {
+	switch {
+	case client.JWKS != "":
+		return parseJWKSet([]byte(client.JWKS))
+	case client.JWKSURI != "":
+		jwksMu.Lock()
+		cached, ok := jwksCache[client.ClientID]
+		jwksMu.Unlock()
+		if ok && cached.uri == client.JWKSURI && time.Since(cached.fetched) < jwksCacheTTL {
+			return cached.keys, nil
+		}
+		body, err := fetchDocument(ctx, client.JWKSURI)
+		if err != nil {
+			return nil, fmt.Errorf("fetch jwks_uri: %w", err)
+		}
+		keys, err := parseJWKSet(body)
+		if err != nil {
+			return nil, err
+		}
+		jwksMu.Lock()
+		jwksCache[client.ClientID] = cachedJWKS{uri: client.JWKSURI, keys: keys, fetched: time.Now()}
+		jwksMu.Unlock()
+		return keys, nil
+	default:
+		return nil, errors.New("client has no registered keys")
+	}
+}
+
+// fetchRequestObject loads a request object passed by reference (RFC 9101 section 5.2).
+// uri must be registered for the client, so the provider is never sent to arbitrary URLs.
+func fetchRequestObject(ctx context.Context, client *models.Client, uri string) (string, error) {
+	if !client.HasRequestURI(uri) {
+		return "", errors.New("request_uri is not registered for this client")
+	}
+	body, err := fetchDocument(ctx, uri)
+	if err != nil {
+		return "", fmt.Errorf("fetch request_uri: %w", err)
+	}
+	return string(body), nil
+}
+
+// verifyRequestObject checks a request object signed by client (RFC 9101 section 6) and returns
+// the authorization request parameters it carries. Structured claims, such as claims, are passed
+// on as JSON. The object must expire within requestObjectMaxLifetime; callers use it up with
+// verifiedRequestObject.use once it has served an authorization.
+func verifyRequestObject(ctx context.Context, cfg *config.Config, client *models.Client, token string) (*verifiedRequestObject, error) {
+	header, _, _, _, err := parseJWS(token)
+	if err != nil {
+		return nil, err
+	}
+	alg, _ := header["alg"].(string)
+	if !hasScope(RequestObjectAlgs, alg) {
+		return nil, fmt.Errorf("request object algorithm %q is not accepted", alg)
+	}
+	if typ, _ := header["typ"].(string); typ != "" && typ != requestObjectType && typ != "JWT" {
+		return nil, fmt.Errorf("unexpected request object typ %q", typ)
+	}
+	keys, err := clientKeys(ctx, client)
+	if err != nil {
+		return nil, err
+	}
+	kid, _ := header["kid"].(string)
+	var payload []byte
+	for _, k := range keys {
+		if (kid != "" && k.Kid != kid) || (k.Alg != "" && k.Alg != alg) {
+			continue
+		}
+		pub, err := k.PublicKey()
+		if err != nil {
+			continue
+		}
+		if _, p, err := verifyJWS(token, alg, pub); err == nil {
+			payload = p
+			break
+		}
+	}
+	if payload == nil {
+		return nil, errors.New("request object is not signed by a key of the client")
+	}
+
+	var claims map[string]any
+	if err := json.Unmarshal(payload, &claims); err != nil {
+		return nil, errors.New("malformed request object claims")
+	}
+	if iss, _ := claims["iss"].(string); iss != client.ClientID {
+		return nil, errors.New("request object iss must be the client_id")
+	}
+	if !audienceIncludes(claims["aud"], cfg.Issuer) {
+		return nil, errors.New("request object aud must include the issuer")
+	}
+	if cid, ok := claims["client_id"].(string); ok && cid != client.ClientID {
+		return nil, errors.New("request object client_id does not match")
+	}
+	now := time.Now().Unix()
+	exp, ok := claims["exp"].(float64)
+	if !ok {
+		return nil, errors.New("request object exp is required")
+	}
+	if now >= int64(exp) {
+		return nil, errors.New("request object has expired")
+	}
+	if int64(exp) > now+int64(requestObjectMaxLifetime.Seconds()) {
+		return nil, errors.New("request object exp is too far in the future")
+	}
+	jti, _ := claims["jti"].(string)
+	if nbf, ok := claims["nbf"].(float64); ok && now < int64(nbf) {
+		return nil, errors.New("request object is not valid yet")
+	}
+
+	params := url.Values{}
+	for name, v := range claims {
+		switch name {
+		case "iss", "aud", "exp", "nbf", "iat", "jti":
+			continue
+		case "request", "request_uri":
+			return nil, errors.New("request objects cannot contain request or request_uri")
+		}
+		if s, ok := v.(string); ok {
+			params.Set(name, s)
+			continue
+		}
+		b, err := json.Marshal(v)
+		if err != nil {
+			return nil, err
+		}
+		params.Set(name, string(b))
+	}
+	params.Set("client_id", client.ClientID)
+	return &verifiedRequestObject{Params: params, JTI: jti, Expires: time.Unix(int64(exp), 0)}, nil
+}
+
+// audienceIncludes reports whether the aud claim, a string or an array of strings, contains want.
+func audienceIncludes(aud any, want string) bool {
+	switch a := aud.(type) {
+	case string:
+		return a == want
+	case []any:
+		for _, v := range a {
+			if s, _ := v.(string); s == want {
+				return true
+			}
+		}
+	}
+	return false
+}
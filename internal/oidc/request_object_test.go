@@ -0,0 +1,155 @@
+package oidc
+
+import (
+	"context"
+	"crypto"
+	"encoding/json"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/lescuer97/nostr-oicd/internal/config"
+	"github.com/lescuer97/nostr-oicd/internal/models"
+)
+
+func TestVerifyRequestObject(t *testing.T) {
+	cfg := &config.Config{Issuer: "https://op.test"}
+	key := newTestKey(t, AlgES256)
+	jwk := testJWK(t, key)
+	jwk.Kid = "k1"
+	set, err := json.Marshal(JWKSet{Keys: []JWK{jwk}})
+	if err != nil {
+		t.Fatalf("marshal jwks: %v", err)
+	}
+	client := &models.Client{ClientID: testClientID, JWKS: string(set)}
+	other := newTestKey(t, AlgES256)
+	now := time.Now().Unix()
+
+	// claims returns valid request object claims with changes applied; a nil value removes a claim.
+	claims := func(changes map[string]any) map[string]any {
+		c := map[string]any{
+			"iss":           testClientID,
+			"aud":           cfg.Issuer,
+			"exp":           now + 300,
+			"jti":           "jti-1",
+			"response_type": "code",
+			"scope":         "openid",
+			"claims":        map[string]any{"userinfo": map[string]any{"name": nil}},
+		}
+		for k, v := range changes {
+			if v == nil {
+				delete(c, k)
+			} else {
+				c[k] = v
+			}
+		}
+		return c
+	}
+	sign := func(signer crypto.Signer, alg string, header, claims map[string]any) string {
+		payload, err := json.Marshal(claims)
+		if err != nil {
+			t.Fatalf("marshal claims: %v", err)
+		}
+		token, err := signJWS(signer, alg, header, payload)
+		if err != nil {
+			t.Fatalf("sign request object: %v", err)
+		}
+		return token
+	}
+	header := func(typ string) map[string]any {
+		h := map[string]any{"kid": "k1"}
+		if typ != "" {
+			h["typ"] = typ
+		}
+		return h
+	}
+	hs256, err := signHS256([]byte(testClientSecret), header(requestObjectType), mustJSON(t, claims(nil)))
+	if err != nil {
+		t.Fatalf("sign HS256: %v", err)
+	}
+
+	tests := []struct {
+		name    string
+		token   string
+		wantErr string
+	}{
+		{"valid", sign(key, AlgES256, header(requestObjectType), claims(nil)), ""},
+		{"typ JWT", sign(key, AlgES256, header("JWT"), claims(nil)), ""},
+		{"no typ", sign(key, AlgES256, header(""), claims(nil)), ""},
+		{"aud array", sign(key, AlgES256, header(""), claims(map[string]any{"aud": []string{"https://other.test", cfg.Issuer}})), ""},
+		{"other typ", sign(key, AlgES256, header("at+jwt"), claims(nil)), "unexpected request object typ"},
+		{"HS256", hs256, "is not accepted"},
+		{"signed by another key", sign(other, AlgES256, header(""), claims(nil)), "not signed by a key of the client"},
+		{"unknown kid", sign(key, AlgES256, map[string]any{"kid": "k2"}, claims(nil)), "not signed by a key of the client"},
+		{"other iss", sign(key, AlgES256, header(""), claims(map[string]any{"iss": "other"})), "iss must be the client_id"},
+		{"no iss", sign(key, AlgES256, header(""), claims(map[string]any{"iss": nil})), "iss must be the client_id"},
+		{"other aud", sign(key, AlgES256, header(""), claims(map[string]any{"aud": "https://other.test"})), "aud must include the issuer"},
+		{"other client_id", sign(key, AlgES256, header(""), claims(map[string]any{"client_id": "other"})), "client_id does not match"},
+		{"no exp", sign(key, AlgES256, header(""), claims(map[string]any{"exp": nil})), "exp is required"},
+		{"expired", sign(key, AlgES256, header(""), claims(map[string]any{"exp": now - 1})), "has expired"},
+		{"exp too far ahead", sign(key, AlgES256, header(""), claims(map[string]any{"exp": now + 2*3600})), "too far in the future"},
+		{"not valid yet", sign(key, AlgES256, header(""), claims(map[string]any{"nbf": now + 60})), "not valid yet"},
+		{"nested request_uri", sign(key, AlgES256, header(""), claims(map[string]any{"request_uri": "https://rp.test/req"})), "cannot contain request"},
+		{"malformed", "not.a.jws", "malformed"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			obj, err := verifyRequestObject(context.Background(), cfg, client, tt.token)
+			if tt.wantErr != "" {
+				if err == nil || !strings.Contains(err.Error(), tt.wantErr) {
+					t.Fatalf("verifyRequestObject() error = %v, want %q", err, tt.wantErr)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("verifyRequestObject() error = %v", err)
+			}
+			if got := obj.Params.Get("client_id"); got != testClientID {
+				t.Errorf("client_id = %q, want %q", got, testClientID)
+			}
+			if got := obj.Params.Get("scope"); got != "openid" {
+				t.Errorf("scope = %q, want openid", got)
+			}
+			if got := obj.Params.Get("claims"); got != `{"userinfo":{"name":null}}` {
+				t.Errorf("claims = %q, want them as JSON", got)
+			}
+			for _, name := range []string{"iss", "aud", "exp", "jti"} {
+				if obj.Params.Has(name) {
+					t.Errorf("%s was passed on as an authorization parameter", name)
+				}
+			}
+		})
+	}
+}
+
+func TestVerifiedRequestObjectUse(t *testing.T) {
+	client := &models.Client{ClientID: testClientID}
+	otherClient := &models.Client{ClientID: "other"}
+	expires := time.Now().Add(time.Minute)
+	jti := "jti-" + t.Name()
+
+	obj := &verifiedRequestObject{JTI: jti, Expires: expires}
+	if !obj.use(client) {
+		t.Fatal("first use was rejected")
+	}
+	if obj.use(client) {
+		t.Error("second use was accepted")
+	}
+	if !obj.use(otherClient) {
+		t.Error("the same jti from another client was rejected")
+	}
+	noJTI := &verifiedRequestObject{Expires: expires}
+	if !noJTI.use(client) || !noJTI.use(client) {
+		t.Error("an object without jti was rejected")
+	}
+}
+
+// mustJSON marshals v, failing the test on error.
+func mustJSON(t *testing.T, v any) []byte {
+	t.Helper()
+	b, err := json.Marshal(v)
+	if err != nil {
+		t.Fatalf("marshal: %v", err)
+	}
+	return b
+}
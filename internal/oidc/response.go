@@ -0,0 +1,138 @@
+package oidc
+
+import (
+	"crypto/sha256"
+	"crypto/sha512"
+	"encoding/base64"
+	"log/slog"
+	"net/http"
+	"net/url"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/lescuer97/nostr-oicd/internal/config"
+	"github.com/lescuer97/nostr-oicd/templates/pages"
+)
+
+// Response modes (OAuth 2.0 Multiple Response Type Encoding Practices, Form Post Response Mode,
+// JWT Secured Authorization Response Mode). The .jwt modes send the response parameters as a
+// signed JWT in a single "response" parameter; plain jwt picks the default mode of the response type.
+const (
+	ResponseModeQuery       = "query"
+	ResponseModeFragment    = "fragment"
+	ResponseModeFormPost    = "form_post"
+	ResponseModeJWT         = "jwt"
+	ResponseModeQueryJWT    = "query.jwt"
+	ResponseModeFragmentJWT = "fragment.jwt"
+	ResponseModeFormPostJWT = "form_post.jwt"
+)
+
+// ResponseModes lists the supported response modes.
+var ResponseModes = []string{
+	ResponseModeQuery, ResponseModeFragment, ResponseModeFormPost,
+	ResponseModeJWT, ResponseModeQueryJWT, ResponseModeFragmentJWT, ResponseModeFormPostJWT,
+}
+
+// jarmResponseTTL is how long a JWT secured authorization response is valid. It only has to
+// survive the trip through the browser.
+const jarmResponseTTL = 5 * time.Minute
+
+// ResponseTypesSupported lists the supported response types, normalized. Everything but code is
+// for legacy clients and must be allowed per client.
+var ResponseTypesSupported = []string{"code", "id_token", "id_token token", "code id_token", "code token", "code id_token token"}
+
+// normalizeResponseType sorts the space-separated values of a response_type, whose order does
+// not matter, so "id_token code" and "code id_token" compare equal.
+func normalizeResponseType(responseType string) string {
+	values := strings.Fields(responseType)
+	sort.Strings(values)
+	return strings.Join(values, " ")
+}
+
+// defaultResponseMode returns the response mode for responseType when none is requested:
+// query for code, fragment for anything returning tokens from the authorization endpoint.
+func defaultResponseMode(responseType string) string {
+	if responseType == "code" {
+		return ResponseModeQuery
+	}
+	return ResponseModeFragment
+}
+
+// requestedResponseMode returns how the response to an authorization request with params is
+// sent, falling back to the default of its response type when response_mode is not supported.
+// jwt is resolved to query.jwt or fragment.jwt.
+func requestedResponseMode(params url.Values) string {
+	responseType := normalizeResponseType(params.Get("response_type"))
+	mode := params.Get("response_mode")
+	if mode == ResponseModeJWT {
+		return defaultResponseMode(responseType) + ".jwt"
+	}
+	if hasScope(ResponseModes, mode) {
+		return mode
+	}
+	return defaultResponseMode(responseType)
+}
+
+// signAuthorizeResponse returns params as a JWT secured authorization response for req (JARM
+// section 2.1), signed like ID tokens and addressed to the client.
+func signAuthorizeResponse(cfg *config.Config, keys *KeySet, req *authorizeRequest, params url.Values) (string, error) {
+	now := time.Now()
+	claims := map[string]any{}
+	for k := range params {
+		claims[k] = params.Get(k)
+	}
+	claims["iss"] = cfg.Issuer
+	claims["aud"] = req.Client.ClientID
+	claims["exp"] = now.Add(jarmResponseTTL).Unix()
+	return keys.Sign(claims)
+}
+
+// sendAuthorizeResponse delivers an authorization response (or error) to the client's
+// redirect_uri using the request's response mode.
+func sendAuthorizeResponse(w http.ResponseWriter, r *http.Request, cfg *config.Config, keys *KeySet, req *authorizeRequest, params url.Values) {
+	mode, signed := strings.CutSuffix(req.ResponseMode, ".jwt")
+	if signed {
+		response, err := signAuthorizeResponse(cfg, keys, req, params)
+		if err != nil {
+			slog.Error("oidc_authorize_sign_response_failed", "client_id", req.Client.ClientID, "error", err.Error())
+			http.Error(w, "failed to sign the authorization response", http.StatusInternalServerError)
+			return
+		}
+		params = url.Values{"response": {response}}
+	}
+	switch mode {
+	case ResponseModeFragment:
+		u, err := url.Parse(req.RedirectURI)
+		if err != nil {
+			http.Error(w, "invalid redirect_uri", http.StatusBadRequest)
+			return
+		}
+		u.Fragment = ""
+		http.Redirect(w, r, u.String()+"#"+params.Encode(), http.StatusFound)
+	case ResponseModeFormPost:
+		w.Header().Set("Content-Type", "text/html; charset=utf-8")
+		w.Header().Set("Cache-Control", "no-store")
+		// the page carries the response; it must not leak through the Referer of the post
+		w.Header().Set("Referrer-Policy", "no-referrer")
+		if err := pages.FormPostPage(req.RedirectURI, params).Render(r.Context(), w); err != nil {
+			http.Error(w, "failed to render", http.StatusInternalServerError)
+		}
+	default:
+		redirectWithParams(w, r, req.RedirectURI, params)
+	}
+}
+
+// halfHash returns the c_hash or at_hash of value for an ID token signed with alg: the left
+// half of its hash, base64url encoded (OIDC Core section 3.3.2.11). Ed25519 uses SHA-512.
+func halfHash(alg, value string) string {
+	var sum []byte
+	if alg == AlgEdDSA {
+		s := sha512.Sum512([]byte(value))
+		sum = s[:]
+	} else {
+		s := sha256.Sum256([]byte(value))
+		sum = s[:]
+	}
+	return base64.RawURLEncoding.EncodeToString(sum[:len(sum)/2])
+}
@@ -0,0 +1,73 @@
+package oidc
+
+import (
+	"database/sql"
+	"net/http"
+	"strings"
+
+	"github.com/go-chi/chi/v5"
+	"github.com/go-chi/cors"
+	"github.com/lescuer97/nostr-oicd/internal/config"
+	"github.com/lescuer97/nostr-oicd/internal/middleware"
+)
+
+// RegisterRoutes registers the OpenID Connect provider endpoints on the router.
+// keys is the provider keyset used to sign tokens and served at the jwks_uri.
+func RegisterRoutes(r chi.Router, cfg *config.Config, db *sql.DB, keys *KeySet) {
+	r.Get(DiscoveryPath, DiscoveryHandler(cfg, db))
+	r.Get(JWKSPath, JWKSHandler(keys))
+
+	// Authorization endpoint must accept both GET and POST (OIDC Core section 3.1.2.1)
+	r.Get(AuthorizationPath, AuthorizeHandler(cfg, db, keys))
+	r.Post(AuthorizationPath, AuthorizeHandler(cfg, db, keys))
+	r.Post(ConsentPath, ConsentHandler(cfg, db, keys))
+
+	// RP-initiated logout, GET and POST (RP-Initiated Logout section 2)
+	r.Get(EndSessionPath, EndSessionHandler(cfg, db, keys))
+	r.Post(EndSessionPath, EndSessionHandler(cfg, db, keys))
+
+	// Token, introspection and revocation endpoints: clients authenticate themselves, no browser session involved
+	r.Post(TokenPath, TokenHandler(cfg, db, keys))
+	r.Post(IntrospectionPath, IntrospectHandler(cfg, db))
+	r.Post(RevocationPath, RevokeHandler(cfg, db))
+
+	// UserInfo is protected by the bearer access token, GET and POST (OIDC Core section 5.3.1)
+	r.With(BearerAuth(cfg, db)).Get(UserInfoPath, UserInfoHandler(cfg, db))
+	r.With(BearerAuth(cfg, db)).Post(UserInfoPath, UserInfoHandler(cfg, db))
+
+	// Device authorization grant (RFC 8628): the device asks for codes, the user approves on /device
+	// each endpoint has limiters of its own; user code guesses on POST /device must stay at 20 a minute
+	r.With(middleware.ScopedRateLimitMiddleware(middleware.PerMinute(20), 40)).Post(DeviceAuthorizationPath, DeviceAuthorizationHandler(cfg, db))
+	r.Get(DevicePath, DeviceHandler(cfg, db))
+	r.With(middleware.ScopedRateLimitMiddleware(middleware.PerMinute(20), 40)).Post(DevicePath, DeviceHandler(cfg, db))
+
+	// Pushed authorization requests (RFC 9126): clients store the request before redirecting to /authorize
+	r.With(middleware.ScopedRateLimitMiddleware(middleware.PerMinute(30), 60)).Post(PARPath, ParHandler(cfg, db))
+
+	// Dynamic client registration (RFC 7591) and client configuration (RFC 7592)
+	registerLimiter := middleware.ScopedRateLimitMiddleware(middleware.PerMinute(10), 20)
+	r.With(registerLimiter).Post(RegistrationPath, RegisterHandler(cfg, db))
+	r.With(registerLimiter).HandleFunc(RegistrationPath+"/{client_id}", ClientConfigurationHandler(cfg, db))
+}
+
+// CORS lets browser-based clients call the endpoints meant for them from any origin: discovery
+// and the other /.well-known documents, the JWKS, token and userinfo. Credentials are never
+// allowed, so the session cookie is not sent cross-origin, and the endpoints that act on the
+// browser session (authorize, consent, logout, login) get no CORS headers at all.
+func CORS() func(http.Handler) http.Handler {
+	return cors.Handler(cors.Options{
+		AllowOriginFunc: func(r *http.Request, origin string) bool {
+			switch r.URL.Path {
+			case JWKSPath, TokenPath, UserInfoPath:
+				return true
+			}
+			return strings.HasPrefix(r.URL.Path, "/.well-known/")
+		},
+		AllowedMethods: []string{http.MethodGet, http.MethodPost},
+		AllowedHeaders: []string{"Accept", "Authorization", "Content-Type", "DPoP"},
+		// DPoP and bearer errors are described in WWW-Authenticate
+		ExposedHeaders:   []string{"WWW-Authenticate"},
+		AllowCredentials: false,
+		MaxAge:           300,
+	})
+}
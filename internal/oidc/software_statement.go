@@ -0,0 +1,68 @@
+package oidc
+
+import (
+	"encoding/json"
+	"errors"
+	"time"
+
+	"github.com/lescuer97/nostr-oicd/internal/config"
+)
+
+// softwareStatementType is the JWS typ of statements issued by this provider.
+const softwareStatementType = "software-statement+jwt"
+
+// SoftwareStatement is an admin-issued authorization to register clients (RFC 7591 section 2.3).
+// Its metadata values take precedence over the ones sent in the registration request;
+// empty fields leave the choice to the registering client.
+type SoftwareStatement struct {
+	Issuer                  string   `json:"iss"`
+	IssuedAt                int64    `json:"iat"`
+	ExpiresAt               int64    `json:"exp"`
+	SoftwareID              string   `json:"software_id"`
+	ClientName              string   `json:"client_name,omitempty"`
+	Scope                   string   `json:"scope,omitempty"`
+	GrantTypes              []string `json:"grant_types,omitempty"`
+	TokenEndpointAuthMethod string   `json:"token_endpoint_auth_method,omitempty"`
+}
+
+// IssueSoftwareStatement signs s for ttl. Statements are only ever verified by this provider,
+// so they are HS256 with the server secret and outlive signing key rotation.
+func IssueSoftwareStatement(cfg *config.Config, s SoftwareStatement, ttl time.Duration) (string, error) {
+	if s.SoftwareID == "" {
+		return "", errors.New("software_id is required")
+	}
+	now := time.Now()
+	s.Issuer = cfg.Issuer
+	s.IssuedAt = now.Unix()
+	s.ExpiresAt = now.Add(ttl).Unix()
+	payload, err := json.Marshal(s)
+	if err != nil {
+		return "", err
+	}
+	return signHS256(internalKey(cfg), map[string]any{"typ": softwareStatementType}, payload)
+}
+
+// verifySoftwareStatement checks the signature, issuer and expiry of a statement issued by this provider.
+func verifySoftwareStatement(cfg *config.Config, token string) (*SoftwareStatement, error) {
+	header, payload, err := verifyJWS(token, AlgHS256, internalKey(cfg))
+	if err != nil {
+		return nil, err
+	}
+	if typ, _ := header["typ"].(string); typ != softwareStatementType {
+		return nil, errors.New("not a software statement")
+	}
+	var s SoftwareStatement
+	if err := json.Unmarshal(payload, &s); err != nil {
+		return nil, errors.New("malformed software statement")
+	}
+	if s.Issuer != cfg.Issuer {
+		return nil, errors.New("software statement was issued by another provider")
+	}
+	if time.Now().Unix() >= s.ExpiresAt {
+		return nil, errors.New("software statement has expired")
+	}
+	if s.SoftwareID == "" {
+		return nil, errors.New("software statement has no software_id")
+	}
+	return &s, nil
+}
@@ -0,0 +1,134 @@
+package oidc
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/url"
+
+	"github.com/lescuer97/nostr-oicd/internal/config"
+	"github.com/lescuer97/nostr-oicd/internal/models"
+	"github.com/nbd-wtf/go-nostr/nip19"
+)
+
+// Subject identifier types (OIDC Core section 8).
+const (
+	SubjectPublic   = "public"
+	SubjectPairwise = "pairwise"
+)
+
+// SubjectTypes lists the supported subject identifier types.
+var SubjectTypes = []string{SubjectPublic, SubjectPairwise}
+
+// scopeNpub grants the user's npub as a claim, for clients that need the Nostr identity
+// itself even when their sub is pairwise.
+const scopeNpub = "npub"
+
+// sectorIdentifier returns the host pairwise subjects of client are derived for: the sector
+// fixed when the client was saved, or for clients saved before it was stored, the host its
+// metadata names. Clients without redirect URIs (device or service clients) are their own sector.
+func sectorIdentifier(client *models.Client) string {
+	if client.SectorIdentifier != "" {
+		return client.SectorIdentifier
+	}
+	if host := sectorHost(client); host != "" {
+		return host
+	}
+	return "client:" + client.ClientID
+}
+
+// sectorHost returns the host of the sector_identifier_uri of client, otherwise the host of its
+// redirect URIs, which ValidateSubjectType ensures is unique, or "" if it has neither.
+func sectorHost(client *models.Client) string {
+	if u, err := url.Parse(client.SectorIdentifierURI); err == nil && u.Host != "" {
+		return u.Host
+	}
+	for _, raw := range client.RedirectURIs {
+		if u, err := url.Parse(raw); err == nil && u.Host != "" {
+			return u.Host
+		}
+	}
+	return ""
+}
+
+// subjectFor returns the sub of user as seen by client: the hex pubkey, or for pairwise clients
+// an HMAC of the sector and pubkey under the server secret, so clients in different sectors
+// cannot correlate users (OIDC Core section 8.1).
+func subjectFor(cfg *config.Config, client *models.Client, user *models.User) string {
+	if client.SubjectType != SubjectPairwise {
+		return user.PublicKey
+	}
+	return hashToken(cfg, "pairwise:"+sectorIdentifier(client)+":"+user.PublicKey)
+}
+
+// npubOf returns the bech32 npub of a hex pubkey, or "" if it cannot be encoded.
+func npubOf(pubkey string) string {
+	npub, err := nip19.EncodePublicKey(pubkey)
+	if err != nil {
+		return ""
+	}
+	return npub
+}
+
+// ValidateSubjectType checks the subject_type and sector_identifier_uri of c, fetching the
+// sector identifier document, which must list every redirect URI of the client (OIDC Dynamic
+// Client Registration section 5), and fixes the pairwise sector of c. An empty subject type
+// becomes public. prev is the client as saved before this change, or nil for a new client:
+// a pairwise client may not move to another sector, as that would change every user's sub
+// (OIDC Core section 8.1).
+func ValidateSubjectType(ctx context.Context, c, prev *models.Client) error {
+	if c.SubjectType == "" {
+		c.SubjectType = SubjectPublic
+	}
+	if !hasScope(SubjectTypes, c.SubjectType) {
+		return fmt.Errorf("unsupported subject type %q", c.SubjectType)
+	}
+	if c.SectorIdentifierURI != "" {
+		if err := checkSectorDocument(ctx, c); err != nil {
+			return err
+		}
+	} else if c.SubjectType == SubjectPairwise {
+		hosts := map[string]bool{}
+		for _, raw := range c.RedirectURIs {
+			if u, err := url.Parse(raw); err == nil {
+				hosts[u.Host] = true
+			}
+		}
+		if len(hosts) > 1 {
+			return errors.New("pairwise clients with redirect URIs on several hosts need a sector_identifier_uri")
+		}
+	}
+
+	c.SectorIdentifier = ""
+	if c.SubjectType != SubjectPairwise {
+		return nil
+	}
+	c.SectorIdentifier = sectorHost(c)
+	if prev != nil && prev.SubjectType == SubjectPairwise && sectorIdentifier(prev) != sectorIdentifier(c) {
+		return fmt.Errorf("the client would leave its pairwise sector %q, changing the sub of every user; keep its redirect URIs on that host, or use a sector_identifier_uri on it that lists them", sectorIdentifier(prev))
+	}
+	return nil
+}
+
+// checkSectorDocument fetches the sector_identifier_uri of c and checks that it lists every
+// redirect URI of the client.
+func checkSectorDocument(ctx context.Context, c *models.Client) error {
+	if err := validateFetchURL("sector_identifier_uri", c.SectorIdentifierURI); err != nil {
+		return err
+	}
+	body, err := fetchDocument(ctx, c.SectorIdentifierURI)
+	if err != nil {
+		return fmt.Errorf("fetch sector_identifier_uri: %w", err)
+	}
+	var listed []string
+	if err := json.Unmarshal(body, &listed); err != nil {
+		return errors.New("sector_identifier_uri must serve a JSON array of redirect URIs")
+	}
+	for _, raw := range c.RedirectURIs {
+		if !hasScope(listed, raw) {
+			return fmt.Errorf("redirect URI %q is not listed at the sector_identifier_uri", raw)
+		}
+	}
+	return nil
+}
@@ -0,0 +1,496 @@
+package oidc
+
+import (
+	"context"
+	"crypto/hmac"
+	"database/sql"
+	"encoding/json"
+	"errors"
+	"log/slog"
+	"net/http"
+	"net/url"
+	"strings"
+	"time"
+
+	"github.com/lescuer97/nostr-oicd/internal/config"
+	"github.com/lescuer97/nostr-oicd/internal/models"
+)
+
+const (
+	// accessTokenTTL is the default lifetime of opaque access tokens.
+	accessTokenTTL = time.Hour
+	// idTokenTTL is the default lifetime of signed ID tokens.
+	idTokenTTL = time.Hour
+	// refreshTokenTTL is the default lifetime of a refresh token; rotation issues a fresh one.
+	refreshTokenTTL = 30 * 24 * time.Hour
+)
+
+// userOnlyScopes cannot be granted to a client acting on its own behalf.
+var userOnlyScopes = []string{"openid", "offline_access"}
+
+// accessTokenLifetime returns the client's access token lifetime or the provider default.
+func accessTokenLifetime(c *models.Client) time.Duration {
+	if c.AccessTokenTTL > 0 {
+		return c.AccessTokenTTL
+	}
+	return accessTokenTTL
+}
+
+// idTokenLifetime returns the client's ID token lifetime or the provider default.
+func idTokenLifetime(c *models.Client) time.Duration {
+	if c.IDTokenTTL > 0 {
+		return c.IDTokenTTL
+	}
+	return idTokenTTL
+}
+
+// refreshTokenLifetime returns the client's refresh token lifetime or the provider default.
+func refreshTokenLifetime(c *models.Client) time.Duration {
+	if c.RefreshTokenTTL > 0 {
+		return c.RefreshTokenTTL
+	}
+	return refreshTokenTTL
+}
+
+// tokenError is an OAuth 2.0 error response from the token endpoint (RFC 6749 section 5.2).
+type tokenError struct {
+	Status      int    `json:"-"`
+	Code        string `json:"error"`
+	Description string `json:"error_description,omitempty"`
+}
+
+// tokenResponse is a successful token endpoint response (RFC 6749 section 5.1, OIDC Core 3.1.3.3).
+type tokenResponse struct {
+	AccessToken  string `json:"access_token"`
+	TokenType    string `json:"token_type"`
+	ExpiresIn    int64  `json:"expires_in"`
+	RefreshToken string `json:"refresh_token,omitempty"`
+	IDToken      string `json:"id_token,omitempty"`
+	Scope        string `json:"scope,omitempty"`
+}
+
+// writeJSON writes v as a non-cacheable JSON response.
+func writeJSON(w http.ResponseWriter, status int, v any) {
+	w.Header().Set("Content-Type", "application/json")
+	w.Header().Set("Cache-Control", "no-store")
+	w.Header().Set("Pragma", "no-cache")
+	w.WriteHeader(status)
+	_ = json.NewEncoder(w).Encode(v)
+}
+
+// writeTokenError writes e as JSON. invalid_client answers carry a Basic challenge.
+func writeTokenError(w http.ResponseWriter, e *tokenError) {
+	if e.Status == http.StatusUnauthorized {
+		w.Header().Set("WWW-Authenticate", `Basic realm="token"`)
+	}
+	writeJSON(w, e.Status, e)
+}
+
+// authenticateClient identifies the calling client and verifies its credentials using the
+// method registered for it: client_secret_basic, client_secret_post or none (public clients).
+func authenticateClient(r *http.Request, cfg *config.Config, db *sql.DB) (*models.Client, *tokenError) {
+	invalidClient := &tokenError{http.StatusUnauthorized, "invalid_client", "client authentication failed"}
+
+	method := "none"
+	clientID := r.PostForm.Get("client_id")
+	secret := ""
+	if id, pass, ok := r.BasicAuth(); ok {
+		if r.PostForm.Get("client_secret") != "" {
+			return nil, &tokenError{http.StatusBadRequest, "invalid_request", "multiple client authentication methods used"}
+		}
+		// credentials are form-urlencoded before being placed in the header (RFC 6749 section 2.3.1)
+		var err error
+		if clientID, err = url.QueryUnescape(id); err != nil {
+			return nil, invalidClient
+		}
+		if secret, err = url.QueryUnescape(pass); err != nil {
+			return nil, invalidClient
+		}
+		method = "client_secret_basic"
+	} else if r.PostForm.Get("client_secret") != "" {
+		secret = r.PostForm.Get("client_secret")
+		method = "client_secret_post"
+	}
+	if clientID == "" {
+		return nil, invalidClient
+	}
+
+	client, err := models.GetClientByClientID(r.Context(), db, clientID)
+	if err != nil {
+		if err != sql.ErrNoRows {
+			slog.Error("oidc_token_client_lookup_failed", "client_id", clientID, "error", err.Error())
+		}
+		return nil, invalidClient
+	}
+	if client.TokenEndpointAuthMethod != method {
+		return nil, invalidClient
+	}
+	if method != "none" {
+		if client.SecretHash == "" || !hmac.Equal([]byte(hashToken(cfg, secret)), []byte(client.SecretHash)) {
+			return nil, invalidClient
+		}
+	}
+	return client, nil
+}
+
+// TokenHandler implements the token endpoint.
+func TokenHandler(cfg *config.Config, db *sql.DB, keys *KeySet) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		if err := r.ParseForm(); err != nil {
+			writeTokenError(w, &tokenError{http.StatusBadRequest, "invalid_request", "invalid form body"})
+			return
+		}
+		client, terr := authenticateClient(r, cfg, db)
+		if terr != nil {
+			writeTokenError(w, terr)
+			return
+		}
+
+		// a DPoP proof binds the issued tokens to its key (RFC 9449 section 5)
+		if r.Header.Get("DPoP") != "" {
+			jkt, err := verifyDPoPProof(r, cfg, "")
+			if err != nil {
+				writeTokenError(w, &tokenError{http.StatusBadRequest, "invalid_dpop_proof", err.Error()})
+				return
+			}
+			r = r.WithContext(context.WithValue(r.Context(), contextDPoPKey, jkt))
+		}
+
+		grantType := r.PostForm.Get("grant_type")
+		if grantType != "" && !client.AllowsGrantType(grantType) {
+			writeTokenError(w, &tokenError{http.StatusBadRequest, "unauthorized_client", "client is not allowed to use this grant_type"})
+			return
+		}
+		switch grantType {
+		case "authorization_code":
+			resp, terr := exchangeAuthorizationCode(r, cfg, db, keys, client)
+			if terr != nil {
+				writeTokenError(w, terr)
+				return
+			}
+			writeJSON(w, http.StatusOK, resp)
+		case "refresh_token":
+			resp, terr := exchangeRefreshToken(r, cfg, db, keys, client)
+			if terr != nil {
+				writeTokenError(w, terr)
+				return
+			}
+			writeJSON(w, http.StatusOK, resp)
+		case "client_credentials":
+			resp, terr := exchangeClientCredentials(r, cfg, db, client)
+			if terr != nil {
+				writeTokenError(w, terr)
+				return
+			}
+			writeJSON(w, http.StatusOK, resp)
+		case GrantTypeDeviceCode:
+			resp, terr := exchangeDeviceCode(r, cfg, db, keys, client)
+			if terr != nil {
+				writeTokenError(w, terr)
+				return
+			}
+			writeJSON(w, http.StatusOK, resp)
+		case "":
+			writeTokenError(w, &tokenError{http.StatusBadRequest, "invalid_request", "grant_type is required"})
+		default:
+			writeTokenError(w, &tokenError{http.StatusBadRequest, "unsupported_grant_type", "grant_type is not supported"})
+		}
+	}
+}
+
+// exchangeAuthorizationCode redeems an authorization code exactly once and issues tokens.
+func exchangeAuthorizationCode(r *http.Request, cfg *config.Config, db *sql.DB, keys *KeySet, client *models.Client) (*tokenResponse, *tokenError) {
+	ctx := r.Context()
+	invalidGrant := &tokenError{http.StatusBadRequest, "invalid_grant", "authorization code is invalid, expired or already used"}
+
+	code := r.PostForm.Get("code")
+	if code == "" {
+		return nil, &tokenError{http.StatusBadRequest, "invalid_request", "code is required"}
+	}
+	ac, err := models.ConsumeAuthorizationCode(ctx, db, hashToken(cfg, code))
+	if err != nil {
+		if errors.Is(err, models.ErrCodeAlreadyUsed) {
+			// A replayed code may have been stolen: revoke what was issued from it (RFC 6749 section 4.1.2).
+			n, _ := models.DeactivateAccessTokensByCode(ctx, db, ac.ID)
+			rn, _ := models.RevokeRefreshTokenFamiliesByCode(ctx, db, ac.ID)
+			slog.Warn("oidc_token_code_replay", "client_id", client.ClientID, "code_id", ac.ID, "revoked_tokens", n, "revoked_refresh_tokens", rn, "remote", r.RemoteAddr)
+		} else if err != sql.ErrNoRows {
+			slog.Error("oidc_token_code_lookup_failed", "client_id", client.ClientID, "error", err.Error())
+			return nil, &tokenError{http.StatusInternalServerError, "server_error", "failed to redeem code"}
+		}
+		return nil, invalidGrant
+	}
+	if ac.ClientID != client.ClientID || ac.RedirectURI != r.PostForm.Get("redirect_uri") {
+		return nil, invalidGrant
+	}
+	verifier := r.PostForm.Get("code_verifier")
+	if ac.CodeChallenge != "" {
+		if verifier == "" || !verifyPKCE(ac.CodeChallenge, ac.CodeChallengeMethod, verifier) {
+			return nil, &tokenError{http.StatusBadRequest, "invalid_grant", "code_verifier does not match code_challenge"}
+		}
+	} else if verifier != "" {
+		// a verifier for a code issued without PKCE points at a downgrade or mix-up attempt
+		return nil, &tokenError{http.StatusBadRequest, "invalid_grant", "code was not issued with a code_challenge"}
+	}
+
+	user, err := models.GetUserByID(ctx, db, ac.UserID)
+	if err != nil {
+		return nil, invalidGrant
+	}
+
+	// refresh tokens are only issued for offline access (OIDC Core section 11)
+	familyID := ""
+	if hasScope(strings.Fields(ac.Scope), "offline_access") && client.AllowsGrantType("refresh_token") {
+		if familyID, err = generateRandomToken(16); err != nil {
+			return nil, &tokenError{http.StatusInternalServerError, "server_error", "failed to generate token"}
+		}
+	}
+
+	now := time.Now()
+	resp, terr := issueAccessToken(ctx, cfg, db, client, &models.AccessToken{
+		UserID:              &user.ID,
+		SessionID:           &ac.SessionID,
+		AuthorizationCodeID: &ac.ID,
+		RefreshFamilyID:     familyID,
+		Scope:               ac.Scope,
+		Claims:              ac.Claims,
+	})
+	if terr != nil {
+		return nil, terr
+	}
+	if familyID != "" {
+		if resp.RefreshToken, terr = issueRefreshToken(ctx, cfg, db, client, &models.RefreshToken{
+			FamilyID:            familyID,
+			UserID:              user.ID,
+			AuthorizationCodeID: &ac.ID,
+			Scope:               ac.Scope,
+			AuthTime:            ac.AuthTime,
+			Claims:              ac.Claims,
+		}); terr != nil {
+			return nil, terr
+		}
+	}
+	extra := idTokenUserClaims(ctx, cfg, db, client, user, strings.Fields(ac.Scope), storedClaimsRequest(ac.Claims), true)
+	if resp.IDToken, terr = signIDToken(cfg, keys, client, user, ac.Nonce, sessionSID(cfg, ac.SessionID), ac.AuthTime, now, extra); terr != nil {
+		return nil, terr
+	}
+
+	if err := models.TouchConsent(ctx, db, user.ID, client.ClientID); err != nil {
+		slog.Warn("oidc_token_touch_consent_failed", "client_id", client.ClientID, "user_id", user.ID, "error", err.Error())
+	}
+	// the client now holds tokens from this session and is told when it ends
+	if err := models.AddSessionClient(ctx, db, ac.SessionID, client.ClientID); err != nil {
+		slog.Warn("oidc_token_session_client_failed", "client_id", client.ClientID, "session_id", ac.SessionID, "error", err.Error())
+	}
+	slog.Info("oidc_token_issued", "client_id", client.ClientID, "user_id", user.ID, "grant_type", "authorization_code", "refresh_token", familyID != "")
+	return resp, nil
+}
+
+// exchangeRefreshToken rotates a refresh token (RFC 6749 section 6). Presenting a token that was
+// already rotated means it leaked, so its whole family is revoked (RFC 9700 section 4.14.2).
+func exchangeRefreshToken(r *http.Request, cfg *config.Config, db *sql.DB, keys *KeySet, client *models.Client) (*tokenResponse, *tokenError) {
+	ctx := r.Context()
+	invalidGrant := &tokenError{http.StatusBadRequest, "invalid_grant", "refresh token is invalid, expired or revoked"}
+
+	token := r.PostForm.Get("refresh_token")
+	if token == "" {
+		return nil, &tokenError{http.StatusBadRequest, "invalid_request", "refresh_token is required"}
+	}
+	tokenHash := hashToken(cfg, token)
+
+	// Check the request against the stored grant before consuming the token, so a client
+	// error does not burn the token and make the client's retry look like reuse.
+	rt, err := models.GetRefreshTokenByHash(ctx, db, tokenHash)
+	if err != nil {
+		if err != sql.ErrNoRows {
+			slog.Error("oidc_token_refresh_lookup_failed", "client_id", client.ClientID, "error", err.Error())
+			return nil, &tokenError{http.StatusInternalServerError, "server_error", "failed to redeem refresh token"}
+		}
+		return nil, invalidGrant
+	}
+	if rt.ClientID != client.ClientID {
+		return nil, invalidGrant
+	}
+	if rt.DPoPJKT != "" && rt.DPoPJKT != dpopThumbprint(ctx) {
+		return nil, &tokenError{http.StatusBadRequest, "invalid_grant", "refresh token is bound to another DPoP key"}
+	}
+	// the client may narrow, but not widen, the scope of the new access token
+	scope := rt.Scope
+	if requested := strings.Fields(r.PostForm.Get("scope")); len(requested) > 0 {
+		granted := strings.Fields(rt.Scope)
+		for _, s := range requested {
+			if !hasScope(granted, s) {
+				return nil, &tokenError{http.StatusBadRequest, "invalid_scope", "requested scope exceeds the original grant"}
+			}
+		}
+		scope = strings.Join(requested, " ")
+	}
+
+	rt, err = models.ConsumeRefreshToken(ctx, db, tokenHash)
+	if err != nil {
+		if errors.Is(err, models.ErrRefreshTokenReused) {
+			n, _ := models.RevokeRefreshTokenFamily(ctx, db, rt.FamilyID)
+			slog.Warn("oidc_token_refresh_reuse", "client_id", client.ClientID, "family_id", rt.FamilyID, "revoked_refresh_tokens", n, "remote", r.RemoteAddr)
+		} else if err != sql.ErrNoRows {
+			slog.Error("oidc_token_refresh_lookup_failed", "client_id", client.ClientID, "error", err.Error())
+			return nil, &tokenError{http.StatusInternalServerError, "server_error", "failed to redeem refresh token"}
+		}
+		return nil, invalidGrant
+	}
+
+	user, err := models.GetUserByID(ctx, db, rt.UserID)
+	if err != nil {
+		return nil, invalidGrant
+	}
+
+	now := time.Now()
+	resp, terr := issueAccessToken(ctx, cfg, db, client, &models.AccessToken{
+		UserID:          &user.ID,
+		RefreshFamilyID: rt.FamilyID,
+		Scope:           scope,
+		Claims:          rt.Claims,
+	})
+	if terr != nil {
+		return nil, terr
+	}
+	if resp.RefreshToken, terr = issueRefreshToken(ctx, cfg, db, client, &models.RefreshToken{
+		FamilyID:            rt.FamilyID,
+		ParentID:            &rt.ID,
+		UserID:              user.ID,
+		AuthorizationCodeID: rt.AuthorizationCodeID,
+		Scope:               rt.Scope,
+		AuthTime:            rt.AuthTime,
+		Claims:              rt.Claims,
+	}); terr != nil {
+		return nil, terr
+	}
+	if hasScope(strings.Fields(scope), "openid") {
+		// no nonce on refresh (OIDC Core section 12.2)
+		extra := idTokenUserClaims(ctx, cfg, db, client, user, strings.Fields(scope), storedClaimsRequest(rt.Claims), true)
+		if resp.IDToken, terr = signIDToken(cfg, keys, client, user, "", "", rt.AuthTime, now, extra); terr != nil {
+			return nil, terr
+		}
+	}
+
+	if err := models.TouchConsent(ctx, db, user.ID, client.ClientID); err != nil {
+		slog.Warn("oidc_token_touch_consent_failed", "client_id", client.ClientID, "user_id", user.ID, "error", err.Error())
+	}
+	slog.Info("oidc_token_issued", "client_id", client.ClientID, "user_id", user.ID, "grant_type", "refresh_token", "family_id", rt.FamilyID)
+	return resp, nil
+}
+
+// exchangeClientCredentials issues an access token to a confidential client acting on its own
+// behalf (RFC 6749 section 4.4). There is no user, so no ID token and no refresh token; the
+// token's subject is the client and its scope is limited to the client's registered scopes.
+func exchangeClientCredentials(r *http.Request, cfg *config.Config, db *sql.DB, client *models.Client) (*tokenResponse, *tokenError) {
+	if client.IsPublic() {
+		return nil, &tokenError{http.StatusBadRequest, "unauthorized_client", "public clients cannot use the client_credentials grant"}
+	}
+	scopes := strings.Fields(r.PostForm.Get("scope"))
+	if len(scopes) == 0 {
+		// default to everything the client may ask for without a user
+		for _, s := range client.Scopes {
+			if !hasScope(userOnlyScopes, s) {
+				scopes = append(scopes, s)
+			}
+		}
+	}
+	for _, s := range scopes {
+		if hasScope(userOnlyScopes, s) {
+			return nil, &tokenError{http.StatusBadRequest, "invalid_scope", "scope " + s + " needs an end-user"}
+		}
+	}
+	if !client.AllowsScopes(scopes) {
+		return nil, &tokenError{http.StatusBadRequest, "invalid_scope", "requested scope is not allowed for this client"}
+	}
+
+	scope := strings.Join(scopes, " ")
+	resp, terr := issueAccessToken(r.Context(), cfg, db, client, &models.AccessToken{Scope: scope})
+	if terr != nil {
+		return nil, terr
+	}
+	slog.Info("oidc_token_issued", "client_id", client.ClientID, "grant_type", "client_credentials", "scope", scope, "remote", r.RemoteAddr)
+	return resp, nil
+}
+
+// issueAccessToken generates and stores the access token described by t and returns the
+// token response carrying it.
+func issueAccessToken(ctx context.Context, cfg *config.Config, db *sql.DB, client *models.Client, t *models.AccessToken) (*tokenResponse, *tokenError) {
+	accessToken, err := generateRandomToken(32)
+	if err != nil {
+		return nil, &tokenError{http.StatusInternalServerError, "server_error", "failed to generate token"}
+	}
+	t.TokenHash = hashToken(cfg, accessToken)
+	t.ClientID = client.ClientID
+	t.DPoPJKT = dpopThumbprint(ctx)
+	t.ExpiresAt = time.Now().Add(accessTokenLifetime(client))
+	if _, err := models.CreateAccessToken(ctx, db, t); err != nil {
+		slog.Error("oidc_token_store_failed", "client_id", client.ClientID, "error", err.Error())
+		return nil, &tokenError{http.StatusInternalServerError, "server_error", "failed to store token"}
+	}
+	tokenType := "Bearer"
+	if t.DPoPJKT != "" {
+		tokenType = "DPoP"
+	}
+	return &tokenResponse{
+		AccessToken: accessToken,
+		TokenType:   tokenType,
+		ExpiresIn:   int64(accessTokenLifetime(client).Seconds()),
+		Scope:       t.Scope,
+	}, nil
+}
+
+// issueRefreshToken generates and stores the refresh token described by t and returns its plaintext.
+func issueRefreshToken(ctx context.Context, cfg *config.Config, db *sql.DB, client *models.Client, t *models.RefreshToken) (string, *tokenError) {
+	refreshToken, err := generateRandomToken(32)
+	if err != nil {
+		return "", &tokenError{http.StatusInternalServerError, "server_error", "failed to generate token"}
+	}
+	t.TokenHash = hashToken(cfg, refreshToken)
+	t.ClientID = client.ClientID
+	if client.IsPublic() {
+		// confidential clients authenticate at refresh, public ones prove the key instead
+		t.DPoPJKT = dpopThumbprint(ctx)
+	}
+	t.ExpiresAt = time.Now().Add(refreshTokenLifetime(client))
+	if _, err := models.CreateRefreshToken(ctx, db, t); err != nil {
+		slog.Error("oidc_token_store_refresh_failed", "client_id", client.ClientID, "error", err.Error())
+		return "", &tokenError{http.StatusInternalServerError, "server_error", "failed to store refresh token"}
+	}
+	return refreshToken, nil
+}
+
+// signIDToken signs an ID token for user issued at now; see idTokenClaims.
+func signIDToken(cfg *config.Config, keys *KeySet, client *models.Client, user *models.User, nonce, sid string, authTime, now time.Time, extra map[string]any) (string, *tokenError) {
+	idToken, err := keys.Sign(idTokenClaims(cfg, client, user, nonce, sid, authTime, now, extra))
+	if err != nil {
+		return "", &tokenError{http.StatusInternalServerError, "server_error", "failed to sign id_token"}
+	}
+	return idToken, nil
+}
+
+// idTokenClaims returns the claims of an ID token for user issued at now.
+// sid is the session the token was issued in, empty when there is none, and authTime is when
+// the user signed in to it; auth_time is left out when it is not known. extra holds further
+// claims, such as c_hash, at_hash and user claims; the standard claims take precedence.
+func idTokenClaims(cfg *config.Config, client *models.Client, user *models.User, nonce, sid string, authTime, now time.Time, extra map[string]any) map[string]any {
+	claims := map[string]any{}
+	for k, v := range extra {
+		claims[k] = v
+	}
+	claims["iss"] = cfg.Issuer
+	claims["sub"] = subjectFor(cfg, client, user)
+	claims["aud"] = client.ClientID
+	claims["iat"] = now.Unix()
+	claims["exp"] = now.Add(idTokenLifetime(client)).Unix()
+	if nonce != "" {
+		claims["nonce"] = nonce
+	}
+	if sid != "" {
+		claims["sid"] = sid
+	}
+	if authTime.Unix() > 0 {
+		claims["auth_time"] = authTime.Unix()
+	}
+	return claims
+}
@@ -0,0 +1,193 @@
+package oidc
+
+import (
+	"net/http"
+	"net/url"
+	"testing"
+)
+
+func TestExchangeAuthorizationCodeRejects(t *testing.T) {
+	p := newTestProvider(t)
+	tests := []struct {
+		name     string
+		form     func(code string) url.Values
+		wantCode string
+	}{
+		{
+			name: "missing code",
+			form: func(string) url.Values {
+				return url.Values{"grant_type": {"authorization_code"}, "redirect_uri": {testRedirectURI}}
+			},
+			wantCode: "invalid_request",
+		},
+		{
+			name: "unknown code",
+			form: func(string) url.Values {
+				return url.Values{"grant_type": {"authorization_code"}, "code": {"unknown"}, "redirect_uri": {testRedirectURI}}
+			},
+			wantCode: "invalid_grant",
+		},
+		{
+			name: "other redirect_uri",
+			form: func(code string) url.Values {
+				return url.Values{"grant_type": {"authorization_code"}, "code": {code}, "redirect_uri": {"https://rp.test/other"}}
+			},
+			wantCode: "invalid_grant",
+		},
+		{
+			name:     "missing grant_type",
+			form:     func(code string) url.Values { return url.Values{"code": {code}, "redirect_uri": {testRedirectURI}} },
+			wantCode: "invalid_request",
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			status, body := p.token(t, tt.form(p.newCode(t, "openid", "", "")))
+			if status != http.StatusBadRequest || body["error"] != tt.wantCode {
+				t.Errorf("got %d %v, want 400 %s", status, body["error"], tt.wantCode)
+			}
+		})
+	}
+}
+
+func TestAuthorizationCodeReplayRevokesTokens(t *testing.T) {
+	p := newTestProvider(t)
+	tests := []struct {
+		name    string
+		scope   string
+		refresh bool
+	}{
+		{"access token only", "openid", false},
+		{"with refresh token", "openid offline_access", true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			code := p.newCode(t, tt.scope, "", "")
+			status, first := p.exchangeCode(t, code, "")
+			if status != http.StatusOK {
+				t.Fatalf("first exchange: got %d %v", status, first)
+			}
+			accessToken, _ := first["access_token"].(string)
+			refreshToken, _ := first["refresh_token"].(string)
+			if (refreshToken != "") != tt.refresh {
+				t.Fatalf("refresh token issued = %v, want %v", refreshToken != "", tt.refresh)
+			}
+			if _, ok := first["id_token"].(string); !ok {
+				t.Errorf("no id_token in %v", first)
+			}
+
+			status, second := p.exchangeCode(t, code, "")
+			if status != http.StatusBadRequest || second["error"] != "invalid_grant" {
+				t.Fatalf("replay: got %d %v, want 400 invalid_grant", status, second)
+			}
+			if p.accessTokenActive(t, accessToken) {
+				t.Error("access token from the replayed code is still active")
+			}
+			if tt.refresh && p.refreshTokenActive(t, refreshToken) {
+				t.Error("refresh token from the replayed code is still active")
+			}
+		})
+	}
+}
+
+// refresh redeems a refresh token at the token endpoint.
+func (p *testProvider) refresh(t *testing.T, token, scope string) (int, map[string]any) {
+	t.Helper()
+	form := url.Values{"grant_type": {"refresh_token"}, "refresh_token": {token}}
+	if scope != "" {
+		form.Set("scope", scope)
+	}
+	return p.token(t, form)
+}
+
+// startRefreshFamily redeems a fresh offline_access code and returns its access and refresh token.
+func (p *testProvider) startRefreshFamily(t *testing.T) (string, string) {
+	t.Helper()
+	status, body := p.exchangeCode(t, p.newCode(t, "openid offline_access", "", ""), "")
+	if status != http.StatusOK {
+		t.Fatalf("exchange code: got %d %v", status, body)
+	}
+	accessToken, _ := body["access_token"].(string)
+	refreshToken, _ := body["refresh_token"].(string)
+	if accessToken == "" || refreshToken == "" {
+		t.Fatalf("missing tokens in %v", body)
+	}
+	return accessToken, refreshToken
+}
+
+func TestRefreshTokenRotation(t *testing.T) {
+	p := newTestProvider(t)
+	tests := []struct {
+		name       string
+		scope      string
+		wantStatus int
+		wantError  string
+	}{
+		{"same scope", "", http.StatusOK, ""},
+		{"narrower scope", "openid", http.StatusOK, ""},
+		{"wider scope", "openid profile offline_access", http.StatusBadRequest, "invalid_scope"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			_, refreshToken := p.startRefreshFamily(t)
+			status, body := p.refresh(t, refreshToken, tt.scope)
+			if status != tt.wantStatus || (tt.wantError != "" && body["error"] != tt.wantError) {
+				t.Fatalf("got %d %v, want %d %s", status, body, tt.wantStatus, tt.wantError)
+			}
+			if status != http.StatusOK {
+				// a rejected request must not burn the token
+				if status, body := p.refresh(t, refreshToken, ""); status != http.StatusOK {
+					t.Fatalf("retry after rejected request: got %d %v", status, body)
+				}
+				return
+			}
+			if rotated, _ := body["refresh_token"].(string); rotated == "" || rotated == refreshToken {
+				t.Errorf("refresh token was not rotated: %v", body)
+			}
+		})
+	}
+}
+
+func TestRefreshTokenReuseRevokesFamily(t *testing.T) {
+	p := newTestProvider(t)
+	tests := []struct {
+		name string
+		// rotations before the first token is presented again
+		rotations int
+	}{
+		{"reuse after one rotation", 1},
+		{"reuse after several rotations", 3},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			firstAccess, first := p.startRefreshFamily(t)
+			accessTokens := []string{firstAccess}
+			current := first
+			for i := 0; i < tt.rotations; i++ {
+				status, body := p.refresh(t, current, "")
+				if status != http.StatusOK {
+					t.Fatalf("rotation %d: got %d %v", i, status, body)
+				}
+				current, _ = body["refresh_token"].(string)
+				accessToken, _ := body["access_token"].(string)
+				accessTokens = append(accessTokens, accessToken)
+			}
+
+			status, body := p.refresh(t, first, "")
+			if status != http.StatusBadRequest || body["error"] != "invalid_grant" {
+				t.Fatalf("reuse: got %d %v, want 400 invalid_grant", status, body)
+			}
+			if p.refreshTokenActive(t, current) {
+				t.Error("latest refresh token of the family is still active")
+			}
+			if status, body := p.refresh(t, current, ""); status != http.StatusBadRequest {
+				t.Errorf("latest refresh token after reuse: got %d %v, want 400", status, body)
+			}
+			for i, accessToken := range accessTokens {
+				if p.accessTokenActive(t, accessToken) {
+					t.Errorf("access token %d of the family is still active", i)
+				}
+			}
+		})
+	}
+}
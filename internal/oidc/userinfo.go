@@ -0,0 +1,97 @@
+package oidc
+
+import (
+	"context"
+	"database/sql"
+	"log/slog"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/lescuer97/nostr-oicd/internal/config"
+	"github.com/lescuer97/nostr-oicd/internal/models"
+	"github.com/lescuer97/nostr-oicd/internal/profile"
+)
+
+// profileMaxAge is how old a stored profile may get before it is refreshed from relays.
+const profileMaxAge = 24 * time.Hour
+
+// profileClaims maps kind-0 metadata to OIDC claims for the granted scopes (OIDC Core section 5.4).
+// Anyone can put any identifier in their metadata, so nip05 is the verified identifier, and
+// nip05 and email are left out until its domain confirmed it names the user's key.
+func profileClaims(m *profile.Metadata, nip05 string, updatedAt time.Time, scopes []string) map[string]any {
+	claims := map[string]any{}
+	set := func(name, value string) {
+		if value != "" {
+			claims[name] = value
+		}
+	}
+	if hasScope(scopes, "profile") {
+		set("name", m.Name)
+		set("preferred_username", m.DisplayName)
+		set("picture", m.Picture)
+		set("website", m.Website)
+		set("about", m.About)
+		set("nip05", nip05)
+		claims["updated_at"] = updatedAt.Unix()
+	}
+	if hasScope(scopes, "email") && nip05 != "" {
+		// NIP-05 identifiers look like addresses but are not mailboxes, so they are never verified email
+		claims["email"] = nip05
+		claims["email_verified"] = false
+	}
+	return claims
+}
+
+// loadProfile returns the user's stored profile metadata, fetching it from relays when none is
+// stored yet and refreshing a stale one in the background. It returns nil if no profile is known.
+func loadProfile(ctx context.Context, cfg *config.Config, db *sql.DB, user *models.User) *models.Profile {
+	p, err := models.GetProfile(ctx, db, user.ID)
+	if err == sql.ErrNoRows {
+		if err := profile.Refresh(ctx, db, cfg.NostrRelays, user.ID, user.PublicKey); err != nil {
+			slog.Warn("oidc_profile_fetch_failed", "user_id", user.ID, "error", err.Error())
+			return nil
+		}
+		p, err = models.GetProfile(ctx, db, user.ID)
+	}
+	if err != nil {
+		return nil
+	}
+	if time.Since(p.FetchedAt) > profileMaxAge {
+		go func() {
+			if err := profile.Refresh(context.Background(), db, cfg.NostrRelays, user.ID, user.PublicKey); err != nil {
+				slog.Warn("oidc_profile_refresh_failed", "user_id", user.ID, "error", err.Error())
+			}
+		}()
+	}
+	return p
+}
+
+// UserInfoHandler returns claims about the user the bearer access token was issued for,
+// including those requested for userinfo with the claims parameter. Requires BearerAuth.
+func UserInfoHandler(cfg *config.Config, db *sql.DB) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		at, ok := r.Context().Value(ContextAccessTokenKey).(*models.AccessToken)
+		if !ok {
+			writeBearerError(w, http.StatusUnauthorized, "", "missing bearer token")
+			return
+		}
+		scopes := strings.Fields(at.Scope)
+		if at.UserID == nil || !hasScope(scopes, "openid") {
+			writeBearerError(w, http.StatusForbidden, "insufficient_scope", "access token was not issued for openid")
+			return
+		}
+		user, err := models.GetUserByID(r.Context(), db, *at.UserID)
+		if err != nil {
+			writeBearerError(w, http.StatusUnauthorized, "invalid_token", "user no longer exists")
+			return
+		}
+		client, err := models.GetClientByClientID(r.Context(), db, at.ClientID)
+		if err != nil {
+			writeBearerError(w, http.StatusUnauthorized, "invalid_token", "client no longer exists")
+			return
+		}
+
+		writeJSON(w, http.StatusOK, userClaims(r.Context(), cfg, db, client, user, scopes, storedClaimsRequest(at.Claims), claimsForUserInfo))
+	}
+}
@@ -0,0 +1,52 @@
+package oidc
+
+import (
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/hex"
+
+	"github.com/lescuer97/nostr-oicd/internal/config"
+)
+
+// generateRandomToken returns a hex token of nBytes length (2*n hex chars)
+func generateRandomToken(nBytes int) (string, error) {
+	b := make([]byte, nBytes)
+	if _, err := rand.Read(b); err != nil {
+		return "", err
+	}
+	return hex.EncodeToString(b), nil
+}
+
+// internalKey returns the server secret for values only this provider verifies:
+// SESSION_SIGNING_KEY, falling back to JWT_SECRET.
+func internalKey(cfg *config.Config) []byte {
+	if cfg.SessionSigningKey != "" {
+		return []byte(cfg.SessionSigningKey)
+	}
+	return []byte(cfg.JWTSecret)
+}
+
+// hashToken returns the hex HMAC-SHA256 of an opaque token (codes, access tokens, ...).
+// It uses the same key as session tokens (see internalKey).
+func hashToken(cfg *config.Config, token string) string {
+	h := hmac.New(sha256.New, internalKey(cfg))
+	h.Write([]byte(token))
+	return hex.EncodeToString(h.Sum(nil))
+}
+
+// hasScope reports whether scope is present in scopes.
+func hasScope(scopes []string, scope string) bool {
+	for _, s := range scopes {
+		if s == scope {
+			return true
+		}
+	}
+	return false
+}
+
+// HashClientSecret returns the value stored in clients.client_secret_hash for secret,
+// matching what the token endpoint compares against.
+func HashClientSecret(cfg *config.Config, secret string) string {
+	return hashToken(cfg, secret)
+}
@@ -0,0 +1,96 @@
+// Package outbound sends the HTTP requests the provider makes to URLs chosen by clients and
+// users: client documents, logout notifications and NIP-05 lookups. It only connects to public
+// addresses, so those URLs cannot be used to reach the provider's own host or network.
+package outbound
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"net/netip"
+	"syscall"
+	"time"
+)
+
+// nonPublicRanges are special-purpose ranges not covered by the netip.Addr predicates used in
+// IsPublicAddr: "this network", carrier-grade NAT, benchmarking and reserved IPv4 addresses.
+var nonPublicRanges = []netip.Prefix{
+	netip.MustParsePrefix("0.0.0.0/8"),
+	netip.MustParsePrefix("100.64.0.0/10"),
+	netip.MustParsePrefix("198.18.0.0/15"),
+	netip.MustParsePrefix("240.0.0.0/4"),
+}
+
+// IsPublicAddr reports whether addr is a globally routable unicast address.
+func IsPublicAddr(addr netip.Addr) bool {
+	addr = addr.Unmap()
+	if !addr.IsGlobalUnicast() || addr.IsPrivate() {
+		return false
+	}
+	for _, p := range nonPublicRanges {
+		if p.Contains(addr) {
+			return false
+		}
+	}
+	return true
+}
+
+// dialer only connects to public addresses. The check runs on the address being dialed, after
+// name resolution, so a host name that resolves to an internal address is refused too.
+var dialer = &net.Dialer{
+	Timeout: 5 * time.Second,
+	Control: func(network, address string, _ syscall.RawConn) error {
+		ap, err := netip.ParseAddrPort(address)
+		if err != nil || !IsPublicAddr(ap.Addr()) {
+			return fmt.Errorf("refusing to connect to non-public address %s", address)
+		}
+		return nil
+	},
+}
+
+// Transport sends requests through dialer and never through a proxy, which would connect on
+// the provider's behalf without the check.
+var Transport = &http.Transport{
+	DialContext:         dialer.DialContext,
+	ForceAttemptHTTP2:   true,
+	MaxIdleConns:        100,
+	IdleConnTimeout:     90 * time.Second,
+	TLSHandshakeTimeout: 5 * time.Second,
+}
+
+// NewClient returns a client that uses Transport and does not follow redirects: only the URL
+// that was checked is trusted, not wherever it redirects to.
+func NewClient(timeout time.Duration) *http.Client {
+	return &http.Client{
+		Transport:     Transport,
+		Timeout:       timeout,
+		CheckRedirect: func(*http.Request, []*http.Request) error { return http.ErrUseLastResponse },
+	}
+}
+
+// Get GETs uri with client and returns the body, which must be 200 OK and at most limit bytes.
+func Get(ctx context.Context, client *http.Client, uri string, limit int64) ([]byte, error) {
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, uri, nil)
+	if err != nil {
+		return nil, err
+	}
+	resp, err := client.Do(req)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("unexpected status %d", resp.StatusCode)
+	}
+	body, err := io.ReadAll(io.LimitReader(resp.Body, limit+1))
+	if err != nil {
+		return nil, err
+	}
+	if int64(len(body)) > limit {
+		return nil, errors.New("document is too large")
+	}
+	return body, nil
+}
@@ -0,0 +1,164 @@
+package profile
+
+import (
+	"context"
+	"database/sql"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/url"
+	"time"
+
+	"github.com/lescuer97/nostr-oicd/internal/models"
+	"github.com/lescuer97/nostr-oicd/internal/outbound"
+	"github.com/nbd-wtf/go-nostr"
+	"github.com/nbd-wtf/go-nostr/nip05"
+)
+
+const (
+	// fetchTimeout bounds how long relays are queried for a profile.
+	fetchTimeout = 5 * time.Second
+	// nip05Timeout bounds the lookup of a NIP-05 identifier at its domain.
+	nip05Timeout = 5 * time.Second
+	// maxNIP05Document bounds the nostr.json document read from a NIP-05 domain.
+	maxNIP05Document = 64 << 10
+)
+
+// nip05Client looks up NIP-05 identifiers. Identifiers come from login hints and profiles, so
+// their domains may only be public hosts.
+var nip05Client = outbound.NewClient(nip05Timeout)
+
+// ErrNotFound is returned by Refresh when no relay has a kind-0 event for the user.
+var ErrNotFound = errors.New("profile metadata not found on relays")
+
+// Metadata is the content of a kind-0 profile metadata event (NIP-01, NIP-24).
+type Metadata struct {
+	Name        string `json:"name,omitempty"`
+	DisplayName string `json:"display_name,omitempty"`
+	Picture     string `json:"picture,omitempty"`
+	About       string `json:"about,omitempty"`
+	Website     string `json:"website,omitempty"`
+	NIP05       string `json:"nip05,omitempty"`
+	Banner      string `json:"banner,omitempty"`
+	LUD16       string `json:"lud16,omitempty"`
+}
+
+// Parse decodes the stored event content. Unknown fields are ignored.
+func Parse(p *models.Profile) (*Metadata, error) {
+	var m Metadata
+	if err := json.Unmarshal([]byte(p.Content), &m); err != nil {
+		return nil, err
+	}
+	if m.DisplayName == "" {
+		// some clients still write the deprecated camelCase key
+		var legacy struct {
+			DisplayName string `json:"displayName"`
+		}
+		_ = json.Unmarshal([]byte(p.Content), &legacy)
+		m.DisplayName = legacy.DisplayName
+	}
+	return &m, nil
+}
+
+// Save verifies that ev is a kind-0 event signed by pubkey and stores it for the user
+// unless a newer one is already saved.
+func Save(ctx context.Context, db *sql.DB, userID int64, pubkey string, ev *nostr.Event) error {
+	if ev.Kind != nostr.KindProfileMetadata {
+		return errors.New("not a kind-0 event")
+	}
+	if ev.PubKey != pubkey {
+		return errors.New("event is not authored by the user")
+	}
+	ok, err := ev.CheckSignature()
+	if err != nil || !ok {
+		return errors.New("invalid event signature")
+	}
+	var m Metadata
+	if err := json.Unmarshal([]byte(ev.Content), &m); err != nil {
+		return fmt.Errorf("invalid metadata content: %w", err)
+	}
+	return models.SaveProfile(ctx, db, &models.Profile{
+		UserID:         userID,
+		EventID:        ev.ID,
+		Content:        ev.Content,
+		EventCreatedAt: ev.CreatedAt.Time(),
+	})
+}
+
+// Fetch queries relays for the newest kind-0 event of pubkey.
+func Fetch(ctx context.Context, relays []string, pubkey string) (*nostr.Event, error) {
+	if len(relays) == 0 {
+		return nil, ErrNotFound
+	}
+	ctx, cancel := context.WithTimeout(ctx, fetchTimeout)
+	defer cancel()
+
+	pool := nostr.NewSimplePool(ctx)
+	defer pool.Close("profile fetched")
+
+	var newest *nostr.Event
+	filter := nostr.Filter{Kinds: []int{nostr.KindProfileMetadata}, Authors: []string{pubkey}, Limit: 1}
+	for ie := range pool.FetchMany(ctx, relays, filter) {
+		if newest == nil || ie.Event.CreatedAt > newest.CreatedAt {
+			newest = ie.Event
+		}
+	}
+	if newest == nil {
+		return nil, ErrNotFound
+	}
+	return newest, nil
+}
+
+// Refresh fetches the user's profile from relays and saves it.
+func Refresh(ctx context.Context, db *sql.DB, relays []string, userID int64, pubkey string) error {
+	ev, err := Fetch(ctx, relays, pubkey)
+	if err != nil {
+		return err
+	}
+	return Save(ctx, db, userID, pubkey, ev)
+}
+
+// LookupNIP05 returns the hex public key the domain of a NIP-05 identifier names for it.
+func LookupNIP05(ctx context.Context, identifier string) (string, error) {
+	name, domain, err := nip05.ParseIdentifier(identifier)
+	if err != nil {
+		return "", err
+	}
+	ctx, cancel := context.WithTimeout(ctx, nip05Timeout)
+	defer cancel()
+	uri := (&url.URL{Scheme: "https", Host: domain, Path: "/.well-known/nostr.json", RawQuery: url.Values{"name": {name}}.Encode()}).String()
+	body, err := outbound.Get(ctx, nip05Client, uri, maxNIP05Document)
+	if err != nil {
+		return "", err
+	}
+	var doc nip05.WellKnownResponse
+	if err := json.Unmarshal(body, &doc); err != nil {
+		return "", errors.New("invalid nostr.json document")
+	}
+	pubkey := doc.Names[name]
+	if !nostr.IsValidPublicKey(pubkey) {
+		return "", fmt.Errorf("no valid public key for %q", name)
+	}
+	return pubkey, nil
+}
+
+// VerifyNIP05 looks up the NIP-05 identifier of the stored profile p at its domain and records
+// it as verified if the domain names pubkey, or as unverified otherwise. It returns the verified
+// identifier, or "" if the profile has none or it did not check out.
+func VerifyNIP05(ctx context.Context, db *sql.DB, p *models.Profile, pubkey string) (string, error) {
+	m, err := Parse(p)
+	if err != nil {
+		return "", err
+	}
+	verified := ""
+	if nip05.IsValidIdentifier(m.NIP05) {
+		found, err := LookupNIP05(ctx, m.NIP05)
+		if err == nil && found == pubkey {
+			verified = m.NIP05
+		}
+	}
+	if err := models.SetProfileNIP05(ctx, db, p.UserID, verified); err != nil {
+		return "", err
+	}
+	return verified, nil
+}
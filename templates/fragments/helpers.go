@@ -0,0 +1,47 @@
+package fragments
+
+import (
+	"strconv"
+	"time"
+
+	"github.com/lescuer97/nostr-oicd/internal/models"
+	"github.com/nbd-wtf/go-nostr/nip19"
+)
+
+// ttlSeconds renders a lifetime as whole seconds for number inputs; zero (provider default) renders empty.
+func ttlSeconds(d time.Duration) string {
+	if d <= 0 {
+		return ""
+	}
+	return strconv.FormatInt(int64(d.Seconds()), 10)
+}
+
+// contains reports whether v is in list.
+func contains(list []string, v string) bool {
+	for _, s := range list {
+		if s == v {
+			return true
+		}
+	}
+	return false
+}
+
+// logoutStatusClass colours a back-channel logout delivery status.
+func logoutStatusClass(status string) string {
+	switch status {
+	case models.LogoutDelivered:
+		return "text-green-600"
+	case models.LogoutFailed:
+		return "text-red-600"
+	default:
+		return "text-yellow-600"
+	}
+}
+
+// npub renders a hex public key as its bech32 npub, falling back to the hex key.
+func npub(pubkey string) string {
+	if s, err := nip19.EncodePublicKey(pubkey); err == nil {
+		return s
+	}
+	return pubkey
+}
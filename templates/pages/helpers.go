@@ -0,0 +1,56 @@
+package pages
+
+import (
+	"net/url"
+	"sort"
+
+	"github.com/nbd-wtf/go-nostr/nip19"
+)
+
+// scopeDescriptions explains scopes on the consent page.
+var scopeDescriptions = map[string]string{
+	"openid":         "Sign you in with your Nostr public key",
+	"profile":        "Read your Nostr profile: name, picture, website, about and NIP-05 address",
+	"email":          "Read your NIP-05 address as an unverified email address",
+	"offline_access": "Stay signed in while you are away",
+	"npub":           "Read your Nostr public key, which lets the app recognize you across other apps",
+}
+
+// scopeDescription returns a human readable description of scope, or the scope itself.
+func scopeDescription(scope string) string {
+	if d, ok := scopeDescriptions[scope]; ok {
+		return d
+	}
+	return scope
+}
+
+// hintPubkey returns the hex public key of the npub login hint, or "" if it is not one.
+func hintPubkey(npub string) string {
+	prefix, value, err := nip19.Decode(npub)
+	if err != nil || prefix != "npub" {
+		return ""
+	}
+	pubkey, _ := value.(string)
+	return pubkey
+}
+
+// formField is a hidden field of an auto-submitting form.
+type formField struct {
+	Name, Value string
+}
+
+// formFields flattens values into form fields, sorted by name.
+func formFields(values url.Values) []formField {
+	names := make([]string, 0, len(values))
+	for name := range values {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	var fields []formField
+	for _, name := range names {
+		for _, v := range values[name] {
+			fields = append(fields, formField{name, v})
+		}
+	}
+	return fields
+}